@@ -3,6 +3,7 @@ package bencode
 import (
 	"bufio"
 	"io"
+	"slices"
 )
 
 type BType uint8
@@ -19,6 +20,7 @@ type BValue any
 type BObject struct {
 	type_ BType
 	val_  BValue
+	raw_  []byte // exact input bytes, only kept when decoding for Unmarshal
 }
 
 func (o *BObject) Str() (string, error) {
@@ -49,45 +51,74 @@ func (o *BObject) Dict() (map[string]*BObject, error) {
 	return o.val_.(map[string]*BObject), nil
 }
 
+// Bencode writes the canonical encoding of o to w and returns the number of
+// bytes written, or 0 if writing failed.
 func (o *BObject) Bencode(w io.Writer) int {
-	bw, ok := w.(*bufio.Writer)
-	var err error
-	nLen := 0
-	if !ok {
-		bw = bufio.NewWriter(w)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	*buf = o.appendTo(*buf)
+	n, err := writeBuffer(w, *buf)
+	if err != nil {
+		return 0
 	}
-	wLen := 0
+	if bw, ok := w.(*bufio.Writer); ok && bw.Flush() != nil {
+		return 0
+	}
+	return n
+}
+
+func (o *BObject) appendTo(dst []byte) []byte {
 	switch o.type_ {
 	case BSTR:
 		str, _ := o.Str()
-		nLen, err = EncodeString(bw, str)
-		wLen += nLen
+		dst = appendString(dst, str)
 	case BINT:
 		val, _ := o.Int()
-		nLen, err = EncodeInt(bw, val)
-		wLen += nLen
+		dst = appendInt(dst, val)
 	case BLIST:
-		err = bw.WriteByte('l')
+		dst = append(dst, 'l')
 		list, _ := o.List()
 		for _, elem := range list {
-			wLen += elem.Bencode(bw)
+			dst = elem.appendTo(dst)
 		}
-		_ = bw.WriteByte('e')
-		wLen += 2
+		dst = append(dst, 'e')
 	case BDICT:
-		err = bw.WriteByte('d')
+		dst = append(dst, 'd')
 		dict, _ := o.Dict()
-		for k, v := range dict {
-			nLen, err = EncodeString(bw, k)
-			wLen += nLen
-			wLen += v.Bencode(bw)
+		// BEP 3 requires dictionary keys in sorted order
+		keys := make([]string, 0, len(dict))
+		for k := range dict {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			dst = appendString(dst, k)
+			dst = dict[k].appendTo(dst)
 		}
-		err = bw.WriteByte('e')
-		wLen += 2
+		dst = append(dst, 'e')
 	}
-	err = bw.Flush()
-	if err != nil {
-		return 0
+	return dst
+}
+
+func NewString(val string) *BObject {
+	return &BObject{type_: BSTR, val_: val}
+}
+
+func NewInt(val int) *BObject {
+	return &BObject{type_: BINT, val_: val}
+}
+
+func NewList(list []*BObject) *BObject {
+	return &BObject{type_: BLIST, val_: list}
+}
+
+func NewDict(dict map[string]*BObject) *BObject {
+	if dict == nil {
+		dict = make(map[string]*BObject)
 	}
-	return wLen
+	return &BObject{type_: BDICT, val_: dict}
+}
+
+func (o *BObject) Type() BType {
+	return o.type_
 }
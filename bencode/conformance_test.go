@@ -0,0 +1,74 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Edge cases taken from the encoding rules in BEP 3. Valid inputs must
+// re-encode to want; invalid inputs must be rejected with an error.
+func TestConformance(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr error
+	}{
+		{name: "empty string", in: "0:", want: "0:"},
+		{name: "binary string", in: "3:\x00\xff\n", want: "3:\x00\xff\n"},
+		{name: "string with colon", in: "5:a:b:c", want: "5:a:b:c"},
+		{name: "zero", in: "i0e", want: "i0e"},
+		{name: "negative", in: "i-3e", want: "i-3e"},
+		{name: "max int", in: "i9223372036854775807e", want: "i9223372036854775807e"},
+		{name: "min int plus one", in: "i-9223372036854775807e", want: "i-9223372036854775807e"},
+		{name: "empty list", in: "le", want: "le"},
+		{name: "nested list", in: "llelee", want: "llelee"},
+		{name: "empty dict", in: "de", want: "de"},
+		{name: "dict", in: "d3:cow3:moo4:spam4:eggse", want: "d3:cow3:moo4:spam4:eggse"},
+		{name: "dict of list", in: "d4:spaml1:a1:bee", want: "d4:spaml1:a1:bee"},
+		{name: "unsorted keys are re-encoded sorted", in: "d1:bi2e1:ai1ee", want: "d1:ai1e1:bi2ee"},
+		{name: "raw byte key order", in: "d1:a0:1:B0:e", want: "d1:B0:1:a0:e"},
+		{name: "trailing data ignored", in: "i1eXYZ", want: "i1e"},
+
+		{name: "negative zero", in: "i-0e", wantErr: ErrInvalidNum},
+		{name: "leading zero", in: "i03e", wantErr: ErrInvalidNum},
+		{name: "negative leading zero", in: "i-03e", wantErr: ErrInvalidNum},
+		{name: "empty int", in: "ie", wantErr: ErrNum},
+		{name: "sign only", in: "i-e", wantErr: ErrNum},
+		{name: "int overflow", in: "i9223372036854775808e", wantErr: ErrOverflow},
+		{name: "int missing end", in: "i12", wantErr: ErrExpCharE},
+		{name: "float", in: "i1.5e", wantErr: ErrExpCharE},
+		{name: "negative string length", in: "-1:a", wantErr: ErrType},
+		{name: "leading zero string length", in: "01:a", wantErr: ErrInvalidNum},
+		{name: "string length overflow", in: "99999999999999999999:a", wantErr: ErrOverflow},
+		{name: "short string", in: "5:abc"},
+		{name: "huge string length", in: "9999999999:abc"},
+		{name: "missing colon", in: "3abc", wantErr: ErrColon},
+		{name: "unterminated list", in: "l1:a"},
+		{name: "unterminated dict", in: "d1:a1:b"},
+		{name: "dict missing value", in: "d1:ae", wantErr: ErrType},
+		{name: "non-string key", in: "di1ei2ee", wantErr: ErrNum},
+		{name: "unknown type", in: "x", wantErr: ErrType},
+		{name: "empty input"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := Parse(bytes.NewBufferString(tc.in))
+			if tc.want == "" {
+				require.Error(t, err)
+				if tc.wantErr != nil {
+					assert.Equal(t, tc.wantErr, err)
+				}
+				return
+			}
+			require.NoError(t, err)
+			out := new(bytes.Buffer)
+			assert.Equal(t, len(tc.want), o.Bencode(out))
+			assert.Equal(t, tc.want, out.String())
+		})
+	}
+}
@@ -1,20 +1,73 @@
 package bencode
 
 import (
-	"bufio"
+	"errors"
 	"io"
+	"math"
+	"reflect"
 )
 
+// maxPrealloc caps the buffer reserved up front for a string, so a forged
+// length prefix cannot allocate more memory than the input actually holds.
+const maxPrealloc = 64 << 10
+
+// Decoder reads successive bencoded values from a stream into Go values.
+type Decoder struct {
+	s        *scanner
+	naming   NamingStrategy
+	foldKeys bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{s: newScanner(r, true)}
+}
+
+// SetKeyNaming sets how keys are derived for struct fields without a tag
+// name. The default is LowerNames.
+func (d *Decoder) SetKeyNaming(naming NamingStrategy) {
+	d.naming = naming
+}
+
+// SetCaseInsensitive makes struct fields also match dictionary keys that
+// differ from the field key only in case.
+func (d *Decoder) SetCaseInsensitive(fold bool) {
+	d.foldKeys = fold
+}
+
+// Decode reads the next value from the stream and stores it in the value
+// src points to. Decoding merges into what is already there: struct fields
+// and map entries missing from the input are kept, and slices reuse their
+// existing elements.
+func (d *Decoder) Decode(src any) error {
+	p := reflect.ValueOf(src)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return errors.New("dest must be a pointer")
+	}
+	d.s.buf = d.s.buf[:0]
+	o, err := parse(d.s)
+	if err != nil {
+		return err
+	}
+	return d.unmarshalValue(p.Elem(), o)
+}
+
 func DecodeString(r io.Reader) (val string, err error) {
-	br, ok := r.(*bufio.Reader)
-	if !ok {
-		br = bufio.NewReader(r)
+	return decodeString(newScanner(r, false))
+}
+
+func DecodeInt(r io.Reader) (val int, err error) {
+	return decodeInt(newScanner(r, false))
+}
+
+func decodeString(s *scanner) (val string, err error) {
+	num, _, err := readDecimal(s)
+	if err == ErrOverflow || err == ErrInvalidNum {
+		return val, err
 	}
-	num, len_ := readDecimal(br)
-	if len_ == 0 {
+	if err != nil || num < 0 {
 		return val, ErrNum
 	}
-	b, err := br.ReadByte()
+	b, err := s.ReadByte()
 	if err != nil {
 		return "", err
 	}
@@ -22,30 +75,38 @@ func DecodeString(r io.Reader) (val string, err error) {
 		return val, ErrColon
 	}
 
-	buf := make([]byte, num)
-	_, err = io.ReadAtLeast(br, buf, num)
-	if err != nil {
-		return "", err
+	buf := make([]byte, 0, min(num, maxPrealloc))
+	for len(buf) < num {
+		chunk := min(num-len(buf), maxPrealloc)
+		buf = append(buf, make([]byte, chunk)...)
+		if _, err = io.ReadFull(s, buf[len(buf)-chunk:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
 	}
 	val = string(buf)
 	return
 }
 
-func DecodeInt(r io.Reader) (val int, err error) {
-	br, ok := r.(*bufio.Reader)
-	if !ok {
-		br = bufio.NewReader(r)
-	}
-	b, err := br.ReadByte()
+func decodeInt(s *scanner) (val int, err error) {
+	b, err := s.ReadByte()
 	if err != nil {
 		return 0, ErrReadFailed
 	}
 	if b != 'i' {
 		return val, ErrExpCharI
 	}
-	val, _ = readDecimal(br)
-	b, err = br.ReadByte()
-	if b != 'e' {
+	val, _, err = readDecimal(s)
+	if err == ErrOverflow || err == ErrInvalidNum {
+		return 0, err
+	}
+	if err != nil {
+		return 0, ErrNum
+	}
+	b, err = s.ReadByte()
+	if err != nil || b != 'e' {
 		return val, ErrExpCharE
 	}
 	return
@@ -55,35 +116,56 @@ func checkNum(data byte) bool {
 	return data >= '0' && data <= '9'
 }
 
-func readDecimal(r *bufio.Reader) (val int, len int) {
-	sign := 1
+// readDecimal reads an optionally signed decimal number and leaves the first
+// byte after it unread. Only the canonical form is accepted: no leading
+// zeros, no "-0" and no value outside the range of int.
+func readDecimal(r io.ByteScanner) (val int, len int, err error) {
+	neg := false
 	b, err := r.ReadByte()
 	if err != nil {
-		return 0, 0
+		return 0, 0, err
 	}
-	len++
 	if b == '-' {
-		sign = -1
+		neg = true
+		len++
 		b, err = r.ReadByte()
 		if err != nil {
-			return 0, 0
+			return 0, 0, err
 		}
-		len++
 	}
-	for {
-		if !checkNum(b) {
-			err := r.UnreadByte()
-			if err != nil {
-				return 0, 0
-			}
-			len--
-			return sign * val, len
+	digits := 0
+	for checkNum(b) {
+		if digits == 1 && val == 0 {
+			return 0, 0, ErrInvalidNum
+		}
+		d := int(b - '0')
+		if val > (math.MaxInt-d)/10 {
+			return 0, 0, ErrOverflow
 		}
-		val = val*10 + int(b-'0')
+		val = val*10 + d
+		digits++
+		len++
 		b, err = r.ReadByte()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return 0, 0
+			return 0, 0, err
 		}
-		len++
 	}
+	if err == nil {
+		if err = r.UnreadByte(); err != nil {
+			return 0, 0, err
+		}
+	}
+	if digits == 0 {
+		return 0, 0, ErrNum
+	}
+	if neg {
+		if val == 0 {
+			return 0, 0, ErrInvalidNum
+		}
+		val = -val
+	}
+	return val, len, nil
 }
@@ -1,100 +1,95 @@
 package bencode
 
 import (
-	"bufio"
 	"io"
+	"strconv"
+	"sync"
 )
 
-func EncodeString(w io.Writer, val string) (int, error) {
-	strLen := len(val)
-	bw := bufio.NewWriter(w)
-	wLen, err := writeDecimal(bw, strLen)
-	if err != nil {
-		return 0, err
-	}
-	err = bw.WriteByte(':')
-	if err != nil {
-		return 0, ErrWriteFailed
-	}
-	wLen++
-	_, err = bw.WriteString(val)
-	if err != nil {
-		return 0, ErrWriteFailed
+// Encoder writes Go values as bencode to a stream.
+type Encoder struct {
+	w      io.Writer
+	naming NamingStrategy
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetKeyNaming sets how keys are derived for struct fields without a tag
+// name. The default is LowerNames.
+func (e *Encoder) SetKeyNaming(naming NamingStrategy) {
+	e.naming = naming
+}
+
+func (e *Encoder) Encode(v any) error {
+	_, err := e.marshal(v)
+	return err
+}
+
+// maxPooledBuffer keeps buffers grown by unusually large values from being
+// pinned in the pool.
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+func putBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledBuffer {
+		return
 	}
-	wLen += strLen
+	*buf = (*buf)[:0]
+	bufferPool.Put(buf)
+}
 
-	err = bw.Flush()
+func writeBuffer(w io.Writer, b []byte) (int, error) {
+	n, err := w.Write(b)
 	if err != nil {
 		return 0, ErrWriteFailed
 	}
+	return n, nil
+}
 
-	return wLen, nil
+func EncodeString(w io.Writer, val string) (int, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	*buf = appendString(*buf, val)
+	return writeBuffer(w, *buf)
 }
 
 func EncodeInt(w io.Writer, val int) (int, error) {
-	bw := bufio.NewWriter(w)
-	wLen := 0
-	err := bw.WriteByte('i')
-	if err != nil {
-		return 0, err
-	}
-	wLen++
-	nLen, err := writeDecimal(bw, val)
-	if err != nil {
-		return 0, err
-	}
-	wLen += nLen
-	err = bw.WriteByte('e')
-	if err != nil {
-		return 0, ErrWriteFailed
-	}
-	wLen++
+	buf := getBuffer()
+	defer putBuffer(buf)
+	*buf = appendInt(*buf, val)
+	return writeBuffer(w, *buf)
+}
 
-	err = bw.Flush()
-	if err != nil {
-		return 0, ErrWriteFailed
-	}
+func appendString(dst []byte, val string) []byte {
+	dst = strconv.AppendInt(dst, int64(len(val)), 10)
+	dst = append(dst, ':')
+	return append(dst, val...)
+}
 
-	return wLen, nil
+func appendInt(dst []byte, val int) []byte {
+	return appendInt64(dst, int64(val))
 }
 
-func writeDecimal(w *bufio.Writer, val int) (len int, err error) {
-	if val == 0 {
-		err = w.WriteByte('0')
-		if err != nil {
-			return 0, ErrWriteFailed
-		}
-		len++
-		return
-	}
-	if val < 0 {
-		err = w.WriteByte('-')
-		if err != nil {
-			return 0, ErrWriteFailed
-		}
-		len++
-		val = -val
-	}
+func appendInt64(dst []byte, val int64) []byte {
+	dst = append(dst, 'i')
+	dst = strconv.AppendInt(dst, val, 10)
+	return append(dst, 'e')
+}
 
-	dividend := 1
-	for {
-		if dividend > val {
-			dividend /= 10
-			break
-		}
-		dividend *= 10
-	}
-	for {
-		num := byte(val / dividend)
-		err = w.WriteByte('0' + num)
-		if err != nil {
-			return 0, ErrWriteFailed
-		}
-		len++
-		if dividend == 1 {
-			return
-		}
-		val %= dividend
-		dividend /= 10
-	}
+func appendUint64(dst []byte, val uint64) []byte {
+	dst = append(dst, 'i')
+	dst = strconv.AppendUint(dst, val, 10)
+	return append(dst, 'e')
 }
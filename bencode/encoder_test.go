@@ -0,0 +1,148 @@
+package bencode
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type krpcArgs struct {
+	ID string `bencode:"id"`
+}
+
+type krpcQuery struct {
+	A krpcArgs `bencode:"a"`
+	Q string   `bencode:"q"`
+	T string   `bencode:"t"`
+	Y string   `bencode:"y"`
+}
+
+type extHandshake struct {
+	M    map[string]int `bencode:"m"`
+	P    int            `bencode:"p"`
+	V    string         `bencode:"v"`
+	Reqq int            `bencode:"reqq"`
+}
+
+var (
+	nodeID = strings.Repeat("\xab", 20)
+	ping   = &krpcQuery{A: krpcArgs{ID: nodeID}, Q: "ping", T: "aa", Y: "q"}
+	hs     = &extHandshake{
+		M: map[string]int{"ut_metadata": 1, "ut_pex": 2},
+		P: 6881,
+		V: "go-bittorrent 0.1",
+	}
+	pingObject = NewDict(map[string]*BObject{
+		"a": NewDict(map[string]*BObject{"id": NewString(nodeID)}),
+		"q": NewString("ping"),
+		"t": NewString("aa"),
+		"y": NewString("q"),
+	})
+)
+
+func TestAppendBencode(t *testing.T) {
+	out, err := AppendBencode([]byte("prefix:"), ping)
+	require.NoError(t, err)
+	want := "prefix:d1:ad2:id20:" + nodeID + "e1:q4:ping1:t2:aa1:y1:qe"
+	assert.Equal(t, want, string(out))
+
+	out, err = AppendBencode(nil, pingObject)
+	require.NoError(t, err)
+	assert.Equal(t, "d1:ad2:id20:"+nodeID+"e1:q4:ping1:t2:aa1:y1:qe", string(out))
+
+	buf := new(bytes.Buffer)
+	n, err := Marshal(buf, pingObject)
+	require.NoError(t, err)
+	assert.Equal(t, string(out), buf.String())
+	assert.Equal(t, len(out), n)
+}
+
+func TestEncodeAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector makes pooled buffers allocate")
+	}
+	dst := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = AppendBencode(dst[:0], ping)
+	})
+	assert.Zero(t, allocs, "AppendBencode of a struct")
+
+	allocs = testing.AllocsPerRun(100, func() {
+		_, _ = Marshal(io.Discard, ping)
+	})
+	assert.Zero(t, allocs, "Marshal of a struct")
+
+	allocs = testing.AllocsPerRun(100, func() {
+		_, _ = EncodeString(io.Discard, nodeID)
+		_, _ = EncodeInt(io.Discard, 6881)
+	})
+	assert.Zero(t, allocs, "EncodeString and EncodeInt")
+
+	allocs = testing.AllocsPerRun(100, func() {
+		pingObject.Bencode(io.Discard)
+	})
+	assert.LessOrEqual(t, allocs, 2.0, "Bencode sorts keys of its two dictionaries")
+}
+
+func TestEncodeConcurrent(t *testing.T) {
+	want, err := AppendBencode(nil, hs)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				buf := new(bytes.Buffer)
+				_, err := Marshal(buf, hs)
+				assert.NoError(t, err)
+				assert.Equal(t, string(want), buf.String())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkAppendBencodePing(b *testing.B) {
+	b.ReportAllocs()
+	dst := make([]byte, 0, 1024)
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendBencode(dst[:0], ping)
+	}
+}
+
+func BenchmarkMarshalPing(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = Marshal(io.Discard, ping)
+	}
+}
+
+func BenchmarkMarshalPingParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = Marshal(io.Discard, ping)
+		}
+	})
+}
+
+func BenchmarkMarshalExtHandshake(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = Marshal(io.Discard, hs)
+	}
+}
+
+func BenchmarkBencodePing(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pingObject.Bencode(io.Discard)
+	}
+}
@@ -4,10 +4,13 @@ import "errors"
 
 var (
 	ErrNum         = errors.New("expect num")
+	ErrInvalidNum  = errors.New("non-canonical num")
+	ErrOverflow    = errors.New("num overflows int")
 	ErrColon       = errors.New("expect colon")
 	ErrExpCharI    = errors.New("expect char i")
 	ErrExpCharE    = errors.New("expect char e")
 	ErrType        = errors.New("wrong type")
 	ErrWriteFailed = errors.New("write failed")
 	ErrReadFailed  = errors.New("read failed")
+	ErrEmptyRaw    = errors.New("empty raw message")
 )
@@ -0,0 +1,95 @@
+package bencode
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RawMessage is a raw encoded bencode value. Unmarshal stores the exact
+// input bytes of a value in it and Marshal writes it back unchanged.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+type field struct {
+	index     int
+	key       string
+	extra     bool
+	omitEmpty bool
+}
+
+// defaultFields caches the fields of struct types under the default naming
+// strategy, which is what almost every caller uses.
+var defaultFields sync.Map // map[reflect.Type][]field
+
+// structFields lists the fields of struct type t that take part in
+// encoding, sorted by their key: the tag name or the name naming derives,
+// LowerNames when nil. A field tagged `bencode:",extra"` must be a map[string]RawMessage
+// and collects the dictionary keys no other field claims. With omitempty a
+// zero int or an empty string, list or dictionary is left out when encoding.
+func structFields(t reflect.Type, naming NamingStrategy) []field {
+	if naming != nil {
+		return typeFields(t, naming)
+	}
+	if fields, ok := defaultFields.Load(t); ok {
+		return fields.([]field)
+	}
+	fields, _ := defaultFields.LoadOrStore(t, typeFields(t, LowerNames))
+	return fields.([]field)
+}
+
+func typeFields(t reflect.Type, naming NamingStrategy) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		if !ft.IsExported() {
+			continue
+		}
+		tag := ft.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		key, opts, _ := strings.Cut(tag, ",")
+		f := field{index: i, key: key}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "extra":
+				f.extra = ft.Type.Kind() == reflect.Map &&
+					ft.Type.Key().Kind() == reflect.String &&
+					ft.Type.Elem() == rawMessageType
+			case "omitempty":
+				f.omitEmpty = true
+			}
+		}
+		if f.key == "" {
+			f.key = naming(ft.Name)
+		}
+		fields = append(fields, f)
+	}
+	slices.SortStableFunc(fields, func(a, b field) int { return strings.Compare(a.key, b.key) })
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	switch {
+	case isInt(v.Kind()):
+		return v.Int() == 0
+	case isUint(v.Kind()):
+		return v.Uint() == 0
+	}
+	return false
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
@@ -0,0 +1,93 @@
+package bencode
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fuzzTarget struct {
+	Announce string   `bencode:"announce"`
+	Date     int      `bencode:"creation date"`
+	Tiers    [][]int  `bencode:"tiers"`
+	Names    []string `bencode:"names"`
+	Members  []User   `bencode:"members"`
+	User     `bencode:"user"`
+}
+
+var fuzzSeeds = []string{
+	"0:", "3:abc", "i0e", "i-42e", "le", "de",
+	"li1ei2ee", "d1:ai1e1:bl1:cee", "lli1eeli2eee",
+	"i-0e", "i03e", "ie", "-1:a", "1:", "d1:ae", "l", "d", "x",
+	"i9223372036854775807e", "i9223372036854775808e",
+}
+
+func addSeedCorpus(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	files, err := filepath.Glob(filepath.Join("testdata", "torrents", "*.torrent"))
+	require.NoError(f, err)
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		require.NoError(f, err)
+		f.Add(data)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	addSeedCorpus(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		o, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		out := new(bytes.Buffer)
+		n := o.Bencode(out)
+		assert.Equal(t, out.Len(), n)
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	addSeedCorpus(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		o, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		first := new(bytes.Buffer)
+		o.Bencode(first)
+
+		again, err := Parse(bytes.NewReader(first.Bytes()))
+		require.NoError(t, err, "re-parse of %q", first.Bytes())
+		assert.Equal(t, o, again)
+
+		second := new(bytes.Buffer)
+		again.Bencode(second)
+		assert.Equal(t, first.Bytes(), second.Bytes())
+	})
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	addSeedCorpus(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v fuzzTarget
+		if err := Unmarshal(bytes.NewReader(data), &v); err != nil {
+			return
+		}
+		first := new(bytes.Buffer)
+		_, err := Marshal(first, &v)
+		require.NoError(t, err)
+
+		var again fuzzTarget
+		require.NoError(t, Unmarshal(bytes.NewReader(first.Bytes()), &again))
+		second := new(bytes.Buffer)
+		_, err = Marshal(second, &again)
+		require.NoError(t, err)
+		assert.Equal(t, first.String(), second.String())
+	})
+}
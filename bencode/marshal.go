@@ -3,102 +3,168 @@ package bencode
 import (
 	"io"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 )
 
+var bobjectType = reflect.TypeOf((*BObject)(nil))
+
 func Marshal(w io.Writer, s any) (int, error) {
+	return NewEncoder(w).marshal(s)
+}
+
+// AppendBencode appends the encoding of v to dst and returns the extended
+// slice. It does not allocate once dst has room for the result.
+func AppendBencode(dst []byte, v any) ([]byte, error) {
+	var e Encoder
+	return e.appendAny(dst, v)
+}
+
+func (e *Encoder) marshal(s any) (int, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	var err error
+	*buf, err = e.appendAny(*buf, s)
+	if err != nil {
+		return 0, err
+	}
+	return writeBuffer(e.w, *buf)
+}
+
+func (e *Encoder) appendAny(dst []byte, s any) ([]byte, error) {
 	v := reflect.ValueOf(s)
-	if v.Kind() == reflect.Ptr {
+	if v.Kind() == reflect.Ptr && v.Type() != bobjectType {
 		v = v.Elem()
 	}
-	return marshalValue(w, v)
+	return e.appendValue(dst, v)
 }
 
-func marshalValue(w io.Writer, v reflect.Value) (len int, err error) {
-	var tmpLen int
+// appendValue encodes strings and byte slices as strings, integers of any
+// size as integers, other slices as lists and structs and maps with string
+// keys as dictionaries. Anything else, nil included, is ErrType.
+func (e *Encoder) appendValue(dst []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return dst, ErrType
+	}
+	switch v.Type() {
+	case rawMessageType:
+		if v.Len() == 0 {
+			return dst, ErrEmptyRaw
+		}
+		return append(dst, v.Bytes()...), nil
+	case bobjectType:
+		return v.Interface().(*BObject).appendTo(dst), nil
+	}
+	switch k := v.Kind(); {
+	case isInt(k):
+		return appendInt64(dst, v.Int()), nil
+	case isUint(k):
+		return appendUint64(dst, v.Uint()), nil
+	}
 	switch v.Kind() {
 	case reflect.String:
-		tmpLen, err = EncodeString(w, v.String())
-		if err != nil {
-			return 0, err
-		}
-		len += tmpLen
-	case reflect.Int:
-		tmpLen, err = EncodeInt(w, int(v.Int()))
-		if err != nil {
-			return 0, err
-		}
-		len += tmpLen
+		return appendString(dst, v.String()), nil
 	case reflect.Slice:
-		tmpLen, err = marshalList(w, v)
-		if err != nil {
-			return 0, err
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			dst = strconv.AppendInt(dst, int64(v.Len()), 10)
+			dst = append(dst, ':')
+			return append(dst, v.Bytes()...), nil
 		}
-		len += tmpLen
+		return e.appendList(dst, v)
 	case reflect.Struct:
-		tmpLen, err = marshalDict(w, v)
-		if err != nil {
-			return 0, err
+		return e.appendDict(dst, v)
+	case reflect.Map:
+		return e.appendMap(dst, v)
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return dst, ErrType
 		}
-		len += tmpLen
-	default:
-		panic("unhandled default case")
+		return e.appendValue(dst, v.Elem())
 	}
-	return
+	return dst, ErrType
 }
 
-func marshalList(w io.Writer, v reflect.Value) (len int, err error) {
-	len = 2
-	var tmpLen int
-	_, err = w.Write([]byte("l"))
-	if err != nil {
-		return 0, err
-	}
+func (e *Encoder) appendList(dst []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	dst = append(dst, 'l')
 	for i := 0; i < v.Len(); i++ {
-		ev := v.Index(i)
-		tmpLen, err = marshalValue(w, ev)
+		dst, err = e.appendValue(dst, v.Index(i))
 		if err != nil {
-			return 0, err
+			return dst, err
 		}
-		len += tmpLen
-	}
-	_, err = w.Write([]byte{'e'})
-	if err != nil {
-		return 0, err
 	}
-	return
+	return append(dst, 'e'), nil
 }
 
-func marshalDict(w io.Writer, v reflect.Value) (len int, err error) {
-	len = 2
-	var tmpLen int
-	_, err = w.Write([]byte("d"))
-	if err != nil {
-		return 0, err
+func (e *Encoder) appendMap(dst []byte, v reflect.Value) ([]byte, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return dst, ErrType
 	}
-	for i := 0; i < v.NumField(); i++ {
-		fv := v.Field(i)
-		ft := v.Type().Field(i)
-		key := ft.Tag.Get("bencode")
-		if key == "" {
-			key = strings.ToLower(ft.Name)
-		}
-
-		tmpLen, err = EncodeString(w, key)
+	var err error
+	dst = append(dst, 'd')
+	for _, key := range sortedMapKeys(v) {
+		dst = appendString(dst, key.String())
+		dst, err = e.appendValue(dst, v.MapIndex(key))
 		if err != nil {
-			return 0, err
+			return dst, err
 		}
-		len += tmpLen
+	}
+	return append(dst, 'e'), nil
+}
 
-		tmpLen, err = marshalValue(w, fv)
+// appendDict writes the fields of a struct, which structFields sorts by
+// key, with the entries of an extra field merged in, so the dictionary is
+// canonical. A field left out by omitempty does not hide the extra entry
+// of its key.
+func (e *Encoder) appendDict(dst []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	fields := structFields(v.Type(), e.naming)
+	var extra reflect.Value
+	var extraKeys []reflect.Value
+	for _, f := range fields {
+		if f.extra {
+			extra = v.Field(f.index)
+			extraKeys = sortedMapKeys(extra)
+		}
+	}
+	appendExtra := func(key reflect.Value) {
+		dst = appendString(dst, key.String())
+		dst, err = e.appendValue(dst, extra.MapIndex(key))
+	}
+	dst = append(dst, 'd')
+	for _, f := range fields {
+		if f.extra || f.omitEmpty && isEmptyValue(v.Field(f.index)) {
+			continue
+		}
+		for ; len(extraKeys) > 0 && extraKeys[0].String() < f.key; extraKeys = extraKeys[1:] {
+			appendExtra(extraKeys[0])
+			if err != nil {
+				return dst, err
+			}
+		}
+		if len(extraKeys) > 0 && extraKeys[0].String() == f.key {
+			extraKeys = extraKeys[1:]
+		}
+		dst = appendString(dst, f.key)
+		dst, err = e.appendValue(dst, v.Field(f.index))
 		if err != nil {
-			return 0, err
+			return dst, err
 		}
-		len += tmpLen
 	}
-	_, err = w.Write([]byte("e"))
-	if err != nil {
-		return 0, err
+	for _, key := range extraKeys {
+		appendExtra(key)
+		if err != nil {
+			return dst, err
+		}
 	}
-	return
+	return append(dst, 'e'), nil
+}
+
+func sortedMapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	slices.SortFunc(keys, func(a, b reflect.Value) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return keys
 }
@@ -3,6 +3,8 @@ package bencode
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
 	"testing"
 )
 
@@ -46,6 +48,59 @@ func TestMarshal(t *testing.T) {
 	})
 }
 
+func TestAppendBencodeKinds(t *testing.T) {
+	var nilMap map[string]any
+	var nilPtr *User
+	for _, c := range []struct {
+		name string
+		in   any
+		want string
+		err  error
+	}{
+		{"Int64", struct{ A int64 }{1}, "d1:ai1ee", nil},
+		{"Int8", int8(-128), "i-128e", nil},
+		{"Uint64", uint64(1 << 63), "i9223372036854775808e", nil},
+		{"Uint16", struct{ P uint16 }{6881}, "d1:pi6881ee", nil},
+		{"Bytes", []byte("ab\x00"), "3:ab\x00", nil},
+		{"BytesField", struct{ H []byte }{[]byte{0xff}}, "d1:h1:\xffe", nil},
+		{"Interfaces", map[string]any{"a": 1, "b": []any{"x", uint8(2)}}, "d1:ai1e1:bl1:xi2eee", nil},
+		{"PtrField", struct{ U *User }{&User{Name: "n", Age: 1}}, "d1:ud3:agei1e4:name1:nee", nil},
+		{"EmptyMap", nilMap, "de", nil},
+		{"Nil", nil, "", ErrType},
+		{"NilPtr", nilPtr, "", ErrType},
+		{"NilPtrField", struct{ U *User }{}, "d1:u", ErrType},
+		{"NilInterface", map[string]any{"a": nil}, "d1:a", ErrType},
+		{"Bool", true, "", ErrType},
+		{"BoolField", struct{ A bool }{true}, "d1:a", ErrType},
+		{"Float", []float64{1}, "l", ErrType},
+		{"IntKeys", map[int]string{1: "x"}, "", ErrType},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := AppendBencode(nil, c.in)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.want, string(got))
+		})
+	}
+}
+
+func TestUnmarshalKinds(t *testing.T) {
+	var v struct {
+		A int64  `bencode:"a"`
+		B uint8  `bencode:"b"`
+		H []byte `bencode:"h"`
+	}
+	require.NoError(t, Unmarshal(bytes.NewBufferString("d1:ai-5e1:bi255e1:h2:\x00\xffe"), &v))
+	assert.Equal(t, int64(-5), v.A)
+	assert.Equal(t, uint8(255), v.B)
+	assert.Equal(t, []byte{0, 0xff}, v.H)
+
+	var small struct {
+		B uint8 `bencode:"b"`
+	}
+	assert.ErrorIs(t, Unmarshal(bytes.NewBufferString("d1:bi256ee"), &small), ErrOverflow)
+	assert.ErrorIs(t, Unmarshal(bytes.NewBufferString("d1:bi-1ee"), &small), ErrOverflow)
+}
+
 func TestUnmarshal(t *testing.T) {
 	t.Run("UnmarshalList", func(t *testing.T) {
 		str := "li85ei90ei95ee"
@@ -63,7 +118,7 @@ func TestUnmarshal(t *testing.T) {
 	})
 
 	t.Run("UnmarshalRole", func(t *testing.T) {
-		str := "d2:idi1e4:userd4:name6:archer3:agei29eee"
+		str := "d2:idi1e4:userd3:agei29e4:name6:archeree"
 		r := &Role{}
 		_ = Unmarshal(bytes.NewBufferString(str), r)
 		assert.Equal(t, 1, r.Id)
@@ -77,12 +132,12 @@ func TestUnmarshal(t *testing.T) {
 	})
 
 	t.Run("UnmarshalScore", func(t *testing.T) {
-		str := "d4:userd4:name6:archer3:agei29ee5:valueli80ei85ei90eee"
+		str := "d4:userd3:agei29e4:name6:archere5:valueli80ei85ei90eee"
 		s := &Score{}
 		_ = Unmarshal(bytes.NewBufferString(str), s)
 		assert.Equal(t, "archer", s.Name)
 		assert.Equal(t, 29, s.Age)
-		assert.Equal(t, []int{85, 90, 95}, s.Value)
+		assert.Equal(t, []int{80, 85, 90}, s.Value)
 
 		buf := new(bytes.Buffer)
 		length, _ := Marshal(buf, s)
@@ -91,7 +146,7 @@ func TestUnmarshal(t *testing.T) {
 	})
 
 	t.Run("UnmarshalTeam", func(t *testing.T) {
-		str := "d4:name3:ace4:sizei2e6:memberld4:name6:archer3:agei29eed4:name5:nancy3:agei31eeee"
+		str := "d6:memberld3:agei29e4:name6:archered3:agei31e4:name5:nancyee4:name3:ace4:sizei2ee"
 		team := &Team{}
 		_ = Unmarshal(bytes.NewBufferString(str), team)
 		assert.Equal(t, "ace", team.Name)
@@ -103,3 +158,170 @@ func TestUnmarshal(t *testing.T) {
 		assert.Equal(t, str, buf.String())
 	})
 }
+
+type Settings struct {
+	Name  string                `bencode:"name"`
+	Owner User                  `bencode:"owner"`
+	Tags  map[string]int        `bencode:"tags"`
+	Rest  map[string]RawMessage `bencode:",extra"`
+}
+
+type torrentFile struct {
+	Info    RawMessage            `bencode:"info"`
+	URLList []string              `bencode:"url-list"`
+	Extra   map[string]RawMessage `bencode:",extra"`
+}
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	type optional struct {
+		Comment string         `bencode:"comment,omitempty"`
+		Length  int            `bencode:"length,omitempty"`
+		List    []string       `bencode:"list,omitempty"`
+		Map     map[string]int `bencode:"map,omitempty"`
+		Name    string         `bencode:"name"`
+	}
+	buf := new(bytes.Buffer)
+	_, err := Marshal(buf, &optional{})
+	assert.NoError(t, err)
+	assert.Equal(t, "d4:name0:e", buf.String())
+
+	buf.Reset()
+	_, err = Marshal(buf, &optional{Comment: "c", Length: 1, List: []string{"a"}, Map: map[string]int{"k": 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, "d7:comment1:c6:lengthi1e4:listl1:ae3:mapd1:ki2ee4:name0:e", buf.String())
+}
+
+func TestUnmarshalMerge(t *testing.T) {
+	t.Run("MergeStruct", func(t *testing.T) {
+		s := &Settings{Name: "old", Owner: User{Name: "archer", Age: 29}}
+		err := Unmarshal(bytes.NewBufferString("d5:ownerd3:agei30eee"), s)
+		assert.NoError(t, err)
+		assert.Equal(t, "old", s.Name)
+		assert.Equal(t, User{Name: "archer", Age: 30}, s.Owner)
+	})
+
+	t.Run("MergeMap", func(t *testing.T) {
+		m := map[string]int{"a": 1, "b": 2}
+		err := Unmarshal(bytes.NewBufferString("d1:bi3e1:ci4ee"), &m)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"a": 1, "b": 3, "c": 4}, m)
+	})
+
+	t.Run("MergeMapOfStruct", func(t *testing.T) {
+		m := map[string]User{"x": {Name: "archer", Age: 29}}
+		err := Unmarshal(bytes.NewBufferString("d1:xd3:agei30eee"), &m)
+		assert.NoError(t, err)
+		assert.Equal(t, User{Name: "archer", Age: 30}, m["x"])
+	})
+
+	t.Run("ReuseSlice", func(t *testing.T) {
+		backing := make([]int, 1, 8)
+		l := backing
+		err := Unmarshal(bytes.NewBufferString("li1ei2ei3ee"), &l)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3}, l)
+		assert.Equal(t, &backing[:1][0], &l[0])
+	})
+
+	t.Run("MergeSliceElements", func(t *testing.T) {
+		users := []User{{Name: "archer", Age: 29}}
+		err := Unmarshal(bytes.NewBufferString("ld3:agei30eed4:name5:nancyee"), &users)
+		assert.NoError(t, err)
+		assert.Equal(t, []User{{Name: "archer", Age: 30}, {Name: "nancy"}}, users)
+	})
+
+	t.Run("TypeMismatch", func(t *testing.T) {
+		l := []string{}
+		err := Unmarshal(bytes.NewBufferString("li1ee"), &l)
+		assert.Equal(t, ErrType, err)
+	})
+}
+
+func TestExtraKeys(t *testing.T) {
+	t.Run("CaptureAndReEmit", func(t *testing.T) {
+		str := "d1:a3:abc4:name3:ace5:ownerd3:agei29e4:name6:archere4:tagsde1:zli1eee"
+		s := &Settings{}
+		err := Unmarshal(bytes.NewBufferString(str), s)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]RawMessage{
+			"a": RawMessage("3:abc"),
+			"z": RawMessage("li1ee"),
+		}, s.Rest)
+
+		buf := new(bytes.Buffer)
+		length, err := Marshal(buf, s)
+		assert.NoError(t, err)
+		assert.Equal(t, str, buf.String())
+		assert.Equal(t, buf.Len(), length)
+	})
+
+	t.Run("RawMessageKeepsExactBytes", func(t *testing.T) {
+		str := "d4:infod4:name1:a6:lengthi1ee1:x0:e"
+		f := &torrentFile{}
+		err := Unmarshal(bytes.NewBufferString(str), f)
+		assert.NoError(t, err)
+		assert.Equal(t, "d4:name1:a6:lengthi1ee", string(f.Info))
+
+		buf := new(bytes.Buffer)
+		_, err = Marshal(buf, f)
+		assert.NoError(t, err)
+		assert.Equal(t, "d4:infod4:name1:a6:lengthi1ee8:url-listle1:x0:e", buf.String())
+	})
+
+	t.Run("TorrentRoundTrip", func(t *testing.T) {
+		data, err := os.ReadFile("testdata/torrents/web-seed.torrent")
+		assert.NoError(t, err)
+		f := &torrentFile{}
+		assert.NoError(t, Unmarshal(bytes.NewReader(data), f))
+		assert.Contains(t, f.Extra, "x_cross_seed")
+
+		buf := new(bytes.Buffer)
+		_, err = Marshal(buf, f)
+		assert.NoError(t, err)
+		assert.Equal(t, string(data), buf.String())
+	})
+
+	t.Run("EmptyRawMessage", func(t *testing.T) {
+		_, err := Marshal(new(bytes.Buffer), &torrentFile{})
+		assert.Equal(t, ErrEmptyRaw, err)
+	})
+
+	t.Run("MismatchedTypeKept", func(t *testing.T) {
+		type webSeeds struct {
+			URLList []string              `bencode:"url-list,omitempty"`
+			Extra   map[string]RawMessage `bencode:",extra"`
+		}
+		// BEP 19 also allows a single URL
+		str := "d1:ai1e8:url-list13:http://x/a/b/e"
+		f := &webSeeds{}
+		assert.NoError(t, Unmarshal(bytes.NewBufferString(str), f))
+		assert.Nil(t, f.URLList)
+		assert.Equal(t, RawMessage("13:http://x/a/b/"), f.Extra["url-list"])
+
+		buf := new(bytes.Buffer)
+		_, err := Marshal(buf, f)
+		assert.NoError(t, err)
+		assert.Equal(t, str, buf.String())
+	})
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	type unsorted struct {
+		Z     int                   `bencode:"z"`
+		B     string                `bencode:"b,omitempty"`
+		A     int                   `bencode:"a"`
+		Extra map[string]RawMessage `bencode:",extra"`
+	}
+	v := unsorted{Z: 1, A: 2, Extra: map[string]RawMessage{"y": RawMessage("0:"), "b": RawMessage("i3e"), "0": RawMessage("le")}}
+	buf := new(bytes.Buffer)
+	_, err := Marshal(buf, v)
+	assert.NoError(t, err)
+	assert.Equal(t, "d1:0le1:ai2e1:bi3e1:y0:1:zi1ee", buf.String())
+
+	// a field that is written wins over the extra entry of its key
+	v.B = "set"
+	buf.Reset()
+	_, err = Marshal(buf, v)
+	assert.NoError(t, err)
+	assert.Equal(t, "d1:0le1:ai2e1:b3:set1:y0:1:zi1ee", buf.String())
+}
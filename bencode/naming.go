@@ -0,0 +1,61 @@
+package bencode
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NamingStrategy derives the dictionary key of a struct field that has no
+// name in its bencode tag.
+type NamingStrategy func(field string) string
+
+var (
+	// ExactNames uses the Go field name as is: PieceLength -> PieceLength.
+	ExactNames NamingStrategy = func(field string) string { return field }
+	// LowerNames lower-cases the field name: PieceLength -> piecelength.
+	LowerNames NamingStrategy = strings.ToLower
+	// SnakeNames joins words with underscores: PieceLength -> piece_length.
+	SnakeNames = joinWords("_")
+	// SpaceNames joins words with spaces as BEP 3 does: PieceLength -> piece length.
+	SpaceNames = joinWords(" ")
+	// KebabNames joins words with dashes: AnnounceList -> announce-list.
+	KebabNames = joinWords("-")
+)
+
+func joinWords(sep string) NamingStrategy {
+	return func(field string) string {
+		return strings.ToLower(strings.Join(splitWords(field), sep))
+	}
+}
+
+// splitWords breaks a Go identifier into words at case changes, keeping
+// acronyms together: URLList -> URL, List and InfoHashV2 -> Info, Hash, V2.
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		switch {
+		case cur == '_':
+			if start < i {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		case unicode.IsUpper(cur) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+		case unicode.IsUpper(cur) && unicode.IsUpper(prev) &&
+			i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+		default:
+			continue
+		}
+		if start < i {
+			words = append(words, string(runes[start:i]))
+		}
+		start = i
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
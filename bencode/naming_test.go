@@ -0,0 +1,106 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fileInfo struct {
+	CreatedBy    string
+	CreationDate int
+	PieceLength  int
+	URLList      []string
+}
+
+func TestNamingStrategies(t *testing.T) {
+	testCases := []struct {
+		field string
+		exact string
+		lower string
+		snake string
+		space string
+		kebab string
+	}{
+		{"Name", "Name", "name", "name", "name", "name"},
+		{"PieceLength", "PieceLength", "piecelength", "piece_length", "piece length", "piece-length"},
+		{"CreationDate", "CreationDate", "creationdate", "creation_date", "creation date", "creation-date"},
+		{"URLList", "URLList", "urllist", "url_list", "url list", "url-list"},
+		{"InfoHashV2", "InfoHashV2", "infohashv2", "info_hash_v2", "info hash v2", "info-hash-v2"},
+		{"ID", "ID", "id", "id", "id", "id"},
+		{"Meta_Version", "Meta_Version", "meta_version", "meta_version", "meta version", "meta-version"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.field, func(t *testing.T) {
+			assert.Equal(t, tc.exact, ExactNames(tc.field))
+			assert.Equal(t, tc.lower, LowerNames(tc.field))
+			assert.Equal(t, tc.snake, SnakeNames(tc.field))
+			assert.Equal(t, tc.space, SpaceNames(tc.field))
+			assert.Equal(t, tc.kebab, KebabNames(tc.field))
+		})
+	}
+}
+
+func TestEncoderNaming(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.SetKeyNaming(SpaceNames)
+	err := enc.Encode(&fileInfo{CreatedBy: "me", CreationDate: 1, PieceLength: 2, URLList: []string{}})
+	assert.NoError(t, err)
+	assert.Equal(t, "d10:created by2:me13:creation datei1e12:piece lengthi2e8:url listlee", buf.String())
+
+	buf.Reset()
+	enc.SetKeyNaming(KebabNames)
+	assert.NoError(t, enc.Encode(struct{ AnnounceList []string }{}))
+	assert.Equal(t, "d13:announce-listlee", buf.String())
+}
+
+func TestDecoderNaming(t *testing.T) {
+	t.Run("SpaceNames", func(t *testing.T) {
+		dec := NewDecoder(bytes.NewBufferString("d10:created by2:me12:piece lengthi16384ee"))
+		dec.SetKeyNaming(SpaceNames)
+		fi := &fileInfo{}
+		assert.NoError(t, dec.Decode(fi))
+		assert.Equal(t, "me", fi.CreatedBy)
+		assert.Equal(t, 16384, fi.PieceLength)
+	})
+
+	t.Run("CaseSensitiveByDefault", func(t *testing.T) {
+		fi := &fileInfo{}
+		assert.NoError(t, Unmarshal(bytes.NewBufferString("d9:CreatedBy2:mee"), fi))
+		assert.Empty(t, fi.CreatedBy)
+	})
+
+	t.Run("CaseInsensitive", func(t *testing.T) {
+		dec := NewDecoder(bytes.NewBufferString("d9:CreatedBy2:me11:PIECELENGTHi1e11:piecelengthi2ee"))
+		dec.SetCaseInsensitive(true)
+		fi := &fileInfo{}
+		assert.NoError(t, dec.Decode(fi))
+		assert.Equal(t, "me", fi.CreatedBy)
+		assert.Equal(t, 2, fi.PieceLength)
+	})
+
+	t.Run("FoldedKeysAreNotExtra", func(t *testing.T) {
+		var v struct {
+			Name  string
+			Extra map[string]RawMessage `bencode:",extra"`
+		}
+		dec := NewDecoder(bytes.NewBufferString("d4:NAME1:a5:other1:be"))
+		dec.SetCaseInsensitive(true)
+		assert.NoError(t, dec.Decode(&v))
+		assert.Equal(t, "a", v.Name)
+		assert.Equal(t, map[string]RawMessage{"other": RawMessage("1:b")}, v.Extra)
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		dec := NewDecoder(bytes.NewBufferString("i1ei2e3:abc"))
+		var a, b int
+		var c string
+		assert.NoError(t, dec.Decode(&a))
+		assert.NoError(t, dec.Decode(&b))
+		assert.NoError(t, dec.Decode(&c))
+		assert.Equal(t, []any{1, 2, "abc"}, []any{a, b, c})
+	})
+}
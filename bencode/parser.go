@@ -1,56 +1,56 @@
 package bencode
 
 import (
-	"bufio"
 	"io"
 )
 
 func Parse(r io.Reader) (*BObject, error) {
-	br, ok := r.(*bufio.Reader)
-	if !ok {
-		br = bufio.NewReader(r)
-	}
-	b, err := br.Peek(1)
+	return parse(newScanner(r, false))
+}
+
+func parse(s *scanner) (*BObject, error) {
+	b, err := s.peek()
 	if err != nil {
 		return nil, err
 	}
+	start := s.pos()
 	var ret BObject
 	switch {
-	case b[0] >= '0' && b[0] <= '9':
+	case b >= '0' && b <= '9':
 		// parse string
-		val, err := DecodeString(br)
+		val, err := decodeString(s)
 		if err != nil {
 			return nil, err
 		}
 		ret.type_ = BSTR
 		ret.val_ = val
-	case b[0] == 'i':
+	case b == 'i':
 		// parse int
-		val, err := DecodeInt(br)
+		val, err := decodeInt(s)
 		if err != nil {
 			return nil, err
 		}
 		ret.type_ = BINT
 		ret.val_ = val
-	case b[0] == 'l':
-		_, err := br.ReadByte()
+	case b == 'l':
+		_, err := s.ReadByte()
 		if err != nil {
 			return nil, err
 		}
 		var list []*BObject
 		for {
-			p, err := br.Peek(1)
+			p, err := s.peek()
 			if err != nil {
 				return nil, err
 			}
-			if p[0] == 'e' {
-				_, err := br.ReadByte()
+			if p == 'e' {
+				_, err := s.ReadByte()
 				if err != nil {
 					return nil, err
 				}
 				break
 			}
-			elem, err := Parse(br)
+			elem, err := parse(s)
 			if err != nil {
 				return nil, err
 			}
@@ -59,38 +59,39 @@ func Parse(r io.Reader) (*BObject, error) {
 		ret.type_ = BLIST
 		ret.val_ = list
 
-	case b[0] == 'd':
-		_, err := br.ReadByte()
+	case b == 'd':
+		_, err := s.ReadByte()
 		if err != nil {
 			return nil, err
 		}
 		dict := make(map[string]*BObject)
 		for {
-			p, err := br.Peek(1)
+			p, err := s.peek()
 			if err != nil {
 				return nil, err
 			}
-			if p[0] == 'e' {
-				_, err := br.ReadByte()
+			if p == 'e' {
+				_, err := s.ReadByte()
 				if err != nil {
 					return nil, err
 				}
 				break
 			}
-			key, err := DecodeString(br)
+			key, err := decodeString(s)
 			if err != nil {
 				return nil, err
 			}
-			val, err := Parse(br)
+			val, err := parse(s)
 			if err != nil {
 				return nil, err
 			}
 			dict[key] = val
-			ret.type_ = BDICT
-			ret.val_ = dict
 		}
+		ret.type_ = BDICT
+		ret.val_ = dict
 	default:
 		return nil, ErrType
 	}
+	ret.raw_ = s.since(start)
 	return &ret, nil
 }
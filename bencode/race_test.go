@@ -0,0 +1,7 @@
+//go:build race
+
+package bencode
+
+// raceEnabled reports whether the race detector is on, which makes
+// sync.Pool drop items and so allocate.
+const raceEnabled = true
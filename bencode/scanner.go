@@ -0,0 +1,68 @@
+package bencode
+
+import (
+	"bufio"
+	"io"
+)
+
+// scanner is the byte source shared by the decoding functions. With record
+// set it keeps every consumed byte, which lets Unmarshal hand out the exact
+// encoding of a value as a RawMessage.
+type scanner struct {
+	br     *bufio.Reader
+	record bool
+	buf    []byte
+}
+
+func newScanner(r io.Reader, record bool) *scanner {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &scanner{br: br, record: record}
+}
+
+func (s *scanner) Read(p []byte) (int, error) {
+	n, err := s.br.Read(p)
+	if s.record {
+		s.buf = append(s.buf, p[:n]...)
+	}
+	return n, err
+}
+
+func (s *scanner) ReadByte() (byte, error) {
+	b, err := s.br.ReadByte()
+	if err == nil && s.record {
+		s.buf = append(s.buf, b)
+	}
+	return b, err
+}
+
+func (s *scanner) UnreadByte() error {
+	err := s.br.UnreadByte()
+	if err == nil && s.record {
+		s.buf = s.buf[:len(s.buf)-1]
+	}
+	return err
+}
+
+func (s *scanner) peek() (byte, error) {
+	b, err := s.br.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// pos is the number of bytes recorded so far.
+func (s *scanner) pos() int {
+	return len(s.buf)
+}
+
+// since returns the bytes recorded from start up to now.
+func (s *scanner) since(start int) []byte {
+	if !s.record {
+		return nil
+	}
+	return s.buf[start:len(s.buf):len(s.buf)]
+}
@@ -1,180 +1,183 @@
 package bencode
 
 import (
-	"errors"
+	"bytes"
 	"io"
 	"reflect"
 	"strings"
 )
 
+// Unmarshal decodes one value from r into the value src points to, see
+// Decoder.Decode.
 func Unmarshal(r io.Reader, src any) error {
-	o, err := Parse(r)
-	if err != nil {
-		return err
-	}
-	p := reflect.ValueOf(src)
-	if p.Kind() != reflect.Ptr {
-		return errors.New("dest must be a pointer")
+	return NewDecoder(r).Decode(src)
+}
+
+func kindMatches(o *BObject, t reflect.Type) bool {
+	if t == rawMessageType {
+		return true
 	}
 	switch o.type_ {
+	case BSTR:
+		return t.Kind() == reflect.String || t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+	case BINT:
+		return isInt(t.Kind()) || isUint(t.Kind())
 	case BLIST:
-		list, err := o.List()
+		return t.Kind() == reflect.Slice
+	case BDICT:
+		return t.Kind() == reflect.Struct ||
+			t.Kind() == reflect.Map && t.Key().Kind() == reflect.String
+	}
+	return false
+}
+
+func (d *Decoder) unmarshalValue(v reflect.Value, o *BObject) error {
+	if !kindMatches(o, v.Type()) {
+		return ErrType
+	}
+	if v.Type() == rawMessageType {
+		v.SetBytes(bytes.Clone(o.raw_))
+		return nil
+	}
+	switch o.type_ {
+	case BSTR:
+		val, err := o.Str()
 		if err != nil {
 			return err
 		}
-		l := reflect.MakeSlice(p.Elem().Type(), len(list), len(list))
-		p.Elem().Set(l)
-		err = unmarshalList(p, list)
+		if v.Kind() == reflect.Slice {
+			v.SetBytes([]byte(val))
+		} else {
+			v.SetString(val)
+		}
+	case BINT:
+		val, err := o.Int()
 		if err != nil {
 			return err
 		}
+		if isUint(v.Kind()) {
+			if val < 0 || v.OverflowUint(uint64(val)) {
+				return ErrOverflow
+			}
+			v.SetUint(uint64(val))
+		} else {
+			if v.OverflowInt(int64(val)) {
+				return ErrOverflow
+			}
+			v.SetInt(int64(val))
+		}
+	case BLIST:
+		list, err := o.List()
+		if err != nil {
+			return err
+		}
+		return d.unmarshalList(v, list)
 	case BDICT:
 		dict, err := o.Dict()
 		if err != nil {
 			return err
 		}
-		err = unmarshalDict(p, dict)
+		if v.Kind() == reflect.Map {
+			return d.unmarshalMap(v, dict)
+		}
+		return d.unmarshalDict(v, dict)
+	}
+	return nil
+}
+
+func (d *Decoder) unmarshalList(v reflect.Value, list []*BObject) error {
+	n := len(list)
+	switch {
+	case v.IsNil() || v.Cap() < n:
+		s := reflect.MakeSlice(v.Type(), n, n)
+		reflect.Copy(s, v)
+		v.Set(s)
+	default:
+		old := v.Len()
+		v.SetLen(n)
+		for i := old; i < n; i++ {
+			v.Index(i).SetZero()
+		}
+	}
+	for i, o := range list {
+		err := d.unmarshalValue(v.Index(i), o)
 		if err != nil {
 			return err
 		}
-	default:
-		return errors.New("src code must be struct or slice")
 	}
 	return nil
 }
 
-func unmarshalList(p reflect.Value, list []*BObject) error {
-	if p.Kind() != reflect.Ptr || p.Elem().Type().Kind() != reflect.Slice {
-		return errors.New("dest must be a pointer to a slice")
+func (d *Decoder) unmarshalMap(v reflect.Value, dict map[string]*BObject) error {
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(v.Type(), len(dict)))
 	}
-	v := p.Elem()
-	if len(list) == 0 {
-		return nil
-	}
-	switch list[0].type_ {
-	case BSTR:
-		for i, o := range list {
-			val, err := o.Str()
-			if err != nil {
-				return err
-			}
-			v.Index(i).SetString(val)
+	kt, et := v.Type().Key(), v.Type().Elem()
+	for key, o := range dict {
+		kv := reflect.ValueOf(key).Convert(kt)
+		ev := reflect.New(et).Elem()
+		if old := v.MapIndex(kv); old.IsValid() {
+			ev.Set(old)
 		}
-	case BINT:
-		for i, o := range list {
-			val, err := o.Int()
-			if err != nil {
-				return err
-			}
-			v.Index(i).SetInt(int64(val))
-		}
-	case BLIST:
-		for i, o := range list {
-			val, err := o.List()
-			if err != nil {
-				return err
-			}
-			if v.Type().Elem().Kind() != reflect.Slice {
-				return ErrType
-			}
-			lp := reflect.New(v.Type().Elem())
-			ls := reflect.MakeSlice(v.Type().Elem(), len(val), len(val))
-			lp.Elem().Set(ls)
-			err = unmarshalList(lp, val)
-			if err != nil {
-				return err
-			}
-			v.Index(i).Set(lp.Elem())
-		}
-	case BDICT:
-		for i, o := range list {
-			val, err := o.Dict()
-			if err != nil {
-				return err
-			}
-			if v.Type().Elem().Kind() != reflect.Struct {
-				return ErrType
-			}
-			dp := reflect.New(v.Type().Elem())
-			err = unmarshalDict(dp, val)
-			if err != nil {
-				return err
-			}
-			v.Index(i).Set(dp.Elem())
+		err := d.unmarshalValue(ev, o)
+		if err != nil {
+			return err
 		}
+		v.SetMapIndex(kv, ev)
 	}
 	return nil
 }
 
-func unmarshalDict(p reflect.Value, dict map[string]*BObject) error {
-	if p.Kind() != reflect.Ptr || p.Elem().Type().Kind() != reflect.Struct {
-		return errors.New("dest must be a pointer to a struct")
-	}
-	v := p.Elem()
-	for i, n := 0, v.NumField(); i < n; i++ {
-		fv := v.Field(i)
-		if !fv.CanSet() {
+func (d *Decoder) unmarshalDict(v reflect.Value, dict map[string]*BObject) error {
+	var extra reflect.Value
+	used := make(map[string]bool)
+	for _, f := range structFields(v.Type(), d.naming) {
+		fv := v.Field(f.index)
+		if f.extra {
+			extra = fv
 			continue
 		}
-		ft := v.Type().Field(i)
-		key := ft.Tag.Get("bencode")
-		if key == "" {
-			key = strings.ToLower(ft.Name)
-		}
+		key := d.lookupKey(dict, f.key)
 		fo := dict[key]
-		if fo == nil {
+		// a value of another type than the field is kept in extra
+		if fo == nil || !kindMatches(fo, fv.Type()) {
 			continue
 		}
-		switch fo.type_ {
-		case BSTR:
-			if ft.Type.Kind() != reflect.String {
-				break
-			}
-			val, err := fo.Str()
-			if err != nil {
-				return err
-			}
-			fv.SetString(val)
-		case BINT:
-			if ft.Type.Kind() != reflect.Int {
-				break
-			}
-			val, err := fo.Int()
-			if err != nil {
-				return err
-			}
-			fv.SetInt(int64(val))
-		case BLIST:
-			if ft.Type.Kind() != reflect.Slice {
-				break
-			}
-			list, err := fo.List()
-			if err != nil {
-				return err
-			}
-			lp := reflect.New(ft.Type)
-			ls := reflect.MakeSlice(ft.Type, len(list), len(list))
-			lp.Elem().Set(ls)
-			err = unmarshalList(lp, list)
-			if err != nil {
-				return err
-			}
-			fv.Set(lp.Elem())
-		case BDICT:
-			if ft.Type.Kind() != reflect.Struct {
-				break
-			}
-			dp := reflect.New(ft.Type)
-			dict, err := fo.Dict()
-			if err != nil {
-				return err
-			}
-			err = unmarshalDict(dp, dict)
-			if err != nil {
-				return err
-			}
-			fv.Set(dp.Elem())
+		err := d.unmarshalValue(fv, fo)
+		if err != nil {
+			return err
 		}
+		used[key] = true
+	}
+	if !extra.IsValid() {
+		return nil
+	}
+	for key, o := range dict {
+		if used[key] {
+			continue
+		}
+		if extra.IsNil() {
+			extra.Set(reflect.MakeMap(extra.Type()))
+		}
+		kv := reflect.ValueOf(key).Convert(extra.Type().Key())
+		extra.SetMapIndex(kv, reflect.ValueOf(RawMessage(bytes.Clone(o.raw_))))
 	}
 	return nil
 }
+
+// lookupKey returns the dictionary key that matches a field key. An exact
+// match wins; with case folding enabled the smallest key equal under
+// Unicode case folding is used instead.
+func (d *Decoder) lookupKey(dict map[string]*BObject, key string) string {
+	if _, ok := dict[key]; ok || !d.foldKeys {
+		return key
+	}
+	match := key
+	found := false
+	for k := range dict {
+		if strings.EqualFold(k, key) && (!found || k < match) {
+			match, found = k, true
+		}
+	}
+	return match
+}
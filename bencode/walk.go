@@ -0,0 +1,255 @@
+package bencode
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SkipSubtree is returned by a WalkFunc to skip the children of the list or
+// dictionary it was called for. It is not returned by Walk itself.
+var SkipSubtree = errors.New("skip subtree")
+
+// PathElem is one step from a container to one of its values.
+type PathElem struct {
+	Key   string
+	Index int // position in a list, -1 for a dictionary value
+}
+
+// Path locates a value relative to the root of a document.
+type Path []PathElem
+
+// String writes keys joined by dots and indexes in brackets, as in
+// info.files[0].length. Keys that would read ambiguously, holding a dot,
+// a bracket or a quote or being empty, are written quoted in brackets, as
+// in ["a.b"].
+func (p Path) String() string {
+	var sb strings.Builder
+	for _, e := range p {
+		if e.Index >= 0 {
+			sb.WriteString("[" + strconv.Itoa(e.Index) + "]")
+			continue
+		}
+		if e.Key == "" || strings.ContainsAny(e.Key, ".[]\"") || !strconv.CanBackquote(e.Key) {
+			sb.WriteString("[" + strconv.Quote(e.Key) + "]")
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(e.Key)
+	}
+	return sb.String()
+}
+
+// WalkFunc is called for every value visited. The path is reused between
+// calls and must be copied to be retained.
+type WalkFunc func(path Path, o *BObject) error
+
+// TransformFunc maps a value, whose children have already been transformed,
+// to its replacement. Returning nil removes the value from its parent.
+type TransformFunc func(path Path, o *BObject) (*BObject, error)
+
+// Walk visits o and everything below it depth first, a container before its
+// values and dictionary values in key order.
+func Walk(o *BObject, fn WalkFunc) error {
+	return walk(nil, o, fn)
+}
+
+func walk(path Path, o *BObject, fn WalkFunc) error {
+	err := fn(path, o)
+	if err == SkipSubtree {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch o.type_ {
+	case BLIST:
+		list, _ := o.List()
+		for i, elem := range list {
+			err = walk(append(path, PathElem{Index: i}), elem, fn)
+			if err != nil {
+				return err
+			}
+		}
+	case BDICT:
+		dict, _ := o.Dict()
+		for _, k := range slices.Sorted(maps.Keys(dict)) {
+			err = walk(append(path, PathElem{Key: k, Index: -1}), dict[k], fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Transform builds a new tree by applying fn bottom up to o and everything
+// below it. The input tree is not modified.
+func Transform(o *BObject, fn TransformFunc) (*BObject, error) {
+	return transform(nil, o, fn)
+}
+
+func transform(path Path, o *BObject, fn TransformFunc) (*BObject, error) {
+	switch o.type_ {
+	case BLIST:
+		list, _ := o.List()
+		out := make([]*BObject, 0, len(list))
+		for i, elem := range list {
+			v, err := transform(append(path, PathElem{Index: i}), elem, fn)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				out = append(out, v)
+			}
+		}
+		o = NewList(out)
+	case BDICT:
+		dict, _ := o.Dict()
+		out := make(map[string]*BObject, len(dict))
+		for _, k := range slices.Sorted(maps.Keys(dict)) {
+			v, err := transform(append(path, PathElem{Key: k, Index: -1}), dict[k], fn)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				out[k] = v
+			}
+		}
+		o = NewDict(out)
+	}
+	return fn(path, o)
+}
+
+// Walk reads the next value from the stream and visits it like Walk without
+// building the tree. Lists and dictionaries are reported empty before their
+// values; returning SkipSubtree for one discards its content unparsed.
+func (d *Decoder) Walk(fn WalkFunc) error {
+	d.s.record = false
+	defer func() { d.s.record = true }()
+	return walkStream(d.s, nil, fn)
+}
+
+// Transform reads the next value from the stream and returns it transformed
+// by fn, see Transform.
+func (d *Decoder) Transform(fn TransformFunc) (*BObject, error) {
+	d.s.buf = d.s.buf[:0]
+	o, err := parse(d.s)
+	if err != nil {
+		return nil, err
+	}
+	return Transform(o, fn)
+}
+
+func walkStream(s *scanner, path Path, fn WalkFunc) error {
+	b, err := s.peek()
+	if err != nil {
+		return err
+	}
+	switch {
+	case checkNum(b):
+		val, err := decodeString(s)
+		if err != nil {
+			return err
+		}
+		return skipToNil(fn(path, NewString(val)))
+	case b == 'i':
+		val, err := decodeInt(s)
+		if err != nil {
+			return err
+		}
+		return skipToNil(fn(path, NewInt(val)))
+	case b == 'l' || b == 'd':
+		_, _ = s.ReadByte()
+		var o *BObject
+		if b == 'l' {
+			o = NewList(nil)
+		} else {
+			o = NewDict(nil)
+		}
+		err = fn(path, o)
+		if err == SkipSubtree {
+			return skipValues(s)
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; ; i++ {
+			p, err := s.peek()
+			if err != nil {
+				return err
+			}
+			if p == 'e' {
+				_, err = s.ReadByte()
+				return err
+			}
+			elem := PathElem{Index: i}
+			if b == 'd' {
+				key, err := decodeString(s)
+				if err != nil {
+					return err
+				}
+				elem = PathElem{Key: key, Index: -1}
+			}
+			err = walkStream(s, append(path, elem), fn)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrType
+	}
+}
+
+func skipToNil(err error) error {
+	if err == SkipSubtree {
+		return nil
+	}
+	return err
+}
+
+// skipValues discards the values up to and including the end of the
+// current list or dictionary.
+func skipValues(s *scanner) error {
+	for depth := 1; depth > 0; {
+		b, err := s.peek()
+		if err != nil {
+			return err
+		}
+		switch {
+		case checkNum(b):
+			num, _, err := readDecimal(s)
+			if err != nil {
+				return err
+			}
+			if num < 0 {
+				return ErrNum
+			}
+			if c, err := s.ReadByte(); err != nil || c != ':' {
+				return ErrColon
+			}
+			if _, err = io.CopyN(io.Discard, s, int64(num)); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+		case b == 'i':
+			_, err = decodeInt(s)
+			if err != nil {
+				return err
+			}
+		case b == 'l' || b == 'd':
+			_, _ = s.ReadByte()
+			depth++
+		case b == 'e':
+			_, _ = s.ReadByte()
+			depth--
+		default:
+			return ErrType
+		}
+	}
+	return nil
+}
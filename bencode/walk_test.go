@@ -0,0 +1,155 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseString(t *testing.T, in string) *BObject {
+	o, err := Parse(bytes.NewBufferString(in))
+	require.NoError(t, err)
+	return o
+}
+
+func TestPathString(t *testing.T) {
+	for want, p := range map[string]Path{
+		"":                     nil,
+		"info.files[0].length": {{Key: "info", Index: -1}, {Key: "files", Index: -1}, {Index: 0}, {Key: "length", Index: -1}},
+		"a.b":                  {{Key: "a", Index: -1}, {Key: "b", Index: -1}},
+		`["a.b"]`:              {{Key: "a.b", Index: -1}},
+		`x["a.b"].c`:           {{Key: "x", Index: -1}, {Key: "a.b", Index: -1}, {Key: "c", Index: -1}},
+		`[""][1]`:              {{Key: "", Index: -1}, {Index: 1}},
+		`["q\"[0]"]`:           {{Key: `q"[0]`, Index: -1}},
+		`["\x00"].created by`:  {{Key: "\x00", Index: -1}, {Key: "created by", Index: -1}},
+	} {
+		assert.Equal(t, want, p.String())
+	}
+}
+
+func TestWalk(t *testing.T) {
+	o := parseString(t, "d4:infod5:filesld6:lengthi1eeee1:al1:x1:yee")
+
+	t.Run("Order", func(t *testing.T) {
+		var paths []string
+		err := Walk(o, func(path Path, o *BObject) error {
+			paths = append(paths, path.String())
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"", "a", "a[0]", "a[1]", "info", "info.files", "info.files[0]", "info.files[0].length",
+		}, paths)
+	})
+
+	t.Run("SkipSubtree", func(t *testing.T) {
+		var paths []string
+		err := Walk(o, func(path Path, o *BObject) error {
+			paths = append(paths, path.String())
+			if path.String() == "info" {
+				return SkipSubtree
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"", "a", "a[0]", "a[1]", "info"}, paths)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		stop := errors.New("stop")
+		count := 0
+		err := Walk(o, func(path Path, o *BObject) error {
+			count++
+			if o.Type() == BSTR {
+				return stop
+			}
+			return nil
+		})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, 3, count)
+	})
+}
+
+func TestTransform(t *testing.T) {
+	passkey := regexp.MustCompile(`/[0-9a-f]{32}/`)
+	in := "d8:announce58:http://t.example/0123456789abcdef0123456789abcdef/announce4:infod6:pieces4:\x01\x02\x03\x04ee"
+	o := parseString(t, in)
+
+	out, err := Transform(o, func(path Path, o *BObject) (*BObject, error) {
+		switch path.String() {
+		case "announce":
+			s, _ := o.Str()
+			return NewString(passkey.ReplaceAllString(s, "/REDACTED/")), nil
+		case "info.pieces":
+			return nil, nil
+		}
+		return o, nil
+	})
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	out.Bencode(buf)
+	assert.Equal(t, "d8:announce34:http://t.example/REDACTED/announce4:infodee", buf.String())
+
+	buf.Reset()
+	o.Bencode(buf)
+	assert.Equal(t, in, buf.String(), "input tree must be left untouched")
+}
+
+func TestDecoderWalk(t *testing.T) {
+	data, err := os.ReadFile("testdata/torrents/multi-file.torrent")
+	require.NoError(t, err)
+
+	var treePaths []string
+	err = Walk(parseString(t, string(data)), func(path Path, o *BObject) error {
+		treePaths = append(treePaths, path.String())
+		return nil
+	})
+	require.NoError(t, err)
+
+	var streamPaths []string
+	dec := NewDecoder(bytes.NewReader(data))
+	err = dec.Walk(func(path Path, o *BObject) error {
+		streamPaths = append(streamPaths, path.String())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, treePaths, streamPaths)
+
+	t.Run("SkipSubtree", func(t *testing.T) {
+		dec := NewDecoder(bytes.NewBufferString("d4:infod6:piecesl1:a1:bd1:xi1eeee1:zi2eei7e"))
+		var paths []string
+		err := dec.Walk(func(path Path, o *BObject) error {
+			paths = append(paths, path.String())
+			if path.String() == "info" {
+				return SkipSubtree
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"", "info", "z"}, paths)
+
+		var next int
+		assert.NoError(t, dec.Decode(&next))
+		assert.Equal(t, 7, next)
+	})
+
+	t.Run("Transform", func(t *testing.T) {
+		dec := NewDecoder(bytes.NewBufferString("li1ei2ei3ee"))
+		out, err := dec.Transform(func(path Path, o *BObject) (*BObject, error) {
+			if v, err := o.Int(); err == nil {
+				return NewInt(v * 10), nil
+			}
+			return o, nil
+		})
+		assert.NoError(t, err)
+		buf := new(bytes.Buffer)
+		out.Bencode(buf)
+		assert.Equal(t, "li10ei20ei30ee", buf.String())
+	})
+}
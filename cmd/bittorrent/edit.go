@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+)
+
+func runEdit(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	var trackers, webSeeds listFlag
+	fs.Var(&trackers, "tracker", "announce URLs of one tier, comma separated; repeat for more tiers")
+	clearTrackers := fs.Bool("clear-trackers", false, "remove all trackers")
+	fs.Var(&webSeeds, "webseed", "web seed URL; repeat for more")
+	clearWebSeeds := fs.Bool("clear-webseeds", false, "remove all web seeds")
+	comment := fs.String("comment", "", "comment")
+	createdBy := fs.String("created-by", "", "created by")
+	private := fs.String("private", "", "set the private flag to true or false (changes the info hash)")
+	source := fs.String("source", "", "source tag (changes the info hash)")
+	out := fs.String("o", "", "output file; defaults to rewriting the input")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected one torrent file")
+	}
+	in := fs.Arg(0)
+
+	var e metainfo.Edit
+	if len(trackers) > 0 || *clearTrackers {
+		e.Trackers = [][]string{}
+		for _, tier := range trackers {
+			e.Trackers = append(e.Trackers, strings.Split(tier, ","))
+		}
+	}
+	if len(webSeeds) > 0 || *clearWebSeeds {
+		e.URLList = append([]string{}, webSeeds...)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "comment":
+			e.Comment = comment
+		case "created-by":
+			e.CreatedBy = createdBy
+		case "source":
+			e.Source = source
+		}
+	})
+	if *private != "" {
+		p, err := strconv.ParseBool(*private)
+		if err != nil {
+			return fmt.Errorf("invalid -private %q", *private)
+		}
+		e.Private = &p
+	}
+
+	mi, err := metainfo.LoadFile(in)
+	if err != nil {
+		return err
+	}
+	res, err := mi.Apply(&e)
+	if err != nil {
+		return err
+	}
+	data, err := mi.Bytes()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = in
+	}
+	err = replaceFile(*out, data)
+	if err != nil {
+		return err
+	}
+
+	if !res.InfoChanged() {
+		fmt.Fprintf(stdout, "info hash unchanged: %s\n", formatHashes(res.New))
+		return nil
+	}
+	fmt.Fprintf(stdout, "info hash changed: %s -> %s\n", formatHashes(res.Old), formatHashes(res.New))
+	return nil
+}
+
+// replaceFile writes data to a temporary file next to name and renames it
+// over name, so a failed write leaves the old file whole. The file keeps the
+// permissions of the one it replaces, or gets 0644 when it is new.
+func replaceFile(name string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if st, err := os.Stat(name); err == nil {
+		mode = st.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func formatHashes(hs metainfo.InfoHashes) string {
+	var parts []string
+	if !hs.V1.IsZero() {
+		parts = append(parts, hs.V1.HexString())
+	}
+	if hs.V2 != (metainfo.InfoHashV2{}) {
+		parts = append(parts, hs.V2.HexString())
+	}
+	return strings.Join(parts, " ")
+}
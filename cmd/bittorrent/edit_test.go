@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEdit(t *testing.T) {
+	data, err := os.ReadFile("../../metainfo/testdata/multi-file.torrent")
+	require.NoError(t, err)
+	name := filepath.Join(t.TempDir(), "a.torrent")
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"edit", "-tracker", "http://a/ann,http://b/ann", "-tracker", "udp://c:1",
+		"-comment", "", "-webseed", "http://seed/", name}, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "info hash unchanged: 68cc940bdce47b589f7996b169f856c65a29c29d\n", stdout.String())
+
+	mi, err := metainfo.LoadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "http://a/ann", mi.Announce)
+	assert.Equal(t, [][]string{{"http://a/ann", "http://b/ann"}, {"udp://c:1"}}, mi.AnnounceList)
+	assert.Empty(t, mi.Comment)
+	assert.Equal(t, []string{"http://seed/"}, mi.URLList)
+	// the edit replaces the file whole and keeps its mode
+	entries, err := os.ReadDir(filepath.Dir(name))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	st, err := entries[0].Info()
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+	out := filepath.Join(t.TempDir(), "b.torrent")
+	stdout.Reset()
+	code = run([]string{"edit", "-private", "false", "-o", out, name}, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	st, err = os.Stat(out)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), st.Mode().Perm())
+	mi, err = metainfo.LoadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "info hash changed: 68cc940bdce47b589f7996b169f856c65a29c29d -> "+mi.HashInfoBytes().HexString()+"\n", stdout.String())
+
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"edit", "-private", "maybe", name}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "invalid -private")
+	assert.Equal(t, 2, run([]string{"nope"}, &stdout, &stderr))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker/server"
+)
+
+func runTracker(args []string, stdout io.Writer) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return serveTracker(ctx, args, stdout)
+}
+
+// serveTracker runs a tracker over HTTP and UDP on the same address until
+// ctx is done, then reports the transfer of each user.
+func serveTracker(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("tracker", flag.ContinueOnError)
+	listen := fs.String("listen", ":6969", "address to serve HTTP and UDP on")
+	interval := fs.Duration("interval", 0, "announce interval sent to clients; 0 means 30m")
+	var whitelist, blacklist listFlag
+	fs.Var(&whitelist, "whitelist", "hex info hash to track, repeatable; when given, others are refused")
+	fs.Var(&blacklist, "blacklist", "hex info hash to refuse, repeatable")
+	var passkeys, clients listFlag
+	fs.Var(&passkeys, "passkey", "passkey of a user, repeatable; when given, the tracker is private")
+	fs.Var(&clients, "client", "peer ID prefix of an allowed client, repeatable")
+	state := fs.String("state", "", "directory keeping swarms and users across restarts; empty keeps them in memory")
+	syncInterval := fs.Duration("sync-interval", 0, "longest changes to -state wait to be synced to disk; 0 syncs every change")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	s := &server.Server{Interval: *interval, Private: len(passkeys) > 0, Clients: clients}
+	if *state != "" {
+		store, err := server.OpenFileStore(*state)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		store.SyncInterval = *syncInterval
+		s.Store = store
+	}
+	for _, p := range passkeys {
+		err = s.AddUser(p)
+		if err != nil {
+			return err
+		}
+	}
+	if len(whitelist) > 0 {
+		s.Whitelist, err = hashSet(whitelist)
+		if err != nil {
+			return err
+		}
+	}
+	s.Blacklist, err = hashSet(blacklist)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	// with port 0 the UDP side takes the port the TCP side got
+	conn, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	fmt.Fprintf(stdout, "tracker listening on http://%s/announce and udp://%s\n", ln.Addr(), conn.LocalAddr())
+
+	srv := &http.Server{Handler: s}
+	defer srv.Close()
+	errc := make(chan error, 2)
+	go func() { errc <- srv.Serve(ln) }()
+	go func() { errc <- s.ServeUDP(conn) }()
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+	}
+	users, uerr := s.Users()
+	for _, u := range users {
+		fmt.Fprintf(stdout, "user %s: uploaded %d, downloaded %d, ratio %.3f\n", u.Passkey, u.Uploaded, u.Downloaded, u.Ratio())
+	}
+	return cmp.Or(err, uerr)
+}
+
+func hashSet(hexes []string) (map[metainfo.InfoHash]bool, error) {
+	set := make(map[metainfo.InfoHash]bool)
+	for _, list := range hexes {
+		for _, s := range strings.Split(list, ",") {
+			h, err := metainfo.ParseInfoHash(s)
+			if err != nil {
+				return nil, fmt.Errorf("info hash %q: %w", s, err)
+			}
+			set[h] = true
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTracker struct {
+	http, udp string
+	out       *bufio.Reader
+	cancel    context.CancelFunc
+	done      chan error
+}
+
+func startTracker(t *testing.T, args ...string) *testTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	r, w := io.Pipe()
+	tt := &testTracker{out: bufio.NewReader(r), cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		tt.done <- serveTracker(ctx, append([]string{"--listen", "127.0.0.1:0"}, args...), w)
+		w.Close()
+	}()
+	line, err := tt.out.ReadString('\n')
+	require.NoError(t, err)
+	fields := strings.Fields(line)
+	tt.http, tt.udp = fields[3], fields[5]
+	return tt
+}
+
+// stop stops the tracker and returns what it printed after starting.
+func (tt *testTracker) stop(t *testing.T) string {
+	tt.cancel()
+	report, err := io.ReadAll(tt.out)
+	require.NoError(t, err)
+	require.NoError(t, <-tt.done)
+	return string(report)
+}
+
+func TestTracker(t *testing.T) {
+	h := metainfo.InfoHash{1, 2, 3}
+	tt := startTracker(t, "-whitelist", h.HexString())
+	ctx := context.Background()
+
+	req := &tracker.AnnounceRequest{InfoHash: h, Port: 6881, Left: 1}
+	for _, c := range []tracker.Client{&tracker.HTTPClient{}, &tracker.UDPClient{}} {
+		announce := tt.http
+		if _, ok := c.(*tracker.UDPClient); ok {
+			announce = tt.udp
+		}
+		res, err := c.Announce(ctx, announce, req)
+		require.NoError(t, err, announce)
+		assert.Equal(t, 1, res.Leechers)
+
+		req := *req
+		req.InfoHash[0] = 9
+		_, err = c.Announce(ctx, announce, &req)
+		var fe *tracker.FailureError
+		assert.ErrorAs(t, err, &fe)
+	}
+	assert.Empty(t, tt.stop(t))
+
+	_, err := hashSet([]string{"abc"})
+	assert.Error(t, err)
+}
+
+func TestPrivateTracker(t *testing.T) {
+	tt := startTracker(t, "-passkey", "k1", "-client", "-qB")
+	ctx := context.Background()
+	base := strings.TrimSuffix(tt.http, "announce")
+
+	req := &tracker.AnnounceRequest{Port: 6881, Uploaded: 300, Downloaded: 100, Left: 1}
+	copy(req.PeerID[:], "-qB4650-000000000000")
+	_, err := (&tracker.HTTPClient{}).Announce(ctx, base+"k1/announce", req)
+	require.NoError(t, err)
+	_, err = (&tracker.HTTPClient{}).Announce(ctx, tt.http, req)
+	assert.Error(t, err)
+	copy(req.PeerID[:], "-TR")
+	_, err = (&tracker.HTTPClient{}).Announce(ctx, base+"k1/announce", req)
+	assert.Error(t, err)
+
+	assert.Equal(t, "user k1: uploaded 300, downloaded 100, ratio 3.000\n", tt.stop(t))
+}
+
+func TestTrackerState(t *testing.T) {
+	state := t.TempDir()
+	ctx := context.Background()
+	req := &tracker.AnnounceRequest{InfoHash: metainfo.InfoHash{1}, Port: 6881, Uploaded: 50}
+	for _, want := range []string{
+		"user k1: uploaded 50, downloaded 0, ratio +Inf\n",
+		"user k1: uploaded 100, downloaded 0, ratio +Inf\n",
+	} {
+		tt := startTracker(t, "-state", state, "-passkey", "k1")
+		res, err := (&tracker.HTTPClient{}).Announce(ctx, strings.TrimSuffix(tt.http, "announce")+"k1/announce", req)
+		require.NoError(t, err)
+		assert.Equal(t, 1, res.Seeders)
+		assert.Equal(t, want, tt.stop(t))
+		req.Uploaded += 50
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+)
+
+var errIncomplete = errors.New("data does not match the torrent")
+
+type verifyReport struct {
+	InfoHash   string             `json:"info_hash,omitempty"`
+	InfoHashV2 string             `json:"info_hash_v2,omitempty"`
+	Pieces     int                `json:"pieces"`
+	GoodPieces int                `json:"good_pieces"`
+	BadPieces  []int              `json:"bad_pieces"`
+	Complete   bool               `json:"complete"`
+	Files      []verifyFileReport `json:"files"`
+}
+
+type verifyFileReport struct {
+	Path     string `json:"path"`
+	Length   int    `json:"length"`
+	Verified int    `json:"verified"`
+	Missing  bool   `json:"missing"`
+	Complete bool   `json:"complete"`
+}
+
+func runVerify(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	workers := fs.Int("workers", 0, "pieces read and hashed at once; 0 uses one per CPU")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("expected a torrent file and a directory")
+	}
+	mi, err := metainfo.LoadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	hs, err := mi.InfoHashes()
+	if err != nil {
+		return err
+	}
+	res, err := (&metainfo.Verifier{Workers: *workers}).Verify(context.Background(), mi, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	report := verifyReport{
+		Pieces:     len(res.Pieces),
+		GoodPieces: res.NumGood(),
+		BadPieces:  []int{},
+		Complete:   res.Complete(),
+	}
+	if !hs.V1.IsZero() {
+		report.InfoHash = hs.V1.HexString()
+	}
+	if hs.V2 != (metainfo.InfoHashV2{}) {
+		report.InfoHashV2 = hs.V2.HexString()
+	}
+	for i, ok := range res.Pieces {
+		if !ok {
+			report.BadPieces = append(report.BadPieces, i)
+		}
+	}
+	for _, f := range res.Files {
+		path := f.DisplayPath()
+		if path == "" {
+			path = info.Name
+		}
+		report.Files = append(report.Files, verifyFileReport{
+			Path:     path,
+			Length:   f.Length,
+			Verified: f.Verified,
+			Missing:  f.Missing,
+			Complete: f.Complete(),
+		})
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(report)
+	if err != nil {
+		return err
+	}
+	if !report.Complete {
+		return errIncomplete
+	}
+	return nil
+}
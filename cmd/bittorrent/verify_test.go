@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "data")
+	require.NoError(t, os.MkdirAll(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a"), []byte(strings.Repeat("a", 40000)), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "b"), []byte("bbb"), 0o644))
+	mi, err := (&metainfo.Builder{PieceLength: 16 << 10}).Build(context.Background(), root)
+	require.NoError(t, err)
+	data, err := mi.Bytes()
+	require.NoError(t, err)
+	torrent := filepath.Join(t.TempDir(), "data.torrent")
+	require.NoError(t, os.WriteFile(torrent, data, 0o644))
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, run([]string{"verify", torrent, dir}, &stdout, &stderr), stderr.String())
+	var report verifyReport
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &report))
+	assert.True(t, report.Complete)
+	assert.Equal(t, 3, report.Pieces)
+	assert.Empty(t, report.BadPieces)
+	assert.Equal(t, mi.HashInfoBytes().HexString(), report.InfoHash)
+	assert.Empty(t, report.InfoHashV2)
+
+	require.NoError(t, os.Remove(filepath.Join(root, "b")))
+	stdout.Reset()
+	require.Equal(t, 1, run([]string{"verify", "-workers", "1", torrent, dir}, &stdout, &stderr))
+	report = verifyReport{}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &report))
+	assert.False(t, report.Complete)
+	assert.Equal(t, []int{2}, report.BadPieces)
+	assert.Equal(t, []verifyFileReport{
+		{Path: "a", Length: 40000, Verified: 2 * 16 << 10, Complete: false},
+		{Path: "b", Length: 3, Missing: true},
+	}, report.Files)
+	assert.Contains(t, stderr.String(), errIncomplete.Error())
+}
@@ -1,3 +1,16 @@
 module github.com/MysticalDevil/go_bittorrent
 
 go 1.23.1
+
+require (
+	github.com/MysticalDevil/gobittorrent/bencode v0.0.0
+	github.com/stretchr/testify v1.9.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/MysticalDevil/gobittorrent/bencode => ./bencode
@@ -0,0 +1,12 @@
+package magnet
+
+import "errors"
+
+var (
+	ErrScheme     = errors.New("not a magnet link")
+	ErrNoInfoHash = errors.New("magnet link has no info hash")
+	ErrInfoHash   = errors.New("invalid info hash")
+	ErrMultihash  = errors.New("unsupported multihash")
+	ErrLength     = errors.New("invalid exact length")
+	ErrSelectOnly = errors.New("invalid select-only range")
+)
@@ -0,0 +1,255 @@
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+)
+
+const (
+	btihPrefix = "urn:btih:"
+	btmhPrefix = "urn:btmh:"
+	// sha256Multihash is the multihash code and length prefix of a SHA-256
+	// digest, the only kind BEP 52 uses.
+	sha256Multihash = "1220"
+)
+
+// Magnet is a parsed magnet link (BEP 9, BEP 53). A hash is zero when the
+// link does not carry it.
+type Magnet struct {
+	InfoHash    metainfo.InfoHash
+	InfoHashV2  metainfo.InfoHashV2
+	DisplayName string
+	Trackers    []string
+	WebSeeds    []string
+	Peers       []string // x.pe host:port pairs
+	Length      int      // xl, 0 when unknown
+	SelectOnly  []FileRange
+	Params      url.Values // parameters not covered above
+}
+
+// FileRange is an inclusive range of file indices selected with so=.
+type FileRange struct {
+	First, Last int
+}
+
+func Parse(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "magnet" {
+		return nil, ErrScheme
+	}
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	m := &Magnet{}
+	for _, key := range slices.Sorted(maps.Keys(q)) {
+		values := q[key]
+		// BEP 9 allows numbered keys such as xt.1 and tr.2
+		base := key
+		if i := strings.LastIndexByte(key, '.'); i >= 0 && isDigits(key[i+1:]) {
+			base = key[:i]
+		}
+		switch base {
+		case "xt":
+			for _, v := range values {
+				err = m.parseExactTopic(v)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case "dn":
+			m.DisplayName = values[0]
+		case "tr":
+			m.Trackers = append(m.Trackers, values...)
+		case "ws":
+			m.WebSeeds = append(m.WebSeeds, values...)
+		case "x.pe":
+			m.Peers = append(m.Peers, values...)
+		case "xl":
+			m.Length, err = strconv.Atoi(values[0])
+			if err != nil || m.Length < 0 {
+				return nil, ErrLength
+			}
+		case "so":
+			m.SelectOnly, err = parseSelectOnly(values[0])
+			if err != nil {
+				return nil, err
+			}
+		default:
+			if m.Params == nil {
+				m.Params = make(url.Values)
+			}
+			m.Params[key] = values
+		}
+	}
+	if m.InfoHash.IsZero() && m.InfoHashV2 == (metainfo.InfoHashV2{}) {
+		return nil, ErrNoInfoHash
+	}
+	return m, nil
+}
+
+func (m *Magnet) parseExactTopic(v string) error {
+	switch {
+	case strings.HasPrefix(v, btihPrefix):
+		h, err := parseBtih(v[len(btihPrefix):])
+		if err != nil {
+			return err
+		}
+		m.InfoHash = h
+	case strings.HasPrefix(v, btmhPrefix):
+		mh := strings.ToLower(v[len(btmhPrefix):])
+		if !strings.HasPrefix(mh, sha256Multihash) {
+			return fmt.Errorf("%w: %s", ErrMultihash, v)
+		}
+		b, err := hex.DecodeString(mh[len(sha256Multihash):])
+		if err != nil || len(b) != len(m.InfoHashV2) {
+			return fmt.Errorf("%w: %s", ErrInfoHash, v)
+		}
+		copy(m.InfoHashV2[:], b)
+	}
+	// other exact topics (ed2k, sha1 ...) are not ours to interpret
+	return nil
+}
+
+// parseBtih accepts the 40 digit hex and 32 character base32 forms.
+func parseBtih(s string) (h metainfo.InfoHash, err error) {
+	switch len(s) {
+	case 40:
+		return metainfo.ParseInfoHash(s)
+	case 32:
+		b, err := base32.StdEncoding.DecodeString(strings.ToUpper(s))
+		if err != nil {
+			return h, fmt.Errorf("%w: %s", ErrInfoHash, s)
+		}
+		copy(h[:], b)
+		return h, nil
+	}
+	return h, fmt.Errorf("%w: %s", ErrInfoHash, s)
+}
+
+func parseSelectOnly(s string) ([]FileRange, error) {
+	var ranges []FileRange
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(first)
+		if err != nil || a < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrSelectOnly, part)
+		}
+		b := a
+		if isRange {
+			b, err = strconv.Atoi(last)
+			if err != nil || b < a {
+				return nil, fmt.Errorf("%w: %s", ErrSelectOnly, part)
+			}
+		}
+		ranges = append(ranges, FileRange{First: a, Last: b})
+	}
+	return ranges, nil
+}
+
+// Selected reports whether file i is wanted. Without so= every file is.
+func (m *Magnet) Selected(i int) bool {
+	if len(m.SelectOnly) == 0 {
+		return true
+	}
+	for _, r := range m.SelectOnly {
+		if i >= r.First && i <= r.Last {
+			return true
+		}
+	}
+	return false
+}
+
+// String builds the link. Exact topics come first and are left unescaped,
+// as clients expect.
+func (m *Magnet) String() string {
+	var params []string
+	if !m.InfoHash.IsZero() {
+		params = append(params, "xt="+btihPrefix+m.InfoHash.HexString())
+	}
+	if m.InfoHashV2 != (metainfo.InfoHashV2{}) {
+		params = append(params, "xt="+btmhPrefix+sha256Multihash+m.InfoHashV2.HexString())
+	}
+	add := func(key string, values ...string) {
+		for _, v := range values {
+			params = append(params, key+"="+url.QueryEscape(v))
+		}
+	}
+	if m.DisplayName != "" {
+		add("dn", m.DisplayName)
+	}
+	if m.Length > 0 {
+		add("xl", strconv.Itoa(m.Length))
+	}
+	add("tr", m.Trackers...)
+	add("ws", m.WebSeeds...)
+	add("x.pe", m.Peers...)
+	if len(m.SelectOnly) > 0 {
+		var parts []string
+		for _, r := range m.SelectOnly {
+			if r.First == r.Last {
+				parts = append(parts, strconv.Itoa(r.First))
+			} else {
+				parts = append(parts, strconv.Itoa(r.First)+"-"+strconv.Itoa(r.Last))
+			}
+		}
+		params = append(params, "so="+strings.Join(parts, ","))
+	}
+	for _, key := range slices.Sorted(maps.Keys(m.Params)) {
+		add(key, m.Params[key]...)
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
+
+// FromMetaInfo builds a link carrying every info hash of the torrent, its
+// name, size, trackers and web seeds.
+func FromMetaInfo(mi *metainfo.MetaInfo) (*Magnet, error) {
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, err
+	}
+	hs, err := mi.InfoHashes()
+	if err != nil {
+		return nil, err
+	}
+	m := &Magnet{
+		InfoHash:    hs.V1,
+		InfoHashV2:  hs.V2,
+		DisplayName: info.Name,
+		WebSeeds:    mi.URLList,
+	}
+	for _, f := range info.VisibleFiles() {
+		m.Length += f.Length
+	}
+	if !info.HasV1() {
+		files, err := info.TreeFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			m.Length += f.Length
+		}
+	}
+	for _, tier := range mi.AnnounceList {
+		m.Trackers = append(m.Trackers, tier...)
+	}
+	if len(m.Trackers) == 0 && mi.Announce != "" {
+		m.Trackers = []string{mi.Announce}
+	}
+	return m, nil
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
@@ -0,0 +1,133 @@
+package magnet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	hexHash    = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	base32Hash = "YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK"
+	v2Hash     = "caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("Hex", func(t *testing.T) {
+		m, err := Parse("magnet:?xt=urn:btih:" + hexHash + "&dn=Some+File.iso&tr=udp%3A%2F%2Ftracker.example%3A1337&tr=http://t2.example/announce&xl=1024")
+		require.NoError(t, err)
+		assert.Equal(t, hexHash, m.InfoHash.HexString())
+		assert.Equal(t, "Some File.iso", m.DisplayName)
+		assert.Equal(t, []string{"udp://tracker.example:1337", "http://t2.example/announce"}, m.Trackers)
+		assert.Equal(t, 1024, m.Length)
+	})
+
+	t.Run("Base32", func(t *testing.T) {
+		m, err := Parse("magnet:?xt=urn:btih:" + base32Hash)
+		require.NoError(t, err)
+		assert.Equal(t, hexHash, m.InfoHash.HexString())
+
+		m, err = Parse("magnet:?xt=urn:btih:" + strings.ToLower(base32Hash))
+		require.NoError(t, err)
+		assert.Equal(t, hexHash, m.InfoHash.HexString())
+	})
+
+	t.Run("V2AndHybrid", func(t *testing.T) {
+		m, err := Parse("magnet:?xt=urn:btmh:1220" + v2Hash)
+		require.NoError(t, err)
+		assert.True(t, m.InfoHash.IsZero())
+		assert.Equal(t, v2Hash, m.InfoHashV2.HexString())
+
+		m, err = Parse("magnet:?xt.1=urn:btih:" + hexHash + "&xt.2=urn:btmh:1220" + v2Hash)
+		require.NoError(t, err)
+		assert.Equal(t, hexHash, m.InfoHash.HexString())
+		assert.Equal(t, v2Hash, m.InfoHashV2.HexString())
+	})
+
+	t.Run("PeersSeedsSelection", func(t *testing.T) {
+		m, err := Parse("magnet:?xt=urn:btih:" + hexHash + "&x.pe=10.0.0.1:6881&x.pe=[2001:db8::1]:51413&x.pe.1=10.0.0.2:6881&x.pe.2=10.0.0.3:6881&ws=http://seed.example/f&so=0,2,4-6&tr.1=udp://a&foo=bar")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.1:6881", "[2001:db8::1]:51413", "10.0.0.2:6881", "10.0.0.3:6881"}, m.Peers)
+		assert.Equal(t, []string{"http://seed.example/f"}, m.WebSeeds)
+		assert.Equal(t, []FileRange{{0, 0}, {2, 2}, {4, 6}}, m.SelectOnly)
+		assert.Equal(t, []string{"udp://a"}, m.Trackers)
+		assert.Equal(t, []string{"bar"}, m.Params["foo"])
+		for i, want := range []bool{true, false, true, false, true, true, true, false} {
+			assert.Equal(t, want, m.Selected(i), "file %d", i)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		testCases := map[string]error{
+			"http://example.com/?xt=urn:btih:" + hexHash:     ErrScheme,
+			"magnet:?dn=nothing":                             ErrNoInfoHash,
+			"magnet:?xt=urn:btih:abc":                        ErrInfoHash,
+			"magnet:?xt=urn:btih:" + strings.Repeat("1", 32): ErrInfoHash,
+			"magnet:?xt=urn:btmh:1114" + hexHash:             ErrMultihash,
+			"magnet:?xt=urn:btmh:1220abcd":                   ErrInfoHash,
+			"magnet:?xt=urn:btih:" + hexHash + "&xl=-1":      ErrLength,
+			"magnet:?xt=urn:btih:" + hexHash + "&so=3-1":     ErrSelectOnly,
+			"magnet:?xt=urn:btih:" + hexHash + "&so=a":       ErrSelectOnly,
+		}
+		for uri, want := range testCases {
+			_, err := Parse(uri)
+			assert.ErrorIs(t, err, want, uri)
+		}
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	links := []string{
+		"magnet:?xt=urn:btih:" + hexHash,
+		"magnet:?xt=urn:btih:" + hexHash + "&xt=urn:btmh:1220" + v2Hash + "&dn=a+b%26c&xl=42&tr=udp%3A%2F%2Ft.example%3A80&ws=http%3A%2F%2Fs.example%2F&x.pe=1.2.3.4%3A5&so=0,2-3&x.foo=1",
+	}
+	for _, link := range links {
+		m, err := Parse(link)
+		require.NoError(t, err)
+		assert.Equal(t, link, m.String())
+		again, err := Parse(m.String())
+		require.NoError(t, err)
+		assert.Equal(t, m, again)
+	}
+}
+
+func TestFromMetaInfo(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.MkdirAll(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a"), []byte("hello"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "b"), []byte("world!"), 0o644))
+	b := &metainfo.Builder{
+		Version:  metainfo.Hybrid,
+		Trackers: [][]string{{"udp://a.example:1"}, {"udp://b.example:2"}},
+		WebSeeds: []string{"http://seed.example/"},
+	}
+	mi, err := b.Build(context.Background(), root)
+	require.NoError(t, err)
+
+	m, err := FromMetaInfo(mi)
+	require.NoError(t, err)
+	assert.Equal(t, mi.HashInfoBytes(), m.InfoHash)
+	assert.Equal(t, mi.HashInfoBytesV2(), m.InfoHashV2)
+	assert.Equal(t, "data", m.DisplayName)
+	assert.Equal(t, 11, m.Length, "padding is not part of the size")
+	assert.Equal(t, []string{"udp://a.example:1", "udp://b.example:2"}, m.Trackers)
+	assert.Equal(t, []string{"http://seed.example/"}, m.WebSeeds)
+
+	again, err := Parse(m.String())
+	require.NoError(t, err)
+	assert.Equal(t, m, again)
+
+	v1, err := metainfo.LoadFile("../metainfo/testdata/single-file.torrent")
+	require.NoError(t, err)
+	m, err = FromMetaInfo(v1)
+	require.NoError(t, err)
+	assert.Equal(t, "922f95069521e60d5b3c7518755700bb28eacd22", m.InfoHash.HexString())
+	assert.Equal(t, []string{"http://tracker.example.org:6969/announce"}, m.Trackers)
+	assert.True(t, strings.HasPrefix(m.String(), "magnet:?xt=urn:btih:922f95069521e60d5b3c7518755700bb28eacd22&dn="))
+}
@@ -0,0 +1,121 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File attribute flags of BEP 47.
+const (
+	AttrPadding    = 'p'
+	AttrExecutable = 'x'
+	AttrHidden     = 'h'
+	AttrSymlink    = 'l'
+)
+
+func (f *File) IsPadding() bool {
+	return strings.ContainsRune(f.Attr, AttrPadding)
+}
+
+func (f *File) IsExecutable() bool {
+	return strings.ContainsRune(f.Attr, AttrExecutable)
+}
+
+func (f *File) IsHidden() bool {
+	return strings.ContainsRune(f.Attr, AttrHidden)
+}
+
+func (f *File) IsSymlink() bool {
+	return strings.ContainsRune(f.Attr, AttrSymlink)
+}
+
+func (f *File) validateAttrs() error {
+	if f.SHA1 != "" && len(f.SHA1) != sha1.Size {
+		return fmt.Errorf("%w: sha1 must be %d bytes", ErrAttr, sha1.Size)
+	}
+	if !f.IsSymlink() {
+		return nil
+	}
+	if f.Length != 0 {
+		return fmt.Errorf("%w: symlink with data", ErrAttr)
+	}
+	err := validPath(f.SymlinkPath)
+	if err != nil {
+		return fmt.Errorf("%w: symlink target: %w", ErrAttr, err)
+	}
+	return nil
+}
+
+// VisibleFiles returns the files a user should see: the file list without
+// padding files, which only exist to align pieces.
+func (info *Info) VisibleFiles() []File {
+	var files []File
+	for _, f := range info.UpvertedFiles() {
+		if !f.IsPadding() {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// ApplyAttrs creates the symlinks and sets the executable bits the torrent
+// asks for on data downloaded into dir, where a multi-file torrent lives in
+// dir/Name and a single file is dir/Name. Regular file data must already be
+// in place.
+func (info *Info) ApplyAttrs(dir string) error {
+	root := filepath.Join(dir, info.Name)
+	for _, f := range info.VisibleFiles() {
+		name := root
+		if info.IsDir() {
+			name = filepath.Join(root, filepath.Join(f.Path...))
+		}
+		switch {
+		case f.IsSymlink():
+			err := makeSymlink(name, filepath.Join(root, filepath.Join(f.SymlinkPath...)))
+			if err != nil {
+				return err
+			}
+		case f.IsExecutable():
+			st, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			mode := st.Mode().Perm()
+			err = os.Chmod(name, mode|(mode&0o444)>>2)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func makeSymlink(name, target string) error {
+	rel, err := filepath.Rel(filepath.Dir(name), target)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+	st, err := os.Lstat(name)
+	if err == nil && st.Mode()&fs.ModeSymlink != 0 {
+		if old, err := os.Readlink(name); err == nil && old == rel {
+			// made by an earlier run
+			return nil
+		}
+	}
+	if err == nil && (st.Mode()&fs.ModeSymlink != 0 || st.Mode().IsRegular() && st.Size() == 0) {
+		// a zero-length placeholder left by the download, or a stale link
+		err = os.Remove(name)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Symlink(rel, name)
+}
@@ -0,0 +1,142 @@
+package metainfo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAttrs(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"bin/run.sh": "#!/bin/sh\n",
+		"doc.txt":    "hello",
+	})
+	require.NoError(t, os.Chmod(filepath.Join(root, "bin/run.sh"), 0o755))
+	require.NoError(t, os.Symlink("../doc.txt", filepath.Join(root, "bin/doc")))
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("out"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "linked.txt")))
+	// dangling symlinks are left out
+	require.NoError(t, os.Symlink("../missing.txt", filepath.Join(root, "bin/gone")))
+	require.NoError(t, os.Symlink(filepath.Join(t.TempDir(), "missing"), filepath.Join(root, "lost")))
+
+	for _, v := range []Version{V1, Hybrid} {
+		mi, err := (&Builder{PieceLength: 16 << 10, Version: v, FileHashes: true}).Build(context.Background(), root)
+		require.NoError(t, err)
+		data, err := mi.Bytes()
+		require.NoError(t, err)
+		mi, err = Load(bytes.NewReader(data))
+		require.NoError(t, err)
+		info, err := mi.UnmarshalInfo()
+		require.NoError(t, err)
+
+		files := info.VisibleFiles()
+		require.Len(t, files, 4)
+		assert.Equal(t, "bin/doc", files[0].DisplayPath())
+		assert.True(t, files[0].IsSymlink())
+		assert.Equal(t, []string{"doc.txt"}, files[0].SymlinkPath)
+		assert.Empty(t, files[0].SHA1)
+		assert.True(t, files[1].IsExecutable())
+		sum := sha1.Sum([]byte("#!/bin/sh\n"))
+		assert.Equal(t, string(sum[:]), files[1].SHA1)
+		assert.Equal(t, "doc.txt", files[2].DisplayPath())
+		assert.Empty(t, files[2].Attr)
+		// symlinks leaving the tree are followed
+		assert.Equal(t, "linked.txt", files[3].DisplayPath())
+		assert.Equal(t, 3, files[3].Length)
+		assert.False(t, files[3].IsSymlink())
+
+		if v == Hybrid {
+			assert.Greater(t, len(info.Files), len(files))
+			tree, err := info.TreeFiles()
+			require.NoError(t, err)
+			assert.Equal(t, "l", tree[0].Attr)
+			assert.Equal(t, []string{"doc.txt"}, tree[0].SymlinkPath)
+			assert.Equal(t, "x", tree[1].Attr)
+		}
+	}
+}
+
+func TestValidateAttrs(t *testing.T) {
+	base := func() *Info {
+		return &Info{
+			Name:        "x",
+			PieceLength: 16 << 10,
+			Pieces:      string(make([]byte, 20)),
+			Files: []File{
+				{Length: 10, Path: []string{"a"}},
+				{Attr: "l", Path: []string{"b"}, SymlinkPath: []string{"a"}},
+			},
+		}
+	}
+	require.NoError(t, base().Validate())
+
+	tests := []struct {
+		name  string
+		patch func(info *Info)
+	}{
+		{"ShortSHA1", func(info *Info) { info.Files[0].SHA1 = "abc" }},
+		{"SymlinkWithData", func(info *Info) { info.Files[1].Length = 1 }},
+		{"SymlinkEscapes", func(info *Info) { info.Files[1].SymlinkPath = []string{"..", "etc"} }},
+		{"SymlinkEmpty", func(info *Info) { info.Files[1].SymlinkPath = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := base()
+			tt.patch(info)
+			assert.ErrorIs(t, info.Validate(), ErrAttr)
+		})
+	}
+}
+
+func TestApplyAttrs(t *testing.T) {
+	dir := t.TempDir()
+	info := &Info{
+		Name: "pkg",
+		Files: []File{
+			{Length: 5, Path: []string{"data"}},
+			{Attr: "p", Length: 3, Path: []string{".pad", "3"}},
+			{Attr: "l", Path: []string{"sub", "link"}, SymlinkPath: []string{"data"}},
+			{Attr: "x", Length: 2, Path: []string{"tool"}},
+		},
+	}
+	writeFile := func(name string, data string) {
+		path := filepath.Join(dir, "pkg", filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	}
+	writeFile("data", "hello")
+	writeFile("tool", "go")
+	writeFile("sub/link", "")
+
+	require.NoError(t, info.ApplyAttrs(dir))
+
+	got, err := os.ReadFile(filepath.Join(dir, "pkg", "sub", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+	target, err := os.Readlink(filepath.Join(dir, "pkg", "sub", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "data"), target)
+
+	// applying again keeps a right link and replaces a wrong one
+	require.NoError(t, info.ApplyAttrs(dir))
+	link := filepath.Join(dir, "pkg", "sub", "link")
+	require.NoError(t, os.Remove(link))
+	require.NoError(t, os.Symlink("elsewhere", link))
+	require.NoError(t, info.ApplyAttrs(dir))
+	target, err = os.Readlink(link)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "data"), target)
+
+	st, err := os.Stat(filepath.Join(dir, "pkg", "tool"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), st.Mode().Perm())
+	_, err = os.Stat(filepath.Join(dir, "pkg", ".pad"))
+	assert.True(t, os.IsNotExist(err))
+}
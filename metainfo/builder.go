@@ -0,0 +1,514 @@
+package metainfo
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+	// targetPieces is the piece count automatic piece lengths aim for.
+	targetPieces = 1500
+)
+
+// Builder creates torrents from files on disk. The zero value builds a
+// public torrent without trackers and picks the piece length itself.
+type Builder struct {
+	PieceLength  int        // power of two; 0 chooses one from the total size
+	Trackers     [][]string // announce URLs by tier
+	WebSeeds     []string   // BEP 19 url-list
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // left out when zero
+	Private      bool
+	Source       string
+	Workers      int // hashing goroutines; 0 uses one per CPU
+	Version      Version
+	FileHashes   bool // add the BEP 47 sha1 of every v1 file
+	Merkle       bool // store a BEP 30 root hash instead of the pieces; V1 only
+
+	// Progress, when set, is called after each piece is hashed with the
+	// number of pieces done so far; v2 files of at most one piece and
+	// whole-file hashes count as one. Calls are serialized.
+	Progress func(done, total int)
+}
+
+// PieceLengthFor picks a power of two piece length that splits total bytes
+// into roughly targetPieces pieces, kept between 16 KiB and 16 MiB.
+func PieceLengthFor(total int) int {
+	pl := minPieceLength
+	for pl < maxPieceLength && pl*targetPieces < total {
+		pl *= 2
+	}
+	return pl
+}
+
+// Version selects which metadata formats a built torrent carries.
+type Version int
+
+const (
+	V1     Version = iota // BEP 3 pieces only
+	V2                    // BEP 52 file tree and piece layers only
+	Hybrid                // both, with v1 files padded to piece boundaries
+)
+
+type sourceFile struct {
+	path        string // location on disk, empty for padding
+	pad         bool
+	length      int
+	torrentPath []string
+	attr        string
+	symlink     []string // target relative to the torrent root
+	sha1        string
+}
+
+// Build walks root, a file or directory, and returns the metainfo of a
+// torrent holding it. Directory entries are ordered by path so the same
+// tree always yields the same info hash.
+func (b *Builder) Build(ctx context.Context, root string) (*MetaInfo, error) {
+	if b.Merkle && b.Version != V1 {
+		return nil, fmt.Errorf("%w: merkle torrents are v1 only", ErrRootHash)
+	}
+	// "." and ".." have no name of their own
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	// neither has the root of a file system
+	err = validName(filepath.Base(abs))
+	if err != nil {
+		return nil, err
+	}
+	files, isDir, err := collectFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, f := range files {
+		total += f.length
+	}
+	info := &Info{
+		Name:        filepath.Base(abs),
+		PieceLength: b.PieceLength,
+		Source:      b.Source,
+	}
+	if info.PieceLength == 0 {
+		info.PieceLength = PieceLengthFor(total)
+	}
+	if b.Private {
+		info.Private = 1
+	}
+	if !isDir {
+		files[0].torrentPath = []string{info.Name}
+	}
+
+	var jobs []hashJob
+	var pieces []byte
+	var v1Files []sourceFile
+	if b.Version != V2 {
+		v1Files = files
+		if isDir && b.Version == Hybrid {
+			v1Files = padFiles(files, info.PieceLength)
+		}
+		if b.FileHashes {
+			jobs = append(jobs, fileHashJobs(v1Files)...)
+		}
+		v1Total := 0
+		for _, f := range v1Files {
+			v1Total += f.length
+		}
+		pieces = make([]byte, (v1Total+info.PieceLength-1)/info.PieceLength*sha1.Size)
+		jobs = append(jobs, v1Jobs(v1Files, info.PieceLength, v1Total, pieces)...)
+	}
+	var tree []TreeFile
+	var layers [][]Hash256
+	if b.Version != V1 {
+		info.MetaVersion = 2
+		tree = make([]TreeFile, len(files))
+		layers = make([][]Hash256, len(files))
+		jobs = append(jobs, v2Jobs(files, info.PieceLength, tree, layers)...)
+	}
+	err = runJobs(ctx, jobs, b.Workers, info.PieceLength, b.Progress)
+	if err != nil {
+		return nil, err
+	}
+	if b.Version != V2 {
+		if isDir {
+			for _, f := range v1Files {
+				info.Files = append(info.Files, f.file())
+			}
+		} else {
+			info.Attr = files[0].attr
+			info.Length = total
+			info.SHA1 = files[0].sha1
+		}
+	}
+	info.Pieces = string(pieces)
+	if b.Merkle {
+		info.RootHash = string(rootHash(pieces))
+		info.Pieces = ""
+	}
+
+	mi := &MetaInfo{
+		Comment:   b.Comment,
+		CreatedBy: b.CreatedBy,
+		URLList:   b.WebSeeds,
+	}
+	if info.HasV2() {
+		mi.PieceLayers = make(map[string]string)
+		for i, layer := range layers {
+			if len(layer) == 0 {
+				continue
+			}
+			tree[i].PiecesRoot = RootFromPieceLayer(layer, info.PieceLength)
+			var joined []byte
+			for _, h := range layer {
+				joined = append(joined, h[:]...)
+			}
+			mi.PieceLayers[string(tree[i].PiecesRoot[:])] = string(joined)
+		}
+		err = info.SetFileTree(tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = info.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if !b.CreationDate.IsZero() {
+		mi.CreationDate = int(b.CreationDate.Unix())
+	}
+	mi.SetAnnounceList(b.Trackers)
+	err = mi.SetInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	return mi, nil
+}
+
+// collectFiles lists the regular files under root. Symlinks pointing
+// inside root become BEP 47 symlink entries, others are followed when they
+// lead to a regular file. Dangling symlinks have nothing to share and are
+// skipped.
+func collectFiles(root string) (files []sourceFile, isDir bool, err error) {
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, false, err
+	}
+	if !st.IsDir() {
+		return []sourceFile{{
+			path:   root,
+			length: int(st.Size()),
+			attr:   fileAttr(st.Mode()),
+		}}, false, nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, true, err
+	}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		f := sourceFile{
+			path:        path,
+			torrentPath: strings.Split(filepath.ToSlash(rel), "/"),
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := symlinkTarget(realRoot, path)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if target != nil {
+				f.attr = string(AttrSymlink)
+				f.symlink = target
+				files = append(files, f)
+				return nil
+			}
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f.length = int(fi.Size())
+		f.attr = fileAttr(fi.Mode())
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, true, err
+	}
+	if len(files) == 0 {
+		return nil, true, ErrNoFiles
+	}
+	slices.SortFunc(files, func(a, b sourceFile) int {
+		return slices.Compare(a.torrentPath, b.torrentPath)
+	})
+	return files, true, nil
+}
+
+// symlinkTarget returns the path of the target of the symlink at path
+// relative to realRoot, or nil when it resolves outside of it.
+func symlinkTarget(realRoot, path string) ([]string, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(realRoot, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, nil
+	}
+	return strings.Split(filepath.ToSlash(rel), "/"), nil
+}
+
+func fileAttr(mode fs.FileMode) string {
+	if mode&0o111 != 0 {
+		return string(AttrExecutable)
+	}
+	return ""
+}
+
+// padFiles inserts a BEP 47 padding file after every file but the last
+// that does not end on a piece boundary, so each file starts a new piece.
+func padFiles(files []sourceFile, pieceLength int) []sourceFile {
+	var out []sourceFile
+	for i, f := range files {
+		out = append(out, f)
+		if i == len(files)-1 || f.length%pieceLength == 0 {
+			continue
+		}
+		n := pieceLength - f.length%pieceLength
+		out = append(out, sourceFile{
+			pad:         true,
+			length:      n,
+			torrentPath: []string{".pad", strconv.Itoa(n)},
+		})
+	}
+	return out
+}
+
+func (f *sourceFile) file() File {
+	file := File{
+		Attr:        f.attr,
+		Length:      f.length,
+		Path:        f.torrentPath,
+		SHA1:        f.sha1,
+		SymlinkPath: f.symlink,
+	}
+	if f.pad {
+		file.Attr = string(AttrPadding)
+	}
+	return file
+}
+
+// hashJob hashes one piece using buf as scratch space for its data.
+type hashJob func(buf []byte) error
+
+// v1Jobs hashes the concatenation of files piece by piece into pieces.
+func v1Jobs(files []sourceFile, pieceLength, total int, pieces []byte) []hashJob {
+	starts := make([]int, len(files))
+	for i := 1; i < len(files); i++ {
+		starts[i] = starts[i-1] + files[i-1].length
+	}
+	jobs := make([]hashJob, len(pieces)/sha1.Size)
+	for i := range jobs {
+		jobs[i] = func(buf []byte) error {
+			n := min(pieceLength, total-i*pieceLength)
+			err := readAt(files, starts, buf[:n], i*pieceLength)
+			if err != nil {
+				return err
+			}
+			h := sha1.Sum(buf[:n])
+			copy(pieces[i*sha1.Size:], h[:])
+			return nil
+		}
+	}
+	return jobs
+}
+
+// fileHashJobs computes the SHA-1 of every file with data, which the v1
+// pieces do not identify on their own.
+func fileHashJobs(files []sourceFile) []hashJob {
+	var jobs []hashJob
+	for i := range files {
+		f := &files[i]
+		if f.pad || f.symlink != nil {
+			continue
+		}
+		jobs = append(jobs, func(buf []byte) error {
+			h := sha1.New()
+			for off := 0; off < f.length; off += len(buf) {
+				n := min(len(buf), f.length-off)
+				err := readFileAt(f.path, buf[:n], off)
+				if err != nil {
+					return err
+				}
+				h.Write(buf[:n])
+			}
+			f.sha1 = string(h.Sum(nil))
+			return nil
+		})
+	}
+	return jobs
+}
+
+// v2Jobs hashes every file on its own. A file of at most one piece gets its
+// pieces root directly, larger files get their piece layer filled in.
+func v2Jobs(files []sourceFile, pieceLength int, tree []TreeFile, layers [][]Hash256) []hashJob {
+	var jobs []hashJob
+	for i, f := range files {
+		tree[i] = TreeFile{
+			Path:        f.torrentPath,
+			Length:      f.length,
+			Attr:        f.attr,
+			SymlinkPath: f.symlink,
+		}
+		if f.length == 0 {
+			continue
+		}
+		if f.length <= pieceLength {
+			jobs = append(jobs, func(buf []byte) error {
+				err := readFileAt(f.path, buf[:f.length], 0)
+				if err != nil {
+					return err
+				}
+				tree[i].PiecesRoot = PiecesRoot(buf[:f.length], pieceLength)
+				return nil
+			})
+			continue
+		}
+		layers[i] = make([]Hash256, (f.length+pieceLength-1)/pieceLength)
+		for j := range layers[i] {
+			jobs = append(jobs, func(buf []byte) error {
+				n := min(pieceLength, f.length-j*pieceLength)
+				err := readFileAt(f.path, buf[:n], j*pieceLength)
+				if err != nil {
+					return err
+				}
+				layers[i][j] = pieceHashV2(buf[:n], pieceLength)
+				return nil
+			})
+		}
+	}
+	return jobs
+}
+
+// runJobs runs jobs on workers goroutines, one per CPU when 0, each with
+// its own scratch buffer of bufSize bytes, and stops at the first error or
+// cancellation. progress may be nil.
+func runJobs(ctx context.Context, jobs []hashJob, workers, bufSize int, progress func(done, total int)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		done     int
+		firstErr error
+	)
+	queue := make(chan hashJob)
+	for range cmp.Or(workers, runtime.NumCPU()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, bufSize)
+			for job := range queue {
+				err := job(buf)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					done++
+					if progress != nil {
+						progress(done, len(jobs))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if done == len(jobs) {
+		// a cancel after the last job leaves the result whole
+		return nil
+	}
+	return ctx.Err()
+}
+
+// readAt fills buf with the bytes at offset off of the concatenated files.
+func readAt(files []sourceFile, starts []int, buf []byte, off int) error {
+	i := sort.Search(len(files), func(i int) bool {
+		return starts[i]+files[i].length > off
+	})
+	for len(buf) > 0 && i < len(files) {
+		n := min(len(buf), files[i].length-(off-starts[i]))
+		if files[i].pad {
+			clear(buf[:n])
+		} else if n > 0 {
+			err := readFileAt(files[i].path, buf[:n], off-starts[i])
+			if err != nil {
+				return err
+			}
+		}
+		buf = buf[n:]
+		off += n
+		i++
+	}
+	if len(buf) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func readFileAt(name string, buf []byte, off int) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.ReadAt(buf, int64(off))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package metainfo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTree(t *testing.T, files map[string]string) string {
+	root := filepath.Join(t.TempDir(), "dataset")
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	}
+	return root
+}
+
+func joinedHashes(data []byte, pieceLength int) string {
+	var out []byte
+	for len(data) > 0 {
+		n := min(len(data), pieceLength)
+		h := sha1.Sum(data[:n])
+		out = append(out, h[:]...)
+		data = data[n:]
+	}
+	return string(out)
+}
+
+func TestBuildDirectory(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"b.txt":        "bbbbbbbbbbbbbbbbbbbbbbbbb",
+		"a/z.bin":      "0123456789",
+		"a/y.bin":      "",
+		"A.txt":        "upper",
+		"a/deep/x.bin": "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx",
+	})
+	b := &Builder{
+		PieceLength:  16,
+		Trackers:     [][]string{{"http://a.example/announce"}, {"udp://b.example:80", "udp://c.example:80"}},
+		WebSeeds:     []string{"http://seed.example/"},
+		Comment:      "test data",
+		CreatedBy:    "go-bittorrent",
+		CreationDate: time.Unix(1700000000, 0),
+		Private:      true,
+		Source:       "CI",
+		Workers:      3,
+	}
+	mi, err := b.Build(context.Background(), root)
+	require.NoError(t, err)
+
+	assert.Equal(t, "http://a.example/announce", mi.Announce)
+	assert.Len(t, mi.AnnounceList, 2)
+	assert.Equal(t, []string{"http://seed.example/"}, mi.URLList)
+	assert.Equal(t, 1700000000, mi.CreationDate)
+
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+	assert.Equal(t, "dataset", info.Name)
+	assert.True(t, info.IsPrivate())
+	assert.Equal(t, "CI", info.Source)
+	var paths []string
+	for _, f := range info.Files {
+		paths = append(paths, f.DisplayPath())
+	}
+	assert.Equal(t, []string{"A.txt", "a/deep/x.bin", "a/y.bin", "a/z.bin", "b.txt"}, paths)
+
+	content := "upper" + "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx" + "" + "0123456789" + "bbbbbbbbbbbbbbbbbbbbbbbbb"
+	assert.Equal(t, joinedHashes([]byte(content), 16), info.Pieces)
+
+	// canonical output loads back to the same info hash
+	data, err := mi.Bytes()
+	require.NoError(t, err)
+	loaded, err := Load(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, mi.HashInfoBytes(), loaded.HashInfoBytes())
+	again, err := loaded.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	// the same tree hashed with a different worker count is identical
+	b.Workers = 1
+	mi2, err := b.Build(context.Background(), root)
+	require.NoError(t, err)
+	assert.Equal(t, mi.HashInfoBytes(), mi2.HashInfoBytes())
+}
+
+func TestBuildSingleFile(t *testing.T) {
+	root := writeTree(t, map[string]string{"file.iso": "some iso content"})
+	var calls []int
+	b := &Builder{
+		PieceLength: 16 << 10,
+		Progress: func(done, total int) {
+			assert.Equal(t, 1, total)
+			calls = append(calls, done)
+		},
+	}
+	mi, err := b.Build(context.Background(), filepath.Join(root, "file.iso"))
+	require.NoError(t, err)
+	assert.Empty(t, mi.Announce)
+	assert.Nil(t, mi.AnnounceList)
+	assert.Equal(t, []int{1}, calls)
+
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	assert.Equal(t, "file.iso", info.Name)
+	assert.Equal(t, 16, info.Length)
+	assert.Equal(t, joinedHashes([]byte("some iso content"), 16<<10), info.Pieces)
+}
+
+func TestBuildRelativeRoot(t *testing.T) {
+	root := writeTree(t, map[string]string{"a/x.bin": "xx"})
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(filepath.Join(root, "a")))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, dir := range []string{".", "..", "../a"} {
+		mi, err := (&Builder{}).Build(context.Background(), dir)
+		require.NoError(t, err, dir)
+		info, err := mi.UnmarshalInfo()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Base(filepath.Join(root, "a", dir)), info.Name, dir)
+	}
+}
+
+func TestBuildCancel(t *testing.T) {
+	root := writeTree(t, map[string]string{"big.bin": string(make([]byte, 1<<20))})
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Builder{
+		PieceLength: 16 << 10,
+		Workers:     2,
+		Progress: func(done, total int) {
+			if done == 3 {
+				cancel()
+			}
+		},
+	}
+	_, err := b.Build(ctx, root)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// once every piece is hashed, a cancel comes too late to matter
+	ctx, cancel = context.WithCancel(context.Background())
+	b.Progress = func(done, total int) {
+		if done == total {
+			cancel()
+		}
+	}
+	mi, err := b.Build(ctx, root)
+	require.NoError(t, err)
+	assert.NotEmpty(t, mi.InfoBytes)
+}
+
+func TestBuildErrors(t *testing.T) {
+	_, err := (&Builder{}).Build(context.Background(), filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = (&Builder{}).Build(context.Background(), t.TempDir())
+	assert.Equal(t, ErrNoFiles, err)
+
+	// the root directory has no name to give the torrent
+	_, err = (&Builder{}).Build(context.Background(), string(filepath.Separator))
+	assert.ErrorIs(t, err, ErrName)
+}
+
+func TestPieceLengthFor(t *testing.T) {
+	assert.Equal(t, 16<<10, PieceLengthFor(0))
+	assert.Equal(t, 16<<10, PieceLengthFor(1<<20))
+	assert.Equal(t, 256<<10, PieceLengthFor(300<<20))
+	assert.Equal(t, 4<<20, PieceLengthFor(4<<30))
+	assert.Equal(t, 16<<20, PieceLengthFor(1<<40))
+}
+
+func TestSetAnnounceList(t *testing.T) {
+	mi := &MetaInfo{}
+	mi.SetAnnounceList([][]string{{}, {"udp://a"}})
+	assert.Equal(t, "udp://a", mi.Announce)
+	assert.Nil(t, mi.AnnounceList)
+
+	mi.SetAnnounceList([][]string{{"udp://a", "udp://b"}, {}})
+	assert.Equal(t, [][]string{{"udp://a", "udp://b"}}, mi.AnnounceList)
+}
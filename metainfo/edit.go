@@ -0,0 +1,80 @@
+package metainfo
+
+import "slices"
+
+// Edit lists changes to make to a torrent; nil fields are left alone. An
+// empty, non-nil Trackers or URLList removes them. Private and Source live
+// in the info dictionary, so changing them gives the torrent a new
+// identity.
+type Edit struct {
+	Trackers  [][]string // announce and announce-list, by tier
+	Comment   *string
+	CreatedBy *string
+	URLList   []string
+	Private   *bool
+	Source    *string
+}
+
+// EditResult reports the info hashes of a torrent before and after Apply.
+type EditResult struct {
+	Old, New InfoHashes
+}
+
+func (r EditResult) InfoChanged() bool {
+	return r.Old != r.New
+}
+
+// Apply makes the changes of e. Outer fields are edited in place and
+// keys the edit does not name, known or not, keep their exact encoding;
+// the info dictionary is only re-encoded when one of its fields actually
+// changes value.
+func (mi *MetaInfo) Apply(e *Edit) (res EditResult, err error) {
+	res.Old, err = mi.InfoHashes()
+	if err != nil {
+		return res, err
+	}
+	if e.Trackers != nil {
+		mi.SetAnnounceList(e.Trackers)
+	}
+	if e.Comment != nil {
+		mi.Comment = *e.Comment
+	}
+	if e.CreatedBy != nil {
+		mi.CreatedBy = *e.CreatedBy
+	}
+	if e.URLList != nil {
+		mi.URLList = slices.Clone(e.URLList)
+		if len(mi.URLList) == 0 {
+			mi.URLList = nil
+		}
+	}
+
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return res, err
+	}
+	changed := false
+	if e.Private != nil && *e.Private != info.IsPrivate() {
+		info.Private = 0
+		if *e.Private {
+			info.Private = 1
+		}
+		changed = true
+	}
+	if e.Source != nil && *e.Source != info.Source {
+		info.Source = *e.Source
+		changed = true
+	}
+	if changed {
+		err = info.Validate()
+		if err != nil {
+			return res, err
+		}
+		err = mi.SetInfo(info)
+		if err != nil {
+			return res, err
+		}
+	}
+	res.New, err = mi.InfoHashes()
+	return res, err
+}
@@ -0,0 +1,101 @@
+package metainfo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	pieces := strings.Repeat("x", 20)
+	info := "d6:lengthi16e4:name1:a12:piece lengthi16e6:pieces20:" + pieces + "7:x-quirkli1e3:abce" + "e"
+	in := "d8:announce13:http://old/an7:comment3:old4:info" + info + "9:x-unknownd1:ki-3eee"
+
+	load := func(t *testing.T) *MetaInfo {
+		mi, err := Load(strings.NewReader(in))
+		require.NoError(t, err)
+		return mi
+	}
+	encode := func(t *testing.T, mi *MetaInfo) string {
+		buf := new(bytes.Buffer)
+		require.NoError(t, mi.Write(buf))
+		return buf.String()
+	}
+	str := func(s string) *string { return &s }
+	yes := true
+	no := false
+
+	t.Run("Outer", func(t *testing.T) {
+		mi := load(t)
+		res, err := mi.Apply(&Edit{
+			Trackers:  [][]string{{"http://new/a"}, {"udp://new:1/b"}},
+			Comment:   str(""),
+			CreatedBy: str("edit"),
+			URLList:   []string{"http://seed/"},
+		})
+		require.NoError(t, err)
+		assert.False(t, res.InfoChanged())
+		assert.Equal(t, HashBytes([]byte(info)), res.New.V1)
+		assert.Equal(t, "d8:announce12:http://new/a13:announce-listll12:http://new/ael13:udp://new:1/bee"+
+			"10:created by4:edit4:info"+info+"8:url-listl12:http://seed/e9:x-unknownd1:ki-3eee", encode(t, mi))
+	})
+
+	t.Run("NoOpInfo", func(t *testing.T) {
+		mi := load(t)
+		res, err := mi.Apply(&Edit{Private: &no, Source: str("")})
+		require.NoError(t, err)
+		assert.False(t, res.InfoChanged())
+		assert.Equal(t, in, encode(t, mi))
+	})
+
+	t.Run("Info", func(t *testing.T) {
+		mi := load(t)
+		res, err := mi.Apply(&Edit{Private: &yes, Source: str("NEW")})
+		require.NoError(t, err)
+		assert.True(t, res.InfoChanged())
+		assert.Equal(t, HashBytes([]byte(info)), res.Old.V1)
+		assert.Equal(t, mi.HashInfoBytes(), res.New.V1)
+		assert.Equal(t, "d6:lengthi16e4:name1:a12:piece lengthi16e6:pieces20:"+pieces+
+			"7:privatei1e6:source3:NEW7:x-quirkli1e3:abcee", string(mi.InfoBytes))
+		assert.Contains(t, encode(t, mi), "9:x-unknownd1:ki-3eee")
+
+		res, err = mi.Apply(&Edit{Private: &no, Source: str("")})
+		require.NoError(t, err)
+		assert.True(t, res.InfoChanged())
+		assert.Equal(t, HashBytes([]byte(info)), res.New.V1)
+	})
+
+	t.Run("KeptEncoding", func(t *testing.T) {
+		in := "d8:announce13:http://old/an4:info" + info + "12:piece layersd1:b1:x1:a1:ye8:url-list12:http://seed/e"
+		mi, err := Load(strings.NewReader(in))
+		require.NoError(t, err)
+		// BEP 19 allows a single web seed as a string
+		assert.Equal(t, []string{"http://seed/"}, mi.URLList)
+		assert.Equal(t, in, encode(t, mi))
+
+		_, err = mi.Apply(&Edit{Comment: str("c")})
+		require.NoError(t, err)
+		assert.Equal(t, "d8:announce13:http://old/an7:comment1:c4:info"+info+
+			"12:piece layersd1:b1:x1:a1:ye8:url-list12:http://seed/e", encode(t, mi))
+
+		_, err = mi.Apply(&Edit{URLList: []string{"http://other/"}})
+		require.NoError(t, err)
+		assert.Contains(t, encode(t, mi), "8:url-listl13:http://other/ee")
+		_, err = mi.Apply(&Edit{URLList: []string{}})
+		require.NoError(t, err)
+		assert.NotContains(t, encode(t, mi), "url-list")
+	})
+
+	t.Run("ClearLists", func(t *testing.T) {
+		mi, err := LoadFile("testdata/multi-file.torrent")
+		require.NoError(t, err)
+		_, err = mi.Apply(&Edit{Trackers: [][]string{}, URLList: []string{}})
+		require.NoError(t, err)
+		assert.Empty(t, mi.Announce)
+		assert.Nil(t, mi.AnnounceList)
+		assert.Nil(t, mi.URLList)
+	})
+}
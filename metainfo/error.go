@@ -0,0 +1,25 @@
+package metainfo
+
+import "errors"
+
+var (
+	ErrNoInfo          = errors.New("missing info dictionary")
+	ErrPieceLength     = errors.New("invalid piece length")
+	ErrPiecesLength    = errors.New("pieces length is not a multiple of 20")
+	ErrPieceCount      = errors.New("piece count does not match total length")
+	ErrLengthAndFiles  = errors.New("info has both length and files")
+	ErrNoFiles         = errors.New("info has an empty file list")
+	ErrFileLength      = errors.New("negative file length")
+	ErrName            = errors.New("invalid name")
+	ErrPath            = errors.New("invalid path")
+	ErrPrivate         = errors.New("private must be 0 or 1")
+	ErrPieceOutOfRange = errors.New("piece index out of range")
+	ErrFileTree        = errors.New("invalid file tree")
+	ErrPieceLayer      = errors.New("invalid piece layer")
+	ErrHybrid          = errors.New("v1 and v2 metadata disagree")
+	ErrAttr            = errors.New("invalid file attributes")
+	ErrRange           = errors.New("byte range out of bounds")
+	ErrRootHash        = errors.New("invalid root hash")
+	ErrHashChain       = errors.New("hash chain does not lead to the root hash")
+	ErrNoPieceHashes   = errors.New("merkle torrent piece hashes come from hash chains")
+)
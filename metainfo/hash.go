@@ -0,0 +1,39 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+)
+
+// InfoHash is the SHA-1 hash of the bencoded info dictionary that
+// identifies a v1 torrent.
+type InfoHash [sha1.Size]byte
+
+func HashBytes(b []byte) InfoHash {
+	return sha1.Sum(b)
+}
+
+func (h InfoHash) Bytes() []byte {
+	return h[:]
+}
+
+func (h InfoHash) HexString() string {
+	return hex.EncodeToString(h[:])
+}
+
+func (h InfoHash) String() string {
+	return h.HexString()
+}
+
+func (h InfoHash) IsZero() bool {
+	return h == InfoHash{}
+}
+
+func ParseInfoHash(s string) (h InfoHash, err error) {
+	if hex.DecodedLen(len(s)) != len(h) {
+		return h, errors.New("info hash must be 40 hex digits")
+	}
+	_, err = hex.Decode(h[:], []byte(s))
+	return
+}
@@ -0,0 +1,93 @@
+package metainfo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildHybrid(t *testing.T) {
+	a := strings.Repeat("a", 20000)
+	c := strings.Repeat("c", 16<<10)
+	root := writeTree(t, map[string]string{
+		"a.bin": a,
+		"b.txt": "bb",
+		"c.bin": c,
+		"d.txt": "ddd",
+	})
+	mi, err := (&Builder{PieceLength: 16 << 10, Version: Hybrid}).Build(context.Background(), root)
+	require.NoError(t, err)
+
+	data, err := mi.Bytes()
+	require.NoError(t, err)
+	mi, err = Load(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, mi.VerifyPieceLayers())
+
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+	assert.True(t, info.IsHybrid())
+	var paths []string
+	for _, f := range info.Files {
+		paths = append(paths, f.DisplayPath())
+	}
+	assert.Equal(t, []string{"a.bin", ".pad/12768", "b.txt", ".pad/16382", "c.bin", "d.txt"}, paths)
+	assert.True(t, info.Files[1].IsPadding())
+	assert.False(t, info.Files[2].IsPadding())
+
+	padded := a + strings.Repeat("\x00", 12768) + "bb" + strings.Repeat("\x00", 16382) + c + "ddd"
+	assert.Equal(t, joinedHashes([]byte(padded), 16<<10), info.Pieces)
+
+	hs, err := mi.InfoHashes()
+	require.NoError(t, err)
+	assert.Equal(t, InfoHash(sha1.Sum(mi.InfoBytes)), hs.V1)
+	assert.Equal(t, InfoHashV2(sha256.Sum256(mi.InfoBytes)), hs.V2)
+
+	t.Run("Mismatch", func(t *testing.T) {
+		bad := *info
+		bad.Files = append([]File(nil), info.Files...)
+		bad.Files[2].Length = 3
+		bad.Files[3].Length = 16381
+		assert.ErrorIs(t, bad.Validate(), ErrHybrid)
+
+		bad.Files = append([]File(nil), info.Files...)
+		bad.Files = append(bad.Files[:1], bad.Files[2:]...)
+		bad.Pieces = info.Pieces[:len(info.Pieces)-sha1.Size]
+		assert.ErrorIs(t, bad.Validate(), ErrHybrid)
+
+		bad.Files = append([]File(nil), info.Files[:4]...)
+		bad.Pieces = info.Pieces[:3*sha1.Size]
+		assert.ErrorIs(t, bad.Validate(), ErrHybrid)
+	})
+}
+
+func TestBuildHybridSingleFile(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.bin": "abc"})
+	mi, err := (&Builder{Version: Hybrid}).Build(context.Background(), root+"/a.bin")
+	require.NoError(t, err)
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+	assert.True(t, info.IsHybrid())
+	assert.Equal(t, 3, info.Length)
+
+	info.Length = 4
+	assert.ErrorIs(t, info.Validate(), ErrHybrid)
+	info.Name = "b.bin"
+	info.Length = 3
+	assert.ErrorIs(t, info.Validate(), ErrHybrid)
+}
+
+func TestInfoHashesV1Only(t *testing.T) {
+	mi, err := LoadFile("testdata/single-file.torrent")
+	require.NoError(t, err)
+	hs, err := mi.InfoHashes()
+	require.NoError(t, err)
+	assert.Equal(t, mi.HashInfoBytes(), hs.V1)
+	assert.Equal(t, InfoHashV2{}, hs.V2)
+}
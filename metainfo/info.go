@@ -0,0 +1,213 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+// Info is the info dictionary of a torrent. In v1 a single-file torrent
+// sets Length, a multi-file torrent sets Files and uses Name as the
+// directory. A v2 torrent (BEP 52) sets MetaVersion 2 and FileTree instead,
+// and a hybrid torrent carries both.
+type Info struct {
+	Attr        string                        `bencode:"attr,omitempty"`
+	FileTree    bencode.RawMessage            `bencode:"file tree,omitempty"`
+	Files       []File                        `bencode:"files,omitempty"`
+	Length      int                           `bencode:"length,omitempty"`
+	MetaVersion int                           `bencode:"meta version,omitempty"`
+	Name        string                        `bencode:"name"`
+	PieceLength int                           `bencode:"piece length"`
+	Pieces      string                        `bencode:"pieces,omitempty"`
+	Private     int                           `bencode:"private,omitempty"`
+	RootHash    string                        `bencode:"root hash,omitempty"`
+	SHA1        string                        `bencode:"sha1,omitempty"`
+	Source      string                        `bencode:"source,omitempty"`
+	SymlinkPath []string                      `bencode:"symlink path,omitempty"`
+	Extra       map[string]bencode.RawMessage `bencode:",extra"`
+}
+
+// File is an entry of a v1 file list. Attr, SHA1 and SymlinkPath are the
+// BEP 47 extensions.
+type File struct {
+	Attr        string                        `bencode:"attr,omitempty"`
+	Length      int                           `bencode:"length"`
+	Path        []string                      `bencode:"path"`
+	SHA1        string                        `bencode:"sha1,omitempty"`
+	SymlinkPath []string                      `bencode:"symlink path,omitempty"`
+	Extra       map[string]bencode.RawMessage `bencode:",extra"`
+}
+
+func (f *File) DisplayPath() string {
+	return strings.Join(f.Path, "/")
+}
+
+func (info *Info) IsDir() bool {
+	return len(info.Files) != 0
+}
+
+func (info *Info) IsPrivate() bool {
+	return info.Private == 1
+}
+
+// PeerSources selects the ways a client finds peers for a torrent.
+type PeerSources struct {
+	Trackers bool
+	DHT      bool
+	PEX      bool
+	LSD      bool
+}
+
+// PeerSources narrows enabled to the sources the torrent permits: BEP 27
+// keeps a private torrent to its trackers, so DHT, peer exchange and local
+// service discovery are turned off for it.
+func (info *Info) PeerSources(enabled PeerSources) PeerSources {
+	if info.IsPrivate() {
+		enabled.DHT, enabled.PEX, enabled.LSD = false, false, false
+	}
+	return enabled
+}
+
+// UpvertedFiles returns the file list as laid out in the pieces, padding
+// included, with a single-file torrent presented as one file whose path is
+// empty.
+func (info *Info) UpvertedFiles() []File {
+	if info.IsDir() {
+		return info.Files
+	}
+	return []File{{
+		Attr:        info.Attr,
+		Length:      info.Length,
+		SHA1:        info.SHA1,
+		SymlinkPath: info.SymlinkPath,
+	}}
+}
+
+func (info *Info) TotalLength() (total int) {
+	for _, f := range info.UpvertedFiles() {
+		total += f.Length
+	}
+	return
+}
+
+func (info *Info) NumPieces() int {
+	if info.IsMerkle() {
+		return (info.TotalLength() + info.PieceLength - 1) / info.PieceLength
+	}
+	return len(info.Pieces) / sha1.Size
+}
+
+// PieceHash returns the SHA-1 hash the data of piece i must match. Merkle
+// torrents do not list them; see CheckMerklePiece.
+func (info *Info) PieceHash(i int) (h [sha1.Size]byte, err error) {
+	if i < 0 || i >= info.NumPieces() {
+		return h, ErrPieceOutOfRange
+	}
+	if info.IsMerkle() {
+		return h, ErrNoPieceHashes
+	}
+	copy(h[:], info.Pieces[i*sha1.Size:])
+	return h, nil
+}
+
+// PieceLen returns the length of piece i; only the last piece may be short.
+func (info *Info) PieceLen(i int) int {
+	if i < 0 || i >= info.NumPieces() {
+		return 0
+	}
+	if i == info.NumPieces()-1 {
+		return info.TotalLength() - i*info.PieceLength
+	}
+	return info.PieceLength
+}
+
+func (info *Info) Validate() error {
+	err := validName(info.Name)
+	if err != nil {
+		return err
+	}
+	if info.PieceLength <= 0 {
+		return ErrPieceLength
+	}
+	if info.Private != 0 && info.Private != 1 {
+		return ErrPrivate
+	}
+	if info.IsMerkle() {
+		err = info.validateMerkle()
+		if err != nil {
+			return err
+		}
+	}
+	if info.HasV2() {
+		err = validateV2(info)
+		if err != nil {
+			return err
+		}
+	}
+	if !info.HasV1() {
+		return nil
+	}
+	if len(info.Pieces)%sha1.Size != 0 {
+		return ErrPiecesLength
+	}
+	if info.IsDir() && info.Length != 0 {
+		return ErrLengthAndFiles
+	}
+	if info.Files != nil && len(info.Files) == 0 {
+		return ErrNoFiles
+	}
+	for i, f := range info.UpvertedFiles() {
+		if f.Length < 0 {
+			return fmt.Errorf("file %d: %w", i, ErrFileLength)
+		}
+		if info.IsDir() {
+			err = validPath(f.Path)
+			if err != nil {
+				return fmt.Errorf("file %d: %w", i, err)
+			}
+		}
+		err = f.validateAttrs()
+		if err != nil {
+			return fmt.Errorf("file %d: %w", i, err)
+		}
+	}
+	if info.Length < 0 {
+		return ErrFileLength
+	}
+	total := info.TotalLength()
+	if info.NumPieces() != (total+info.PieceLength-1)/info.PieceLength {
+		return ErrPieceCount
+	}
+	if info.HasV2() {
+		return info.checkHybrid()
+	}
+	return nil
+}
+
+func validName(name string) error {
+	if validComponent(name) {
+		return nil
+	}
+	return fmt.Errorf("%w %q", ErrName, name)
+}
+
+// validPath rejects paths that could escape the torrent's directory when
+// joined onto it.
+func validPath(path []string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("%w: empty", ErrPath)
+	}
+	for _, c := range path {
+		if !validComponent(c) {
+			return fmt.Errorf("%w %q", ErrPath, strings.Join(path, "/"))
+		}
+	}
+	return nil
+}
+
+func validComponent(c string) bool {
+	return c != "" && c != "." && c != ".." &&
+		!strings.ContainsAny(c, "/\\\x00")
+}
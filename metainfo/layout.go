@@ -0,0 +1,141 @@
+package metainfo
+
+import "sort"
+
+// Span is the part of one file that a range of torrent data falls on.
+type Span struct {
+	File   int // index into Layout.Files
+	Offset int // offset within the file
+	Length int
+}
+
+// Layout maps pieces onto files. For v1 and hybrid torrents the files,
+// padding included, follow each other as in the v1 pieces; in a v2-only
+// torrent every file starts on a piece boundary and the last piece of a
+// file may be short.
+type Layout struct {
+	PieceLength int
+	files       []File
+	starts      []int // offset of each file in the piece space
+	numPieces   int
+}
+
+func NewLayout(info *Info) (*Layout, error) {
+	l := &Layout{PieceLength: info.PieceLength}
+	if l.PieceLength <= 0 {
+		return nil, ErrPieceLength
+	}
+	end := 0
+	if info.HasV1() {
+		l.files = info.UpvertedFiles()
+		for _, f := range l.files {
+			l.starts = append(l.starts, end)
+			end += f.Length
+		}
+	} else {
+		tree, err := info.TreeFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range tree {
+			l.files = append(l.files, File{
+				Attr:        f.Attr,
+				Length:      f.Length,
+				Path:        f.Path,
+				SymlinkPath: f.SymlinkPath,
+			})
+			l.starts = append(l.starts, end)
+			end += (f.Length + l.PieceLength - 1) / l.PieceLength * l.PieceLength
+		}
+	}
+	l.numPieces = (end + l.PieceLength - 1) / l.PieceLength
+	return l, nil
+}
+
+func (l *Layout) Files() []File {
+	return l.files
+}
+
+func (l *Layout) NumPieces() int {
+	return l.numPieces
+}
+
+// FileOffset returns where file i starts in the piece space.
+func (l *Layout) FileOffset(i int) int {
+	return l.starts[i]
+}
+
+// PieceLen returns the number of data bytes in piece i, or 0 when i is out
+// of range.
+func (l *Layout) PieceLen(i int) (n int) {
+	for _, s := range l.PieceSpans(i) {
+		n += s.Length
+	}
+	return
+}
+
+// PieceSpans returns the spans piece i covers, in file order. Empty files
+// never appear.
+func (l *Layout) PieceSpans(i int) []Span {
+	if i < 0 || i >= l.numPieces {
+		return nil
+	}
+	return l.spans(i*l.PieceLength, l.PieceLength)
+}
+
+// Spans returns the spans covered by length bytes at offset begin of
+// piece, which must lie within the piece's data.
+func (l *Layout) Spans(piece, begin, length int) ([]Span, error) {
+	if piece < 0 || piece >= l.numPieces {
+		return nil, ErrPieceOutOfRange
+	}
+	if begin < 0 || length < 0 || begin+length > l.PieceLen(piece) {
+		return nil, ErrRange
+	}
+	return l.spans(piece*l.PieceLength+begin, length), nil
+}
+
+// spans collects the file data in n bytes of piece space at off, skipping
+// the gaps after files in a v2 layout.
+func (l *Layout) spans(off, n int) []Span {
+	var spans []Span
+	i := sort.Search(len(l.files), func(i int) bool {
+		return l.starts[i]+l.files[i].Length > off
+	})
+	for ; n > 0 && i < len(l.files); i++ {
+		if gap := l.starts[i] - off; gap > 0 {
+			if gap >= n {
+				break
+			}
+			off += gap
+			n -= gap
+		}
+		fileOff := off - l.starts[i]
+		m := min(n, l.files[i].Length-fileOff)
+		if m <= 0 {
+			continue
+		}
+		spans = append(spans, Span{File: i, Offset: fileOff, Length: m})
+		off += m
+		n -= m
+	}
+	return spans
+}
+
+// PieceRange returns the half-open range of pieces holding length bytes at
+// offset off of file i. The range is empty when length is 0.
+func (l *Layout) PieceRange(i, off, length int) (begin, end int, err error) {
+	if i < 0 || i >= len(l.files) {
+		return 0, 0, ErrRange
+	}
+	if off < 0 || length < 0 || off+length > l.files[i].Length {
+		return 0, 0, ErrRange
+	}
+	start := l.starts[i] + off
+	begin = start / l.PieceLength
+	if length == 0 {
+		return begin, begin, nil
+	}
+	end = (start + length + l.PieceLength - 1) / l.PieceLength
+	return begin, end, nil
+}
@@ -0,0 +1,164 @@
+package metainfo
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// naiveLayout records for every byte of the piece space which file byte it
+// holds, with -1 for the gaps of a v2 layout.
+type naiveLayout struct {
+	file, offset []int
+}
+
+func (n *naiveLayout) add(file, length, align int) {
+	for j := range length {
+		n.file = append(n.file, file)
+		n.offset = append(n.offset, j)
+	}
+	for align > 0 && len(n.file)%align != 0 {
+		n.file = append(n.file, -1)
+		n.offset = append(n.offset, -1)
+	}
+}
+
+func (n *naiveLayout) pieceSpans(piece, pieceLength int) []Span {
+	var spans []Span
+	for pos := piece * pieceLength; pos < min((piece+1)*pieceLength, len(n.file)); pos++ {
+		f := n.file[pos]
+		if f < 0 {
+			continue
+		}
+		if k := len(spans) - 1; k >= 0 && spans[k].File == f && spans[k].Offset+spans[k].Length == n.offset[pos] {
+			spans[k].Length++
+			continue
+		}
+		spans = append(spans, Span{File: f, Offset: n.offset[pos], Length: 1})
+	}
+	return spans
+}
+
+func (n *naiveLayout) pieceRange(file, off, length, pieceLength int) (begin, end int) {
+	begin, end = -1, -1
+	for pos := range n.file {
+		if n.file[pos] == file && n.offset[pos] >= off && n.offset[pos] < off+length {
+			if begin < 0 {
+				begin = pos / pieceLength
+			}
+			end = pos/pieceLength + 1
+		}
+	}
+	return
+}
+
+func randomLengths(r *rand.Rand) []int {
+	lengths := make([]int, 1+r.IntN(8))
+	for i := range lengths {
+		if r.IntN(5) > 0 {
+			lengths[i] = r.IntN(60)
+		}
+	}
+	return lengths
+}
+
+func TestLayoutRandom(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for iter := range 300 {
+		lengths := randomLengths(r)
+		pieceLength := 1 + r.IntN(24)
+		v2 := iter%2 == 1
+
+		info := &Info{Name: "t", PieceLength: pieceLength}
+		var naive naiveLayout
+		if v2 {
+			info.MetaVersion = 2
+			var tree []TreeFile
+			for i, n := range lengths {
+				tree = append(tree, TreeFile{Path: []string{fmt.Sprintf("f%02d", i)}, Length: n})
+				naive.add(i, n, pieceLength)
+			}
+			require.NoError(t, info.SetFileTree(tree))
+		} else {
+			for i, n := range lengths {
+				info.Files = append(info.Files, File{Length: n, Path: []string{fmt.Sprintf("f%02d", i)}})
+				naive.add(i, n, 0)
+			}
+		}
+		name := fmt.Sprintf("%d/%v/pl%d/v2=%v", iter, lengths, pieceLength, v2)
+
+		l, err := NewLayout(info)
+		require.NoError(t, err, name)
+		require.Equal(t, (len(naive.file)+pieceLength-1)/pieceLength, l.NumPieces(), name)
+
+		for p := range l.NumPieces() {
+			want := naive.pieceSpans(p, pieceLength)
+			require.Equal(t, want, l.PieceSpans(p), "%s piece %d", name, p)
+			pieceLen := 0
+			for _, s := range want {
+				pieceLen += s.Length
+			}
+			require.Equal(t, pieceLen, l.PieceLen(p), "%s piece %d", name, p)
+
+			begin := r.IntN(pieceLen + 1)
+			length := r.IntN(pieceLen - begin + 1)
+			spans, err := l.Spans(p, begin, length)
+			require.NoError(t, err)
+			total := 0
+			for _, s := range spans {
+				require.Greater(t, s.Length, 0)
+				total += s.Length
+			}
+			require.Equal(t, length, total, "%s piece %d", name, p)
+		}
+
+		for f, n := range lengths {
+			off := r.IntN(n + 1)
+			length := r.IntN(n - off + 1)
+			begin, end, err := l.PieceRange(f, off, length)
+			require.NoError(t, err)
+			if length == 0 {
+				assert.Equal(t, begin, end, name)
+				continue
+			}
+			wantBegin, wantEnd := naive.pieceRange(f, off, length, pieceLength)
+			require.Equal(t, [2]int{wantBegin, wantEnd}, [2]int{begin, end}, "%s file %d [%d,+%d)", name, f, off, length)
+		}
+	}
+}
+
+func TestLayoutSpans(t *testing.T) {
+	info := &Info{
+		Name:        "t",
+		PieceLength: 4,
+		Files: []File{
+			{Length: 3, Path: []string{"a"}},
+			{Length: 0, Path: []string{"empty"}},
+			{Length: 6, Path: []string{"b"}},
+		},
+	}
+	l, err := NewLayout(info)
+	require.NoError(t, err)
+	assert.Equal(t, 3, l.NumPieces())
+	assert.Equal(t, 3, l.FileOffset(2))
+	assert.Equal(t, []Span{{File: 0, Offset: 0, Length: 3}, {File: 2, Offset: 0, Length: 1}}, l.PieceSpans(0))
+	assert.Equal(t, []Span{{File: 2, Offset: 5, Length: 1}}, l.PieceSpans(2))
+	assert.Equal(t, 1, l.PieceLen(2))
+	assert.Nil(t, l.PieceSpans(3))
+
+	spans, err := l.Spans(0, 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []Span{{File: 0, Offset: 2, Length: 1}, {File: 2, Offset: 0, Length: 1}}, spans)
+
+	_, err = l.Spans(3, 0, 1)
+	assert.ErrorIs(t, err, ErrPieceOutOfRange)
+	_, err = l.Spans(2, 0, 2)
+	assert.ErrorIs(t, err, ErrRange)
+	_, _, err = l.PieceRange(2, 4, 3)
+	assert.ErrorIs(t, err, ErrRange)
+	_, _, err = l.PieceRange(3, 0, 0)
+	assert.ErrorIs(t, err, ErrRange)
+}
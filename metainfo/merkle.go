@@ -0,0 +1,101 @@
+package metainfo
+
+import (
+	"crypto/sha256"
+	"math/bits"
+)
+
+// BlockSize is the size of the data hashed into each leaf of a v2 merkle
+// tree (BEP 52).
+const BlockSize = 16 << 10
+
+type Hash256 = [sha256.Size]byte
+
+// merkleRoot returns the root of the tree over hashes, padded with the
+// hashes of empty subtrees to width leaves. Each hash is the root of a
+// subtree of 2^level blocks; width must be a power of two no smaller than
+// len(hashes).
+func merkleRoot(hashes []Hash256, width, level int) Hash256 {
+	layer := make([]Hash256, width)
+	copy(layer, hashes)
+	pad := zeroHash(level)
+	for i := len(hashes); i < width; i++ {
+		layer[i] = pad
+	}
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			layer[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = layer[:len(layer)/2]
+	}
+	if len(layer) == 0 {
+		return pad
+	}
+	return layer[0]
+}
+
+func hashPair(a, b Hash256) Hash256 {
+	var buf [2 * sha256.Size]byte
+	copy(buf[:], a[:])
+	copy(buf[sha256.Size:], b[:])
+	return sha256.Sum256(buf[:])
+}
+
+// zeroHash is the root of a subtree of 2^level leaves that lie past the
+// end of a file; such leaves are all-zero hashes.
+func zeroHash(level int) (h Hash256) {
+	for range level {
+		h = hashPair(h, h)
+	}
+	return
+}
+
+func nextPow2(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+func log2(n int) int {
+	return bits.Len(uint(n)) - 1
+}
+
+// blockHashes hashes data in BlockSize chunks; the last one may be short.
+func blockHashes(data []byte) []Hash256 {
+	hashes := make([]Hash256, 0, (len(data)+BlockSize-1)/BlockSize)
+	for len(data) > 0 {
+		n := min(len(data), BlockSize)
+		hashes = append(hashes, sha256.Sum256(data[:n]))
+		data = data[n:]
+	}
+	return hashes
+}
+
+// pieceHashV2 returns the piece layer entry for one piece of a file: the
+// root of its blocks padded to a full piece.
+func pieceHashV2(data []byte, pieceLength int) Hash256 {
+	return merkleRoot(blockHashes(data), pieceLength/BlockSize, 0)
+}
+
+// RootFromPieceLayer computes the pieces root of a file larger than one
+// piece from its piece layer.
+func RootFromPieceLayer(layer []Hash256, pieceLength int) Hash256 {
+	return merkleRoot(layer, nextPow2(len(layer)), log2(pieceLength/BlockSize))
+}
+
+// PiecesRoot computes the merkle root of a whole file held in memory, as
+// stored in its file tree entry.
+func PiecesRoot(data []byte, pieceLength int) Hash256 {
+	if len(data) <= pieceLength {
+		leaves := blockHashes(data)
+		return merkleRoot(leaves, nextPow2(len(leaves)), 0)
+	}
+	var layer []Hash256
+	for len(data) > 0 {
+		n := min(len(data), pieceLength)
+		layer = append(layer, pieceHashV2(data[:n], pieceLength))
+		data = data[n:]
+	}
+	return RootFromPieceLayer(layer, pieceLength)
+}
@@ -0,0 +1,195 @@
+package metainfo
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+// MetaInfo is the outer dictionary of a .torrent file (BEP 3). The info
+// dictionary is kept as the exact bytes it was read from, since its hash
+// identifies the torrent; use UnmarshalInfo to decode it. Other known keys
+// whose decoding would not encode back to the same bytes keep those bytes
+// for as long as their field holds the value decoded.
+type MetaInfo struct {
+	Announce     string                        `bencode:"announce,omitempty"`
+	AnnounceList [][]string                    `bencode:"announce-list,omitempty"`
+	Comment      string                        `bencode:"comment,omitempty"`
+	CreatedBy    string                        `bencode:"created by,omitempty"`
+	CreationDate int                           `bencode:"creation date,omitempty"`
+	InfoBytes    bencode.RawMessage            `bencode:"info"`
+	PieceLayers  map[string]string             `bencode:"piece layers,omitempty"`
+	URLList      []string                      `bencode:"url-list,omitempty"`
+	Extra        map[string]bencode.RawMessage `bencode:",extra"`
+
+	kept map[string]keptValue
+}
+
+// keptValue is the encoding of a known key as read and as its field
+// encodes after decoding.
+type keptValue struct {
+	raw, decoded bencode.RawMessage
+}
+
+// Load reads a torrent from r and validates its info dictionary.
+func Load(r io.Reader) (*MetaInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var mi MetaInfo
+	err = bencode.Unmarshal(bytes.NewReader(data), &mi)
+	if err != nil {
+		return nil, err
+	}
+	if len(mi.InfoBytes) == 0 {
+		return nil, ErrNoInfo
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, err
+	}
+	err = info.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = mi.keepEncoding(data)
+	if err != nil {
+		return nil, err
+	}
+	return &mi, nil
+}
+
+// keepEncoding notes the known keys that do not encode back to the bytes
+// of data they were read from. It first takes a url-list given as a single string,
+// which BEP 19 allows, as a list of one.
+func (mi *MetaInfo) keepEncoding(data []byte) error {
+	if raw, ok := mi.Extra["url-list"]; ok {
+		var url string
+		if bencode.Unmarshal(bytes.NewReader(raw), &url) == nil {
+			delete(mi.Extra, "url-list")
+			if url != "" {
+				mi.URLList = []string{url}
+			}
+		}
+	}
+	var read map[string]bencode.RawMessage
+	err := bencode.Unmarshal(bytes.NewReader(data), &read)
+	if err != nil {
+		return err
+	}
+	decoded, err := mi.dict()
+	if err != nil {
+		return err
+	}
+	for key, raw := range read {
+		decoded := decoded[key]
+		if _, extra := mi.Extra[key]; extra || bytes.Equal(raw, decoded) {
+			continue
+		}
+		if mi.kept == nil {
+			mi.kept = make(map[string]keptValue)
+		}
+		mi.kept[key] = keptValue{raw: raw, decoded: decoded}
+	}
+	return nil
+}
+
+func LoadFile(name string) (*MetaInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+func (mi *MetaInfo) UnmarshalInfo() (*Info, error) {
+	if len(mi.InfoBytes) == 0 {
+		return nil, ErrNoInfo
+	}
+	var info Info
+	err := bencode.Unmarshal(bytes.NewReader(mi.InfoBytes), &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// SetInfo replaces the info dictionary with the encoding of info.
+func (mi *MetaInfo) SetInfo(info *Info) error {
+	b, err := bencode.AppendBencode(nil, info)
+	if err != nil {
+		return err
+	}
+	mi.InfoBytes = b
+	return nil
+}
+
+// HashInfoBytes returns the SHA-1 hash of the info dictionary exactly as
+// it appears in the torrent.
+func (mi *MetaInfo) HashInfoBytes() InfoHash {
+	return HashBytes(mi.InfoBytes)
+}
+
+func (mi *MetaInfo) Write(w io.Writer) error {
+	b, err := mi.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Bytes returns the encoded torrent, with the bytes read of the keys whose
+// field still holds the value decoded.
+func (mi *MetaInfo) Bytes() ([]byte, error) {
+	if len(mi.kept) == 0 {
+		return bencode.AppendBencode(nil, mi)
+	}
+	dict, err := mi.dict()
+	if err != nil {
+		return nil, err
+	}
+	for key, v := range mi.kept {
+		if bytes.Equal(dict[key], v.decoded) {
+			dict[key] = v.raw
+		}
+	}
+	return bencode.AppendBencode(nil, dict)
+}
+
+// dict returns the encoding of each key of mi.
+func (mi *MetaInfo) dict() (map[string]bencode.RawMessage, error) {
+	b, err := bencode.AppendBencode(nil, mi)
+	if err != nil {
+		return nil, err
+	}
+	var dict map[string]bencode.RawMessage
+	err = bencode.Unmarshal(bytes.NewReader(b), &dict)
+	return dict, err
+}
+
+// SetAnnounceList sets the trackers: the first one becomes announce and
+// announce-list is only written when there is more than one.
+func (mi *MetaInfo) SetAnnounceList(tiers [][]string) {
+	mi.Announce = ""
+	mi.AnnounceList = nil
+	var n int
+	for _, tier := range tiers {
+		for _, url := range tier {
+			if mi.Announce == "" {
+				mi.Announce = url
+			}
+			n++
+		}
+	}
+	if n > 1 {
+		mi.AnnounceList = slices.DeleteFunc(slices.Clone(tiers), func(tier []string) bool {
+			return len(tier) == 0
+		})
+	}
+}
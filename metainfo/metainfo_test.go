@@ -0,0 +1,161 @@
+package metainfo
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("SingleFile", func(t *testing.T) {
+		mi, err := LoadFile("testdata/single-file.torrent")
+		require.NoError(t, err)
+		assert.Equal(t, "http://tracker.example.org:6969/announce", mi.Announce)
+		assert.Equal(t, "mktorrent 1.1", mi.CreatedBy)
+		assert.Equal(t, 1700000000, mi.CreationDate)
+		assert.Equal(t, "922f95069521e60d5b3c7518755700bb28eacd22", mi.HashInfoBytes().HexString())
+
+		info, err := mi.UnmarshalInfo()
+		require.NoError(t, err)
+		assert.False(t, info.IsDir())
+		assert.Equal(t, "debian-12.2.0-amd64-netinst.iso", info.Name)
+		assert.Equal(t, 3*1048576+1234, info.TotalLength())
+		assert.Equal(t, 13, info.NumPieces())
+		assert.Equal(t, 262144, info.PieceLen(0))
+		assert.Equal(t, 3*1048576+1234-12*262144, info.PieceLen(12))
+		assert.Equal(t, 0, info.PieceLen(13))
+		assert.Equal(t, []File{{Length: info.Length}}, info.UpvertedFiles())
+	})
+
+	t.Run("MultiFile", func(t *testing.T) {
+		mi, err := LoadFile("testdata/multi-file.torrent")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"udp://tracker.example.org:1337/announce"},
+			{"http://backup.example.net/announce", "http://mirror.example.net/announce"},
+		}, mi.AnnounceList)
+		assert.Equal(t, "dataset snapshot", mi.Comment)
+		assert.Equal(t, "68cc940bdce47b589f7996b169f856c65a29c29d", mi.HashInfoBytes().HexString())
+
+		info, err := mi.UnmarshalInfo()
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+		assert.True(t, info.IsPrivate())
+		assert.Equal(t, "EXAMPLE", info.Source)
+		require.Len(t, info.Files, 3)
+		assert.Equal(t, "data/part-0001.bin", info.Files[1].DisplayPath())
+		assert.Equal(t, 400005, info.TotalLength())
+
+		h, err := info.PieceHash(6)
+		require.NoError(t, err)
+		assert.Equal(t, info.Pieces[120:140], string(h[:]))
+		_, err = info.PieceHash(7)
+		assert.Equal(t, ErrPieceOutOfRange, err)
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, name := range []string{"testdata/single-file.torrent", "testdata/multi-file.torrent"} {
+			data, err := os.ReadFile(name)
+			require.NoError(t, err)
+			mi, err := Load(bytes.NewReader(data))
+			require.NoError(t, err)
+			buf := new(bytes.Buffer)
+			require.NoError(t, mi.Write(buf))
+			assert.Equal(t, string(data), buf.String())
+		}
+	})
+
+	t.Run("ExactInfoBytes", func(t *testing.T) {
+		// keys out of order: re-encoding would change the hash
+		in := "d4:infod6:pieces20:" + strings.Repeat("x", 20) + "4:name1:a12:piece lengthi16e6:lengthi16eee"
+		mi, err := Load(strings.NewReader(in))
+		require.NoError(t, err)
+		assert.Equal(t, HashBytes([]byte(in[7:len(in)-1])), mi.HashInfoBytes())
+	})
+
+	t.Run("MissingInfo", func(t *testing.T) {
+		_, err := Load(strings.NewReader("d8:announce1:xe"))
+		assert.Equal(t, ErrNoInfo, err)
+	})
+
+	t.Run("SetInfo", func(t *testing.T) {
+		mi := &MetaInfo{}
+		info := &Info{Name: "a", Length: 1, PieceLength: 16, Pieces: strings.Repeat("x", 20)}
+		require.NoError(t, mi.SetInfo(info))
+		assert.Equal(t, "d6:lengthi1e4:name1:a12:piece lengthi16e6:pieces20:"+strings.Repeat("x", 20)+"e", string(mi.InfoBytes))
+		got, err := mi.UnmarshalInfo()
+		require.NoError(t, err)
+		assert.Equal(t, info, got)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	hashes := func(n int) string { return strings.Repeat("h", 20*n) }
+	testCases := []struct {
+		name    string
+		info    Info
+		wantErr error
+	}{
+		{"single file", Info{Name: "a", Length: 33, PieceLength: 16, Pieces: hashes(3)}, nil},
+		{"multi file", Info{Name: "d", PieceLength: 16, Pieces: hashes(2), Files: []File{
+			{Length: 10, Path: []string{"x"}}, {Length: 10, Path: []string{"y", "z"}},
+		}}, nil},
+		{"empty name", Info{Length: 1, PieceLength: 16, Pieces: hashes(1)}, ErrName},
+		{"dot dot name", Info{Name: "..", Length: 1, PieceLength: 16, Pieces: hashes(1)}, ErrName},
+		{"zero piece length", Info{Name: "a", Length: 1, Pieces: hashes(1)}, ErrPieceLength},
+		{"short pieces", Info{Name: "a", Length: 1, PieceLength: 16, Pieces: "abc"}, ErrPiecesLength},
+		{"too few pieces", Info{Name: "a", Length: 33, PieceLength: 16, Pieces: hashes(2)}, ErrPieceCount},
+		{"too many pieces", Info{Name: "a", Length: 32, PieceLength: 16, Pieces: hashes(3)}, ErrPieceCount},
+		{"bad private", Info{Name: "a", Length: 1, PieceLength: 16, Pieces: hashes(1), Private: 2}, ErrPrivate},
+		{"length and files", Info{Name: "a", Length: 1, PieceLength: 16, Pieces: hashes(1), Files: []File{
+			{Length: 1, Path: []string{"x"}},
+		}}, ErrLengthAndFiles},
+		{"empty files", Info{Name: "a", PieceLength: 16, Files: []File{}}, ErrNoFiles},
+		{"negative length", Info{Name: "a", Length: -1, PieceLength: 16}, ErrFileLength},
+		{"empty path", Info{Name: "a", PieceLength: 16, Pieces: hashes(1), Files: []File{{Length: 1}}}, ErrPath},
+		{"traversal", Info{Name: "a", PieceLength: 16, Pieces: hashes(1), Files: []File{
+			{Length: 1, Path: []string{"..", "etc", "passwd"}},
+		}}, ErrPath},
+		{"separator in component", Info{Name: "a", PieceLength: 16, Pieces: hashes(1), Files: []File{
+			{Length: 1, Path: []string{"x/../../y"}},
+		}}, ErrPath},
+		{"backslash in component", Info{Name: "a", PieceLength: 16, Pieces: hashes(1), Files: []File{
+			{Length: 1, Path: []string{"..\\y"}},
+		}}, ErrPath},
+		{"empty component", Info{Name: "a", PieceLength: 16, Pieces: hashes(1), Files: []File{
+			{Length: 1, Path: []string{"x", ""}},
+		}}, ErrPath},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.info.Validate()
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestPeerSources(t *testing.T) {
+	all := PeerSources{Trackers: true, DHT: true, PEX: true, LSD: true}
+	assert.Equal(t, all, (&Info{}).PeerSources(all))
+	assert.Equal(t, PeerSources{Trackers: true}, (&Info{Private: 1}).PeerSources(all))
+	assert.Equal(t, PeerSources{DHT: true}, (&Info{}).PeerSources(PeerSources{DHT: true}))
+}
+
+func TestParseInfoHash(t *testing.T) {
+	h, err := ParseInfoHash("68cc940bdce47b589f7996b169f856c65a29c29d")
+	require.NoError(t, err)
+	assert.Equal(t, "68cc940bdce47b589f7996b169f856c65a29c29d", h.String())
+	_, err = ParseInfoHash("68cc")
+	assert.Error(t, err)
+	_, err = ParseInfoHash(strings.Repeat("zz", 20))
+	assert.Error(t, err)
+}
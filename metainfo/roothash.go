@@ -0,0 +1,123 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"fmt"
+)
+
+// HashTree is the BEP 30 tree of SHA-1 hashes over the pieces of a merkle
+// torrent, which stores only its root as the root hash. Leaves past the
+// last piece hold zero hashes.
+type HashTree struct {
+	levels    [][][sha1.Size]byte // leaves first, root last
+	numPieces int
+}
+
+// NewHashTree builds the tree over the SHA-1 hashes of the pieces.
+func NewHashTree(pieces [][sha1.Size]byte) *HashTree {
+	leaves := make([][sha1.Size]byte, nextPow2(len(pieces)))
+	copy(leaves, pieces)
+	t := &HashTree{levels: [][][sha1.Size]byte{leaves}, numPieces: len(pieces)}
+	for level := leaves; len(level) > 1; {
+		next := make([][sha1.Size]byte, len(level)/2)
+		for i := range next {
+			next[i] = hashPairSHA1(level[2*i], level[2*i+1])
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t
+}
+
+// rootHash returns the root of the tree over the concatenated piece hashes.
+func rootHash(pieces []byte) []byte {
+	hashes := make([][sha1.Size]byte, len(pieces)/sha1.Size)
+	for i := range hashes {
+		copy(hashes[i][:], pieces[i*sha1.Size:])
+	}
+	root := NewHashTree(hashes).Root()
+	return root[:]
+}
+
+func hashPairSHA1(a, b [sha1.Size]byte) [sha1.Size]byte {
+	var buf [2 * sha1.Size]byte
+	copy(buf[:], a[:])
+	copy(buf[sha1.Size:], b[:])
+	return sha1.Sum(buf[:])
+}
+
+func (t *HashTree) Root() [sha1.Size]byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+func (t *HashTree) NumPieces() int {
+	return t.numPieces
+}
+
+// Chain returns the hash chain a peer sends along with piece i: the
+// sibling of each node on the path from its leaf to the root, bottom up.
+func (t *HashTree) Chain(i int) ([][sha1.Size]byte, error) {
+	if i < 0 || i >= t.numPieces {
+		return nil, ErrPieceOutOfRange
+	}
+	chain := make([][sha1.Size]byte, 0, len(t.levels)-1)
+	for _, level := range t.levels[:len(t.levels)-1] {
+		chain = append(chain, level[i^1])
+		i /= 2
+	}
+	return chain, nil
+}
+
+// VerifyChain reports whether data is piece i of a merkle torrent with
+// numPieces pieces and the given root, using the sibling hashes of chain.
+func VerifyChain(root [sha1.Size]byte, numPieces, i int, data []byte, chain [][sha1.Size]byte) bool {
+	if i < 0 || i >= numPieces || len(chain) != log2(nextPow2(numPieces)) {
+		return false
+	}
+	h := sha1.Sum(data)
+	for _, sibling := range chain {
+		if i%2 == 0 {
+			h = hashPairSHA1(h, sibling)
+		} else {
+			h = hashPairSHA1(sibling, h)
+		}
+		i /= 2
+	}
+	return h == root
+}
+
+// IsMerkle reports whether info is a BEP 30 merkle torrent, with a root
+// hash in place of the pieces.
+func (info *Info) IsMerkle() bool {
+	return info.RootHash != ""
+}
+
+// CheckMerklePiece checks data received for piece i against the root hash
+// using the hash chain that came with it.
+func (info *Info) CheckMerklePiece(i int, data []byte, chain [][sha1.Size]byte) error {
+	if i < 0 || i >= info.NumPieces() {
+		return ErrPieceOutOfRange
+	}
+	if len(data) != info.PieceLen(i) {
+		return fmt.Errorf("%w: piece %d has %d bytes", ErrHashChain, i, len(data))
+	}
+	var root [sha1.Size]byte
+	copy(root[:], info.RootHash)
+	if !VerifyChain(root, info.NumPieces(), i, data, chain) {
+		return ErrHashChain
+	}
+	return nil
+}
+
+func (info *Info) validateMerkle() error {
+	if len(info.RootHash) != sha1.Size {
+		return fmt.Errorf("%w: must be %d bytes", ErrRootHash, sha1.Size)
+	}
+	if info.Pieces != "" {
+		return fmt.Errorf("%w: torrent also has pieces", ErrRootHash)
+	}
+	if info.HasV2() {
+		return fmt.Errorf("%w: v2 torrents have their own trees", ErrRootHash)
+	}
+	return nil
+}
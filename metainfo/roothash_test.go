@@ -0,0 +1,115 @@
+package metainfo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashTree(t *testing.T) {
+	t.Run("ThreePieces", func(t *testing.T) {
+		a, b, c := sha1.Sum([]byte("a")), sha1.Sum([]byte("b")), sha1.Sum([]byte("c"))
+		tree := NewHashTree([][sha1.Size]byte{a, b, c})
+		want := hashPairSHA1(hashPairSHA1(a, b), hashPairSHA1(c, [sha1.Size]byte{}))
+		assert.Equal(t, want, tree.Root())
+
+		chain, err := tree.Chain(2)
+		require.NoError(t, err)
+		assert.Equal(t, [][sha1.Size]byte{{}, hashPairSHA1(a, b)}, chain)
+		_, err = tree.Chain(3)
+		assert.ErrorIs(t, err, ErrPieceOutOfRange)
+	})
+
+	for n := 1; n <= 17; n++ {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			var data [][]byte
+			var hashes [][sha1.Size]byte
+			for i := range n {
+				data = append(data, []byte(fmt.Sprintf("piece %d", i)))
+				hashes = append(hashes, sha1.Sum(data[i]))
+			}
+			tree := NewHashTree(hashes)
+			for i := range n {
+				chain, err := tree.Chain(i)
+				require.NoError(t, err)
+				assert.True(t, VerifyChain(tree.Root(), n, i, data[i], chain))
+				assert.False(t, VerifyChain(tree.Root(), n, i, []byte("bad"), chain))
+				if n > 1 {
+					assert.False(t, VerifyChain(tree.Root(), n, (i+1)%n, data[i], chain))
+					assert.False(t, VerifyChain(tree.Root(), n, i, data[i], chain[1:]))
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMerkle(t *testing.T) {
+	content := strings.Repeat("0123456789", 7000)
+	root := writeTree(t, map[string]string{"a": content[:30000], "b": content[30000:]})
+	mi, err := (&Builder{PieceLength: 16 << 10, Merkle: true}).Build(context.Background(), root)
+	require.NoError(t, err)
+	data, err := mi.Bytes()
+	require.NoError(t, err)
+	mi, err = Load(bytes.NewReader(data))
+	require.NoError(t, err)
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+
+	assert.True(t, info.IsMerkle())
+	assert.Empty(t, info.Pieces)
+	assert.Equal(t, 5, info.NumPieces())
+	assert.Equal(t, 70000-4*16<<10, info.PieceLen(4))
+	_, err = info.PieceHash(0)
+	assert.ErrorIs(t, err, ErrNoPieceHashes)
+
+	var hashes [][sha1.Size]byte
+	var pieces [][]byte
+	for i := range info.NumPieces() {
+		piece := []byte(content[i*16<<10 : i*16<<10+info.PieceLen(i)])
+		pieces = append(pieces, piece)
+		hashes = append(hashes, sha1.Sum(piece))
+	}
+	tree := NewHashTree(hashes)
+	treeRoot := tree.Root()
+	assert.Equal(t, info.RootHash, string(treeRoot[:]))
+	for i, piece := range pieces {
+		chain, err := tree.Chain(i)
+		require.NoError(t, err)
+		assert.NoError(t, info.CheckMerklePiece(i, piece, chain))
+		assert.ErrorIs(t, info.CheckMerklePiece(i, piece[1:], chain), ErrHashChain)
+	}
+
+	res, err := (&Verifier{}).Verify(context.Background(), mi, filepath.Dir(root))
+	require.NoError(t, err)
+	assert.True(t, res.Complete())
+	require.NoError(t, os.WriteFile(filepath.Join(root, "b"), []byte(strings.Repeat("x", 40000)), 0o644))
+	res, err = (&Verifier{}).Verify(context.Background(), mi, filepath.Dir(root))
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.NumGood())
+
+	_, err = (&Builder{Merkle: true, Version: Hybrid}).Build(context.Background(), root)
+	assert.ErrorIs(t, err, ErrRootHash)
+}
+
+func TestValidateMerkle(t *testing.T) {
+	info := &Info{Name: "a", Length: 10, PieceLength: 16 << 10, RootHash: strings.Repeat("r", 20)}
+	require.NoError(t, info.Validate())
+
+	bad := *info
+	bad.RootHash = "short"
+	assert.ErrorIs(t, bad.Validate(), ErrRootHash)
+	bad = *info
+	bad.Pieces = strings.Repeat("p", 20)
+	assert.ErrorIs(t, bad.Validate(), ErrRootHash)
+	bad = *info
+	bad.MetaVersion = 2
+	assert.ErrorIs(t, bad.Validate(), ErrRootHash)
+}
@@ -0,0 +1,332 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+// InfoHashV2 is the SHA-256 hash of the info dictionary of a v2 torrent.
+type InfoHashV2 [sha256.Size]byte
+
+func (h InfoHashV2) HexString() string {
+	return hex.EncodeToString(h[:])
+}
+
+func (h InfoHashV2) String() string {
+	return h.HexString()
+}
+
+// Truncated returns the first 20 bytes of the hash, which is what v2
+// torrents use in places sized for SHA-1 such as tracker announces.
+func (h InfoHashV2) Truncated() (t InfoHash) {
+	copy(t[:], h[:sha1.Size])
+	return
+}
+
+// HashInfoBytesV2 returns the SHA-256 hash of the info dictionary exactly
+// as it appears in the torrent.
+func (mi *MetaInfo) HashInfoBytesV2() InfoHashV2 {
+	return sha256.Sum256(mi.InfoBytes)
+}
+
+// TreeFile is a file of a v2 file tree. PiecesRoot is zero for empty files
+// and symlinks.
+type TreeFile struct {
+	Path        []string
+	Length      int
+	PiecesRoot  Hash256
+	Attr        string
+	SymlinkPath []string
+}
+
+func (f *TreeFile) DisplayPath() string {
+	return (&File{Path: f.Path}).DisplayPath()
+}
+
+func (info *Info) HasV1() bool {
+	return info.MetaVersion != 2 || len(info.Pieces) > 0
+}
+
+func (info *Info) HasV2() bool {
+	return info.MetaVersion == 2
+}
+
+// TreeFiles decodes the file tree into its files in tree order, which is
+// sorted by path.
+func (info *Info) TreeFiles() ([]TreeFile, error) {
+	if len(info.FileTree) == 0 {
+		return nil, fmt.Errorf("%w: empty file tree", ErrFileTree)
+	}
+	o, err := bencode.Parse(bytes.NewReader(info.FileTree))
+	if err != nil {
+		return nil, err
+	}
+	var files []TreeFile
+	err = walkFileTree(o, nil, &files)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no files", ErrFileTree)
+	}
+	return files, nil
+}
+
+func walkFileTree(o *bencode.BObject, path []string, files *[]TreeFile) error {
+	dict, err := o.Dict()
+	if err != nil {
+		return fmt.Errorf("%w: %q is not a dictionary", ErrFileTree, path)
+	}
+	if leaf, ok := dict[""]; ok {
+		if len(dict) != 1 || len(path) == 0 {
+			return fmt.Errorf("%w: %q is both a file and a directory", ErrFileTree, path)
+		}
+		f, err := decodeTreeFile(leaf)
+		if err != nil {
+			return fmt.Errorf("%w: %q: %v", ErrFileTree, path, err)
+		}
+		f.Path = slices.Clone(path)
+		*files = append(*files, f)
+		return nil
+	}
+	keys := make([]string, 0, len(dict))
+	for k := range dict {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if !validComponent(k) {
+			return fmt.Errorf("%w %q", ErrPath, append(path, k))
+		}
+		err = walkFileTree(dict[k], append(path, k), files)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeTreeFile(o *bencode.BObject) (f TreeFile, err error) {
+	dict, err := o.Dict()
+	if err != nil {
+		return f, err
+	}
+	lo, ok := dict["length"]
+	if !ok {
+		return f, fmt.Errorf("missing length")
+	}
+	f.Length, err = lo.Int()
+	if err != nil || f.Length < 0 {
+		return f, ErrFileLength
+	}
+	if ao, ok := dict["attr"]; ok {
+		f.Attr, err = ao.Str()
+		if err != nil {
+			return f, ErrAttr
+		}
+	}
+	if so, ok := dict["symlink path"]; ok {
+		f.SymlinkPath, err = decodePath(so)
+		if err != nil {
+			return f, err
+		}
+	}
+	ro, ok := dict["pieces root"]
+	if !ok {
+		if f.Length > 0 {
+			return f, fmt.Errorf("missing pieces root")
+		}
+		return f, nil
+	}
+	root, err := ro.Str()
+	if err != nil || len(root) != sha256.Size {
+		return f, fmt.Errorf("pieces root must be %d bytes", sha256.Size)
+	}
+	copy(f.PiecesRoot[:], root)
+	return f, nil
+}
+
+func decodePath(o *bencode.BObject) ([]string, error) {
+	list, err := o.List()
+	if err != nil {
+		return nil, ErrPath
+	}
+	path := make([]string, len(list))
+	for i, c := range list {
+		path[i], err = c.Str()
+		if err != nil {
+			return nil, ErrPath
+		}
+	}
+	err = validPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// SetFileTree encodes files as the file tree of info.
+func (info *Info) SetFileTree(files []TreeFile) error {
+	root := make(map[string]*bencode.BObject)
+	for _, f := range files {
+		if len(f.Path) == 0 {
+			return fmt.Errorf("%w: empty", ErrPath)
+		}
+		dir := root
+		for _, c := range f.Path[:len(f.Path)-1] {
+			next, ok := dir[c]
+			if !ok {
+				next = bencode.NewDict(nil)
+				dir[c] = next
+			}
+			d, err := next.Dict()
+			if err != nil {
+				return err
+			}
+			dir = d
+		}
+		leaf := map[string]*bencode.BObject{"length": bencode.NewInt(f.Length)}
+		if f.Length > 0 {
+			leaf["pieces root"] = bencode.NewString(string(f.PiecesRoot[:]))
+		}
+		if f.Attr != "" {
+			leaf["attr"] = bencode.NewString(f.Attr)
+		}
+		if f.SymlinkPath != nil {
+			path := make([]*bencode.BObject, len(f.SymlinkPath))
+			for i, c := range f.SymlinkPath {
+				path[i] = bencode.NewString(c)
+			}
+			leaf["symlink path"] = bencode.NewList(path)
+		}
+		dir[f.Path[len(f.Path)-1]] = bencode.NewDict(map[string]*bencode.BObject{
+			"": bencode.NewDict(leaf),
+		})
+	}
+	b, err := bencode.AppendBencode(nil, bencode.NewDict(root))
+	if err != nil {
+		return err
+	}
+	info.FileTree = b
+	return nil
+}
+
+func validateV2(info *Info) error {
+	if info.PieceLength < BlockSize || info.PieceLength&(info.PieceLength-1) != 0 {
+		return ErrPieceLength
+	}
+	_, err := info.TreeFiles()
+	return err
+}
+
+// PieceLayer splits the piece layer stored for a file into its hashes.
+func PieceLayer(layer string) ([]Hash256, error) {
+	if len(layer)%sha256.Size != 0 {
+		return nil, ErrPieceLayer
+	}
+	hashes := make([]Hash256, len(layer)/sha256.Size)
+	for i := range hashes {
+		copy(hashes[i][:], layer[i*sha256.Size:])
+	}
+	return hashes, nil
+}
+
+// VerifyPieceLayers checks that every file larger than one piece has a
+// piece layer of the right size that hashes up to its pieces root.
+func (mi *MetaInfo) VerifyPieceLayers() error {
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	files, err := info.TreeFiles()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Length <= info.PieceLength {
+			continue
+		}
+		layer, ok := mi.PieceLayers[string(f.PiecesRoot[:])]
+		if !ok {
+			return fmt.Errorf("%w: missing for %s", ErrPieceLayer, f.DisplayPath())
+		}
+		hashes, err := PieceLayer(layer)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, f.DisplayPath())
+		}
+		if len(hashes) != (f.Length+info.PieceLength-1)/info.PieceLength {
+			return fmt.Errorf("%w: wrong size for %s", ErrPieceLayer, f.DisplayPath())
+		}
+		if RootFromPieceLayer(hashes, info.PieceLength) != f.PiecesRoot {
+			return fmt.Errorf("%w: does not match pieces root of %s", ErrPieceLayer, f.DisplayPath())
+		}
+	}
+	return nil
+}
+
+// InfoHashes holds both identities of a torrent. A hash is zero when the
+// torrent lacks that format.
+type InfoHashes struct {
+	V1 InfoHash
+	V2 InfoHashV2
+}
+
+func (mi *MetaInfo) InfoHashes() (hs InfoHashes, err error) {
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return hs, err
+	}
+	if info.HasV1() {
+		hs.V1 = mi.HashInfoBytes()
+	}
+	if info.HasV2() {
+		hs.V2 = mi.HashInfoBytesV2()
+	}
+	return hs, nil
+}
+
+func (info *Info) IsHybrid() bool {
+	return info.HasV1() && info.HasV2()
+}
+
+// checkHybrid verifies that the v1 file list of a hybrid torrent names the
+// same files in the same order as its file tree, and that padding makes
+// every file but the last end on a piece boundary.
+func (info *Info) checkHybrid() error {
+	tree, err := info.TreeFiles()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if len(tree) != 1 || !slices.Equal(tree[0].Path, []string{info.Name}) || tree[0].Length != info.Length {
+			return fmt.Errorf("%w: single file differs from file tree", ErrHybrid)
+		}
+		return nil
+	}
+	offset := 0
+	i := 0
+	for _, f := range info.Files {
+		if f.IsPadding() {
+			offset += f.Length
+			continue
+		}
+		if i == len(tree) || !slices.Equal(f.Path, tree[i].Path) || f.Length != tree[i].Length {
+			return fmt.Errorf("%w: file %s differs from file tree", ErrHybrid, f.DisplayPath())
+		}
+		if offset%info.PieceLength != 0 {
+			return fmt.Errorf("%w: file %s is not piece aligned", ErrHybrid, f.DisplayPath())
+		}
+		offset += f.Length
+		i++
+	}
+	if i != len(tree) {
+		return fmt.Errorf("%w: file tree has %d files, v1 list has %d", ErrHybrid, len(tree), i)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package metainfo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// naiveRoot builds the full BEP 52 tree over every block of data.
+func naiveRoot(data []byte) Hash256 {
+	var layer []Hash256
+	for i := 0; i < len(data); i += BlockSize {
+		layer = append(layer, sha256.Sum256(data[i:min(len(data), i+BlockSize)]))
+	}
+	for len(layer)&(len(layer)-1) != 0 {
+		layer = append(layer, Hash256{})
+	}
+	for len(layer) > 1 {
+		next := make([]Hash256, len(layer)/2)
+		for i := range next {
+			next[i] = sha256.Sum256(append(layer[2*i][:], layer[2*i+1][:]...))
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+func TestPiecesRoot(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{1, BlockSize - 1, BlockSize, BlockSize + 1, 3 * BlockSize, 64 << 10, 64<<10 + 1, 200 << 10, 1 << 20} {
+		data := make([]byte, size)
+		rng.Read(data)
+		for _, pieceLength := range []int{16 << 10, 32 << 10, 64 << 10} {
+			assert.Equal(t, naiveRoot(data), PiecesRoot(data, pieceLength), "size %d piece length %d", size, pieceLength)
+		}
+	}
+}
+
+func TestBuildV2(t *testing.T) {
+	big := strings.Repeat("0123456789abcdef", 5000) // 80000 bytes, 3 pieces of 32 KiB
+	root := writeTree(t, map[string]string{
+		"small.txt":   "hello",
+		"dir/big.bin": big,
+		"dir/empty":   "",
+		"dir/one.bin": strings.Repeat("x", 32<<10),
+	})
+	b := &Builder{PieceLength: 32 << 10, Version: V2, Workers: 4}
+	mi, err := b.Build(context.Background(), root)
+	require.NoError(t, err)
+
+	data, err := mi.Bytes()
+	require.NoError(t, err)
+	mi, err = Load(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, mi.VerifyPieceLayers())
+
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+	assert.True(t, info.HasV2())
+	assert.False(t, info.HasV1())
+	assert.Empty(t, info.Pieces)
+	assert.Nil(t, info.Files)
+
+	files, err := info.TreeFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 4)
+	assert.Equal(t, []string{"dir", "big.bin"}, files[0].Path)
+	assert.Equal(t, naiveRoot([]byte(big)), files[0].PiecesRoot)
+	assert.Equal(t, []string{"dir", "empty"}, files[1].Path)
+	assert.Equal(t, Hash256{}, files[1].PiecesRoot)
+	assert.Equal(t, naiveRoot([]byte(strings.Repeat("x", 32<<10))), files[2].PiecesRoot)
+	assert.Equal(t, "small.txt", files[3].DisplayPath())
+	assert.Equal(t, naiveRoot([]byte("hello")), files[3].PiecesRoot)
+
+	// only the file larger than a piece has a layer
+	require.Len(t, mi.PieceLayers, 1)
+	assert.Len(t, mi.PieceLayers[string(files[0].PiecesRoot[:])], 3*sha256.Size)
+
+	h := mi.HashInfoBytesV2()
+	assert.Equal(t, InfoHashV2(sha256.Sum256(mi.InfoBytes)), h)
+	assert.Equal(t, h[:20], mi.HashInfoBytesV2().Truncated().Bytes())
+
+	t.Run("TamperedLayer", func(t *testing.T) {
+		key := string(files[0].PiecesRoot[:])
+		layer := []byte(mi.PieceLayers[key])
+		layer[0] ^= 1
+		mi.PieceLayers[key] = string(layer)
+		assert.ErrorIs(t, mi.VerifyPieceLayers(), ErrPieceLayer)
+
+		mi.PieceLayers[key] = string(layer[:32])
+		assert.ErrorIs(t, mi.VerifyPieceLayers(), ErrPieceLayer)
+
+		delete(mi.PieceLayers, key)
+		assert.ErrorIs(t, mi.VerifyPieceLayers(), ErrPieceLayer)
+	})
+}
+
+func TestBuildV2SingleFile(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.bin": "abc"})
+	mi, err := (&Builder{Version: V2}).Build(context.Background(), root+"/a.bin")
+	require.NoError(t, err)
+	info, err := mi.UnmarshalInfo()
+	require.NoError(t, err)
+	files, err := info.TreeFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []TreeFile{{Path: []string{"a.bin"}, Length: 3, PiecesRoot: naiveRoot([]byte("abc"))}}, files)
+	assert.Empty(t, mi.PieceLayers)
+}
+
+func TestFileTreeErrors(t *testing.T) {
+	root := strings.Repeat("r", 32)
+	testCases := []struct {
+		name string
+		tree string
+	}{
+		{"empty", "de"},
+		{"not a dict", "i1e"},
+		{"file and dir", "d1:ad0:d6:lengthi0ee1:bd0:d6:lengthi0eeee"},
+		{"file at root", "d0:d6:lengthi0eee"},
+		{"missing length", "d1:ad0:dee"},
+		{"missing pieces root", "d1:ad0:d6:lengthi1eeee"},
+		{"short pieces root", "d1:ad0:d6:lengthi1e11:pieces root3:abceee"},
+		{"traversal", "d2:..d0:d6:lengthi1e11:pieces root32:" + root + "eee"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := &Info{Name: "x", MetaVersion: 2, PieceLength: BlockSize, FileTree: []byte(tc.tree)}
+			assert.Error(t, info.Validate())
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package metainfo
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Verifier checks data on disk against a torrent, as a client does on a
+// recheck. The zero value hashes with one goroutine per CPU.
+type Verifier struct {
+	Workers int // pieces read and hashed at once; 0 uses one per CPU
+
+	// Progress, when set, is called after each piece is checked. Calls are
+	// serialized.
+	Progress func(done, total int)
+}
+
+// VerifyResult tells which pieces and files of a torrent are complete.
+type VerifyResult struct {
+	Pieces []bool       // whether each piece of the layout matches its hash
+	Files  []FileStatus // the visible files, in torrent order
+}
+
+func (r *VerifyResult) NumGood() (n int) {
+	for _, ok := range r.Pieces {
+		if ok {
+			n++
+		}
+	}
+	return
+}
+
+// Complete reports whether every piece matches and no file is missing,
+// which catches absent empty files too.
+func (r *VerifyResult) Complete() bool {
+	for _, f := range r.Files {
+		if !f.Complete() {
+			return false
+		}
+	}
+	return r.NumGood() == len(r.Pieces)
+}
+
+type FileStatus struct {
+	File
+	Missing  bool
+	Verified int // bytes of the file within good pieces
+}
+
+func (f *FileStatus) Complete() bool {
+	return !f.Missing && f.Verified == f.Length
+}
+
+// Verify checks the data of mi stored under dir, where a multi-file
+// torrent lives in dir/Name and a single file is dir/Name. Torrents with a
+// file tree are checked against their v2 hashes, others against the v1
+// pieces. Missing and short files, and anything other than a regular file
+// where one is expected, only fail the pieces they hold, except in
+// merkle torrents, which match or fail as a whole.
+func (v *Verifier) Verify(ctx context.Context, mi *MetaInfo, dir string) (*VerifyResult, error) {
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewLayout(info)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(dir, info.Name)
+	single := !info.IsDir()
+	if !info.HasV1() {
+		// a v2 single file torrent is a tree holding just the name
+		files := l.Files()
+		single = len(files) == 1 && slices.Equal(files[0].Path, []string{info.Name})
+	}
+	paths := make([]string, len(l.Files()))
+	missing := make([]bool, len(l.Files()))
+	for i, f := range l.Files() {
+		paths[i] = root
+		if !single {
+			paths[i] = filepath.Join(root, filepath.Join(f.Path...))
+		}
+		if !f.IsPadding() {
+			missing[i] = !present(paths[i], f)
+		}
+	}
+
+	check := func(p int, data []byte) bool {
+		h, err := info.PieceHash(p)
+		return err == nil && sha1.Sum(data) == h
+	}
+	var hashes []byte
+	if info.IsMerkle() {
+		// without hash chains only the whole torrent can be checked
+		hashes = make([]byte, l.NumPieces()*sha1.Size)
+		check = func(p int, data []byte) bool {
+			h := sha1.Sum(data)
+			copy(hashes[p*sha1.Size:], h[:])
+			return true
+		}
+	}
+	if info.HasV2() {
+		check, err = v2Checker(mi, info, l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &VerifyResult{Pieces: make([]bool, l.NumPieces())}
+	jobs := make([]hashJob, l.NumPieces())
+	for p := range jobs {
+		jobs[p] = func(buf []byte) error {
+			spans := l.PieceSpans(p)
+			for _, s := range spans {
+				if missing[s.File] {
+					return nil
+				}
+			}
+			n, err := readSpans(l, paths, spans, buf)
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			res.Pieces[p] = check(p, buf[:n])
+			return nil
+		}
+	}
+	err = runJobs(ctx, jobs, v.Workers, l.PieceLength, v.Progress)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsMerkle() && string(rootHash(hashes)) != info.RootHash {
+		clear(res.Pieces)
+	}
+
+	for i, f := range l.Files() {
+		if f.IsPadding() {
+			continue
+		}
+		st := FileStatus{File: f, Missing: missing[i]}
+		if f.IsSymlink() {
+			st.Verified = f.Length
+		} else if f.Length > 0 {
+			begin, end, _ := l.PieceRange(i, 0, f.Length)
+			for p := begin; p < end; p++ {
+				if res.Pieces[p] {
+					st.Verified += pieceOverlap(l, p, i)
+				}
+			}
+		}
+		res.Files = append(res.Files, st)
+	}
+	return res, nil
+}
+
+// present reports whether the file f is found at name: a symlink as any
+// entry, data only as a regular file. A directory or device in its place
+// counts as missing.
+func present(name string, f File) bool {
+	if f.IsSymlink() {
+		_, err := os.Lstat(name)
+		return err == nil
+	}
+	st, err := os.Stat(name)
+	return err == nil && st.Mode().IsRegular()
+}
+
+// v2Checker returns a piece check against the merkle hashes of the file
+// tree. Every file starts on a piece boundary, so each piece holds data of
+// one file.
+func v2Checker(mi *MetaInfo, info *Info, l *Layout) (func(int, []byte) bool, error) {
+	tree, err := info.TreeFiles()
+	if err != nil {
+		return nil, err
+	}
+	layers := make(map[int][]Hash256)
+	roots := make(map[int]Hash256)
+	t := 0
+	for i, f := range l.Files() {
+		if f.IsPadding() {
+			continue
+		}
+		if t == len(tree) || !slices.Equal(tree[t].Path, treePath(info, f)) {
+			return nil, fmt.Errorf("%w: file %s missing from file tree", ErrHybrid, f.DisplayPath())
+		}
+		tf := tree[t]
+		t++
+		roots[i] = tf.PiecesRoot
+		if tf.Length <= info.PieceLength {
+			continue
+		}
+		layer, ok := mi.PieceLayers[string(tf.PiecesRoot[:])]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing for %s", ErrPieceLayer, tf.DisplayPath())
+		}
+		layers[i], err = PieceLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func(p int, data []byte) bool {
+		off := 0
+		for _, s := range l.PieceSpans(p) {
+			f := l.Files()[s.File]
+			if f.IsPadding() {
+				off += s.Length
+				continue
+			}
+			data = data[off : off+s.Length]
+			if f.Length <= info.PieceLength {
+				return PiecesRoot(data, info.PieceLength) == roots[s.File]
+			}
+			j := s.Offset / info.PieceLength
+			return j < len(layers[s.File]) && pieceHashV2(data, info.PieceLength) == layers[s.File][j]
+		}
+		return false
+	}, nil
+}
+
+// treePath is the path a file of the layout has in the file tree, where a
+// single file torrent's file is named after the torrent.
+func treePath(info *Info, f File) []string {
+	if !info.IsDir() && info.HasV1() {
+		return []string{info.Name}
+	}
+	return f.Path
+}
+
+// readSpans reads the data of spans into buf and returns its length.
+// Padding files read as zeros and symlinks hold no data.
+func readSpans(l *Layout, paths []string, spans []Span, buf []byte) (n int, err error) {
+	for _, s := range spans {
+		f := l.Files()[s.File]
+		if f.IsPadding() {
+			clear(buf[n : n+s.Length])
+		} else {
+			err = readFileAt(paths[s.File], buf[n:n+s.Length], s.Offset)
+			if err != nil {
+				return 0, err
+			}
+		}
+		n += s.Length
+	}
+	return n, nil
+}
+
+// pieceOverlap returns how many bytes of file i are in piece p.
+func pieceOverlap(l *Layout, p, i int) (n int) {
+	for _, s := range l.PieceSpans(p) {
+		if s.File == i {
+			n += s.Length
+		}
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package metainfo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	files := map[string]string{
+		"a.bin":     strings.Repeat("a", 40000),
+		"b.txt":     "bb",
+		"c/d.bin":   strings.Repeat("d", 50000),
+		"c/e.empty": "",
+	}
+	for _, v := range []Version{V1, V2, Hybrid} {
+		t.Run([]string{"V1", "V2", "Hybrid"}[v], func(t *testing.T) {
+			root := writeTree(t, files)
+			mi, err := (&Builder{PieceLength: 16 << 10, Version: v}).Build(context.Background(), root)
+			require.NoError(t, err)
+			dir := filepath.Dir(root)
+
+			var calls int
+			verifier := &Verifier{Workers: 2, Progress: func(done, total int) { calls++ }}
+			res, err := verifier.Verify(context.Background(), mi, dir)
+			require.NoError(t, err)
+			assert.True(t, res.Complete())
+			assert.Equal(t, len(res.Pieces), calls)
+			require.Len(t, res.Files, 4)
+			for _, f := range res.Files {
+				assert.True(t, f.Complete(), f.DisplayPath())
+			}
+
+			// corrupt the middle of d.bin, cut b.txt short and drop a.bin
+			d := filepath.Join(root, "c", "d.bin")
+			data := []byte(files["c/d.bin"])
+			data[20000] = 'x'
+			require.NoError(t, os.WriteFile(d, data, 0o644))
+			require.NoError(t, os.WriteFile(filepath.Join(root, "b.txt"), []byte("b"), 0o644))
+			require.NoError(t, os.Remove(filepath.Join(root, "a.bin")))
+
+			res, err = verifier.Verify(context.Background(), mi, dir)
+			require.NoError(t, err)
+			assert.False(t, res.Complete())
+			a, b, dbin, e := res.Files[0], res.Files[1], res.Files[2], res.Files[3]
+			assert.True(t, a.Missing)
+			assert.Equal(t, 0, a.Verified)
+			assert.False(t, b.Missing)
+			assert.False(t, b.Complete())
+			assert.Equal(t, "c/d.bin", dbin.DisplayPath())
+			assert.False(t, dbin.Complete())
+			if v == V1 {
+				// d.bin shares its first piece with the missing a.bin
+				assert.Equal(t, 50000-(3*16<<10-40002)-16<<10, dbin.Verified)
+			} else {
+				assert.Equal(t, 50000-16<<10, dbin.Verified)
+			}
+			assert.True(t, e.Complete())
+		})
+	}
+}
+
+func TestVerifySingleFile(t *testing.T) {
+	for _, v := range []Version{V1, V2, Hybrid} {
+		dir := t.TempDir()
+		name := filepath.Join(dir, "file.bin")
+		require.NoError(t, os.WriteFile(name, []byte(strings.Repeat("z", 70000)), 0o644))
+		mi, err := (&Builder{PieceLength: 16 << 10, Version: v}).Build(context.Background(), name)
+		require.NoError(t, err)
+
+		res, err := (&Verifier{}).Verify(context.Background(), mi, dir)
+		require.NoError(t, err)
+		assert.True(t, res.Complete())
+		require.Len(t, res.Files, 1)
+		assert.Equal(t, 70000, res.Files[0].Verified)
+
+		require.NoError(t, os.Truncate(name, 60000))
+		res, err = (&Verifier{}).Verify(context.Background(), mi, dir)
+		require.NoError(t, err)
+		assert.Equal(t, []bool{true, true, true, false, false}, res.Pieces)
+		assert.Equal(t, 3*16<<10, res.Files[0].Verified)
+	}
+}
+
+func TestVerifyNotRegular(t *testing.T) {
+	for _, v := range []Version{V1, V2, Hybrid} {
+		root := writeTree(t, map[string]string{
+			"a.bin": strings.Repeat("a", 40000),
+			"b.bin": strings.Repeat("b", 40000),
+		})
+		mi, err := (&Builder{PieceLength: 16 << 10, Version: v}).Build(context.Background(), root)
+		require.NoError(t, err)
+
+		// a directory where a.bin belongs fails its pieces only
+		a := filepath.Join(root, "a.bin")
+		require.NoError(t, os.Remove(a))
+		require.NoError(t, os.Mkdir(a, 0o755))
+		res, err := (&Verifier{}).Verify(context.Background(), mi, filepath.Dir(root))
+		require.NoError(t, err)
+		require.Len(t, res.Files, 2)
+		assert.True(t, res.Files[0].Missing)
+		assert.Equal(t, 0, res.Files[0].Verified)
+		assert.False(t, res.Files[1].Missing)
+		assert.NotZero(t, res.Files[1].Verified)
+		assert.False(t, res.Complete())
+	}
+}
+
+func TestVerifyCanceled(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": strings.Repeat("a", 100000)})
+	mi, err := (&Builder{PieceLength: 16 << 10}).Build(context.Background(), root)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = (&Verifier{}).Verify(ctx, mi, filepath.Dir(root))
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,373 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultInterval is used when a tracker does not send one.
+	defaultInterval = 30 * time.Minute
+	minRetry        = 15 * time.Second
+	maxRetry        = time.Hour
+)
+
+// Client announces to trackers of the URL schemes it is registered for.
+type Client interface {
+	Announce(ctx context.Context, announce string, req *AnnounceRequest) (*AnnounceResponse, error)
+}
+
+// Clock is the time source of an Announcer.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Announcer keeps one torrent announced to its trackers as BEP 12 asks:
+// tiers are tried in order, trackers within a tier in shuffled order, and
+// a tracker that answers moves to the head of its tier. Only the first
+// tracker to answer is announced to in each round. It is safe for
+// concurrent use once set up.
+type Announcer struct {
+	Trackers [][]string      // announce-list tiers, read on the first announce
+	Request  AnnounceRequest // template for every announce; Event and TrackerID are managed
+	Clients  map[string]Client
+	Clock    Clock      // nil uses the system clock
+	Rand     *rand.Rand // shuffles tiers; nil uses the global source
+
+	round sync.Mutex // held for a whole announce round
+	mu    sync.Mutex
+	ws    *WebSocketClient // made by client, closed by Close
+	tiers [][]*trackerState
+	// current is the tracker of the last successful announce, which a
+	// stopped event goes to.
+	current  *trackerState
+	event    Event // pending event; Started until the first success
+	started  bool
+	next     time.Time
+	minNext  time.Time
+	failures int
+}
+
+type trackerState struct {
+	url       string
+	trackerID string
+	failures  int
+	lastErr   error
+	retryAt   time.Time
+}
+
+// TrackerStatus describes one tracker of an Announcer.
+type TrackerStatus struct {
+	URL       string
+	Tier      int
+	TrackerID string
+	Failures  int   // consecutive failures
+	LastError error // nil after a success
+	RetryAt   time.Time
+}
+
+func (a *Announcer) clock() Clock {
+	if a.Clock == nil {
+		return realClock{}
+	}
+	return a.Clock
+}
+
+// setup builds the tiers on first use; a.mu must be held.
+func (a *Announcer) setup() {
+	if a.tiers != nil {
+		return
+	}
+	a.tiers = [][]*trackerState{}
+	for _, urls := range a.Trackers {
+		var tier []*trackerState
+		for _, u := range urls {
+			tier = append(tier, &trackerState{url: u})
+		}
+		if len(tier) == 0 {
+			continue
+		}
+		shuffle := rand.Shuffle
+		if a.Rand != nil {
+			shuffle = a.Rand.Shuffle
+		}
+		shuffle(len(tier), func(i, j int) { tier[i], tier[j] = tier[j], tier[i] })
+		a.tiers = append(a.tiers, tier)
+	}
+	if !a.started && a.event == None {
+		a.event = Started
+	}
+}
+
+// client returns the client for the scheme of announce, creating the
+// default HTTP, UDP and WebSocket clients when Clients has none.
+func (a *Announcer) client(announce string) (Client, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.Clients[u.Scheme]; ok {
+		return c, nil
+	}
+	if a.Clients == nil {
+		a.Clients = make(map[string]Client)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		c := &HTTPClient{}
+		a.Clients["http"], a.Clients["https"] = c, c
+		return c, nil
+	case "udp":
+		c := &UDPClient{}
+		a.Clients["udp"] = c
+		return c, nil
+	case "ws", "wss":
+		c := &WebSocketClient{}
+		a.Clients["ws"], a.Clients["wss"] = c, c
+		a.ws = c
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrScheme, u.Scheme)
+}
+
+// Close closes the connections of the WebSocket client the announcer made
+// for ws and wss trackers; clients given in Clients are the caller's to
+// close. The announcer stays usable and reconnects when needed.
+func (a *Announcer) Close() error {
+	a.mu.Lock()
+	ws := a.ws
+	a.mu.Unlock()
+	if ws == nil {
+		return nil
+	}
+	return ws.Close()
+}
+
+// SetStats updates the transfer counters sent from the next announce on.
+func (a *Announcer) SetStats(uploaded, downloaded, left int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Request.Uploaded = uploaded
+	a.Request.Downloaded = downloaded
+	a.Request.Left = left
+}
+
+// Complete queues the completed event and makes the next announce due as
+// soon as the tracker's min interval allows.
+func (a *Announcer) Complete() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.event = Completed
+	a.Request.Left = 0
+	a.next = a.minNext
+}
+
+// Next returns when the next announce is due; the zero time means now.
+func (a *Announcer) Next() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.next
+}
+
+func (a *Announcer) Due() bool {
+	return !a.clock().Now().Before(a.Next())
+}
+
+// Announce runs one announce round with the pending event and schedules
+// the next one: after the tracker's interval on success, or with growing
+// backoff when every tracker failed.
+func (a *Announcer) Announce(ctx context.Context) (*AnnounceResponse, error) {
+	a.round.Lock()
+	defer a.round.Unlock()
+	a.mu.Lock()
+	a.setup()
+	req := a.Request
+	req.Event = a.event
+	var candidates []*trackerState
+	now := a.clock().Now()
+	for _, tier := range a.tiers {
+		for _, t := range tier {
+			if !now.Before(t.retryAt) {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		// everyone is backing off: try them all rather than nobody
+		for _, tier := range a.tiers {
+			candidates = append(candidates, tier...)
+		}
+	}
+	a.mu.Unlock()
+	if len(candidates) == 0 {
+		return nil, ErrNoTrackers
+	}
+
+	var errs []error
+	for _, t := range candidates {
+		a.mu.Lock()
+		req.TrackerID = t.trackerID
+		a.mu.Unlock()
+		res, err := a.announceTo(ctx, t, &req)
+		if err == nil {
+			a.succeeded(t, res, req.Event)
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.url, err))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failures++
+	a.next = a.clock().Now().Add(backoff(a.failures))
+	return nil, errors.Join(errs...)
+}
+
+func (a *Announcer) announceTo(ctx context.Context, t *trackerState, req *AnnounceRequest) (*AnnounceResponse, error) {
+	c, err := a.client(t.url)
+	if err == nil {
+		var res *AnnounceResponse
+		res, err = c.Announce(ctx, t.url, req)
+		if err == nil {
+			return res, nil
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t.failures++
+	t.lastErr = err
+	t.retryAt = a.clock().Now().Add(backoff(t.failures))
+	return nil, err
+}
+
+func (a *Announcer) succeeded(t *trackerState, res *AnnounceResponse, event Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t.failures = 0
+	t.lastErr = nil
+	t.retryAt = time.Time{}
+	if res.TrackerID != "" {
+		t.trackerID = res.TrackerID
+	}
+	for _, tier := range a.tiers {
+		if i := slices.Index(tier, t); i > 0 {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = t
+		}
+	}
+	a.current = t
+	a.failures = 0
+	if event == Started {
+		a.started = true
+	}
+	if a.event == event {
+		a.event = None
+	}
+
+	now := a.clock().Now()
+	interval := res.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	a.next = now.Add(interval)
+	a.minNext = now.Add(res.MinInterval)
+}
+
+// Stop sends the stopped event to the tracker last announced to, if any,
+// and resets the announcer so a later announce starts again.
+func (a *Announcer) Stop(ctx context.Context) error {
+	a.round.Lock()
+	defer a.round.Unlock()
+	a.mu.Lock()
+	t := a.current
+	req := a.Request
+	req.Event = Stopped
+	a.current = nil
+	a.started = false
+	a.event = Started
+	a.next = time.Time{}
+	a.minNext = time.Time{}
+	if t != nil {
+		req.TrackerID = t.trackerID
+	}
+	a.mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	c, err := a.client(t.url)
+	if err != nil {
+		return err
+	}
+	_, err = c.Announce(ctx, t.url, &req)
+	return err
+}
+
+// Run announces whenever one is due until ctx is done, handing every
+// outcome to report, which may be nil. It does not send the stopped event;
+// call Stop for that.
+func (a *Announcer) Run(ctx context.Context, report func(*AnnounceResponse, error)) error {
+	for {
+		if wait := a.Next().Sub(a.clock().Now()); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-a.clock().After(wait):
+			}
+			continue
+		}
+		res, err := a.Announce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if report != nil {
+			report(res, err)
+		}
+	}
+}
+
+// Status returns the state of every tracker in announce order.
+func (a *Announcer) Status() []TrackerStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setup()
+	var status []TrackerStatus
+	for i, tier := range a.tiers {
+		for _, t := range tier {
+			status = append(status, TrackerStatus{
+				URL:       t.url,
+				Tier:      i,
+				TrackerID: t.trackerID,
+				Failures:  t.failures,
+				LastError: t.lastErr,
+				RetryAt:   t.retryAt,
+			})
+		}
+	}
+	return status
+}
+
+// backoff is the wait before retrying after n consecutive failures.
+func backoff(n int) time.Duration {
+	d := minRetry
+	for i := 1; i < n && d < maxRetry; i++ {
+		d *= 2
+	}
+	return min(d, maxRetry)
+}
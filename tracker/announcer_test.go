@@ -0,0 +1,298 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = waiters
+}
+
+// fakeClient answers announces from a script per URL and logs them.
+type fakeClient struct {
+	mu    sync.Mutex
+	log   []fakeAnnounce
+	reply map[string]func(req *AnnounceRequest) (*AnnounceResponse, error)
+}
+
+type fakeAnnounce struct {
+	URL       string
+	Event     Event
+	TrackerID string
+}
+
+func (c *fakeClient) Announce(ctx context.Context, announce string, req *AnnounceRequest) (*AnnounceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = append(c.log, fakeAnnounce{announce, req.Event, req.TrackerID})
+	if reply, ok := c.reply[announce]; ok {
+		return reply(req)
+	}
+	return nil, errors.New("unreachable")
+}
+
+func (c *fakeClient) take() []fakeAnnounce {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	log := c.log
+	c.log = nil
+	return log
+}
+
+func ok(interval, minInterval time.Duration, trackerID string) func(*AnnounceRequest) (*AnnounceResponse, error) {
+	return func(*AnnounceRequest) (*AnnounceResponse, error) {
+		return &AnnounceResponse{Interval: interval, MinInterval: minInterval, TrackerID: trackerID}, nil
+	}
+}
+
+func newTestAnnouncer(tiers [][]string) (*Announcer, *fakeClient, *fakeClock) {
+	client := &fakeClient{reply: make(map[string]func(*AnnounceRequest) (*AnnounceResponse, error))}
+	clock := newFakeClock()
+	a := &Announcer{
+		Trackers: tiers,
+		Clients:  map[string]Client{"fake": client},
+		Clock:    clock,
+		Rand:     rand.New(rand.NewPCG(1, 1)),
+	}
+	return a, client, clock
+}
+
+func urls(log []fakeAnnounce) []string {
+	var out []string
+	for _, l := range log {
+		out = append(out, l.URL)
+	}
+	return out
+}
+
+func TestAnnouncerTiers(t *testing.T) {
+	a, client, clock := newTestAnnouncer([][]string{
+		{"fake://a1", "fake://a2", "fake://a3"},
+		{"fake://b1", "fake://b2"},
+	})
+	client.reply["fake://a3"] = ok(10*time.Minute, time.Minute, "id-a3")
+	client.reply["fake://b2"] = ok(20*time.Minute, 0, "")
+	ctx := context.Background()
+
+	require.True(t, a.Due())
+	first := a.Status()
+	// tiers keep their order, trackers within a tier are shuffled
+	assert.ElementsMatch(t, []string{"fake://a1", "fake://a2", "fake://a3"}, []string{first[0].URL, first[1].URL, first[2].URL})
+	assert.Equal(t, 1, first[3].Tier)
+
+	res, err := a.Announce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, res.Interval)
+	log := client.take()
+	assert.Equal(t, "fake://a3", log[len(log)-1].URL)
+	assert.Equal(t, Started, log[0].Event)
+	for _, l := range log {
+		assert.NotContains(t, l.URL, "fake://b")
+	}
+	// the answering tracker is promoted and its tracker id kept
+	status := a.Status()
+	assert.Equal(t, "fake://a3", status[0].URL)
+	assert.Equal(t, "id-a3", status[0].TrackerID)
+	assert.Equal(t, clock.Now().Add(10*time.Minute), a.Next())
+	assert.False(t, a.Due())
+
+	clock.Advance(10 * time.Minute)
+	require.True(t, a.Due())
+	_, err = a.Announce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []fakeAnnounce{{"fake://a3", None, "id-a3"}}, client.take())
+
+	// the first tier fails: the second answers, failed trackers back off
+	delete(client.reply, "fake://a3")
+	clock.Advance(10 * time.Minute)
+	_, err = a.Announce(ctx)
+	require.NoError(t, err)
+	tried := urls(client.take())
+	assert.Equal(t, []string{"fake://a3"}, tried[:1])
+	assert.ElementsMatch(t, []string{"fake://a1", "fake://a2"}, tried[1:3])
+	assert.Equal(t, "fake://b2", tried[len(tried)-1])
+	status = a.Status()
+	assert.Equal(t, "fake://b2", status[3].URL)
+	assert.Equal(t, 1, status[0].Failures)
+	assert.Error(t, status[0].LastError)
+	assert.Equal(t, clock.Now().Add(15*time.Second), status[0].RetryAt)
+
+	// trackers backing off are skipped
+	clock.Advance(10 * time.Second)
+	_, err = a.Announce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fake://b2"}, urls(client.take()))
+}
+
+func TestAnnouncerBackoff(t *testing.T) {
+	a, client, clock := newTestAnnouncer([][]string{{"fake://a"}, {"fake://b"}})
+	ctx := context.Background()
+	var waits []time.Duration
+	for range 10 {
+		_, err := a.Announce(ctx)
+		require.Error(t, err)
+		assert.Len(t, client.take(), 2)
+		wait := a.Next().Sub(clock.Now())
+		waits = append(waits, wait)
+		clock.Advance(wait)
+	}
+	assert.Equal(t, []time.Duration{
+		15 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute,
+		8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour,
+	}, waits)
+	assert.Equal(t, 10, a.Status()[0].Failures)
+
+	// recovery resets the backoff and the started event is still pending
+	client.reply["fake://b"] = ok(0, 0, "")
+	_, err := a.Announce(ctx)
+	require.NoError(t, err)
+	log := client.take()
+	assert.Equal(t, Started, log[len(log)-1].Event)
+	assert.Equal(t, clock.Now().Add(defaultInterval), a.Next())
+	assert.Equal(t, 0, a.Status()[1].Failures)
+}
+
+func TestAnnouncerEvents(t *testing.T) {
+	a, client, clock := newTestAnnouncer([][]string{{"fake://a"}})
+	client.reply["fake://a"] = ok(30*time.Minute, 5*time.Minute, "tid")
+	ctx := context.Background()
+
+	a.SetStats(1, 2, 3)
+	_, err := a.Announce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []fakeAnnounce{{"fake://a", Started, ""}}, client.take())
+
+	// completion is announced early, but not before min interval
+	clock.Advance(time.Minute)
+	a.Complete()
+	assert.False(t, a.Due())
+	assert.Equal(t, clock.Now().Add(4*time.Minute), a.Next())
+	clock.Advance(4 * time.Minute)
+	_, err = a.Announce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []fakeAnnounce{{"fake://a", Completed, "tid"}}, client.take())
+	assert.Equal(t, int64(0), a.Request.Left)
+
+	require.NoError(t, a.Stop(ctx))
+	assert.Equal(t, []fakeAnnounce{{"fake://a", Stopped, "tid"}}, client.take())
+	require.NoError(t, a.Stop(ctx))
+	assert.Empty(t, client.take())
+
+	_, err = a.Announce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Started, client.take()[0].Event)
+}
+
+func TestAnnouncerRun(t *testing.T) {
+	a, client, clock := newTestAnnouncer([][]string{{"fake://a"}})
+	client.reply["fake://a"] = ok(time.Minute, 0, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	reports := make(chan error)
+	done := make(chan error)
+	go func() {
+		done <- a.Run(ctx, func(res *AnnounceResponse, err error) { reports <- err })
+	}()
+
+	require.NoError(t, <-reports)
+	for range 3 {
+		// wait for Run to sleep on the clock before moving it
+		require.Eventually(t, func() bool {
+			clock.mu.Lock()
+			defer clock.mu.Unlock()
+			return len(clock.waiters) == 1
+		}, time.Second, time.Millisecond)
+		clock.Advance(time.Minute)
+		require.NoError(t, <-reports)
+	}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Len(t, client.take(), 4)
+}
+
+func TestAnnouncerClose(t *testing.T) {
+	hash := `"ªx\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000"`
+	s := newWSStandIn(t, func(m map[string]any) []string {
+		return []string{`{"action":"announce","info_hash":` + hash + `,"interval":120}`}
+	})
+	a := &Announcer{
+		Trackers: [][]string{{s.url()}},
+		Request:  AnnounceRequest{InfoHash: metainfo.InfoHash{0xaa, 'x'}, PeerID: PeerID([]byte("-GO0001-aaaaaaaaaaaa")), Left: 1},
+	}
+	conns := func() int {
+		a.ws.mu.Lock()
+		defer a.ws.mu.Unlock()
+		return len(a.ws.conns)
+	}
+	ctx := context.Background()
+	_, err := a.Announce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, conns())
+
+	// the WebSocket client the announcer made is closed with it, and
+	// reconnects when announcing again
+	require.NoError(t, a.Close())
+	assert.Equal(t, 0, conns())
+	_, err = a.Announce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, conns())
+	require.NoError(t, a.Close())
+}
+
+func TestAnnouncerNoTrackers(t *testing.T) {
+	a, _, _ := newTestAnnouncer([][]string{{}})
+	_, err := a.Announce(context.Background())
+	assert.ErrorIs(t, err, ErrNoTrackers)
+
+	a, _, _ = newTestAnnouncer([][]string{{"gopher://x"}})
+	_, err = a.Announce(context.Background())
+	assert.ErrorIs(t, err, ErrScheme)
+}
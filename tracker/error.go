@@ -0,0 +1,26 @@
+package tracker
+
+import "errors"
+
+var (
+	ErrScheme       = errors.New("unsupported tracker url scheme")
+	ErrHTTPStatus   = errors.New("unexpected tracker http status")
+	ErrCompactPeers = errors.New("compact peer list has a partial entry")
+	ErrPeers        = errors.New("invalid peer list")
+	ErrUDPResponse  = errors.New("unexpected udp tracker response")
+	ErrTimeout      = errors.New("tracker did not respond")
+	ErrScrape       = errors.New("invalid scrape request")
+	ErrNoScrape     = errors.New("tracker url does not support scrape")
+	ErrNoTrackers   = errors.New("no trackers to announce to")
+	ErrProxy        = errors.New("tracker proxy failed")
+)
+
+// FailureError is a failure reason sent by the tracker instead of a
+// response.
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker failure: " + e.Reason
+}
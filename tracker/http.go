@@ -0,0 +1,224 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+// maxResponseSize bounds how much of a tracker response is read.
+const maxResponseSize = 4 << 20
+
+// HTTPClient announces to HTTP and HTTPS trackers (BEP 3). Configure it
+// before use.
+type HTTPClient struct {
+	Client *http.Client // nil uses http.DefaultClient, or a client through Proxy
+	// Proxy, when set and Client is nil, is the http, https or socks5 URL
+	// of the proxy to reach trackers through. HTTP proxies are asked to
+	// CONNECT to HTTPS trackers.
+	Proxy     *url.URL
+	UserAgent string // sent when set
+	// Query and Header hold extra query parameters and headers to send to
+	// trackers, by host name without port.
+	Query  map[string]url.Values
+	Header map[string]http.Header
+
+	proxyOnce   sync.Once
+	proxyClient *http.Client
+}
+
+type httpResponse struct {
+	Complete       int                `bencode:"complete,omitempty"`
+	FailureReason  string             `bencode:"failure reason,omitempty"`
+	Incomplete     int                `bencode:"incomplete,omitempty"`
+	Interval       int                `bencode:"interval,omitempty"`
+	MinInterval    int                `bencode:"min interval,omitempty"`
+	Peers          bencode.RawMessage `bencode:"peers,omitempty"`
+	Peers6         string             `bencode:"peers6,omitempty"`
+	TrackerID      string             `bencode:"tracker id,omitempty"`
+	WarningMessage string             `bencode:"warning message,omitempty"`
+}
+
+type dictPeer struct {
+	IP     string `bencode:"ip"`
+	PeerID string `bencode:"peer id,omitempty"`
+	Port   int    `bencode:"port"`
+}
+
+func (c *HTTPClient) Announce(ctx context.Context, announce string, req *AnnounceRequest) (*AnnounceResponse, error) {
+	body, err := c.get(ctx, AnnounceURL(announce, req))
+	if err != nil {
+		return nil, err
+	}
+	var hr httpResponse
+	err = bencode.Unmarshal(bytes.NewReader(body), &hr)
+	if err != nil {
+		return nil, fmt.Errorf("decoding tracker response: %w", err)
+	}
+	if hr.FailureReason != "" {
+		return nil, &FailureError{Reason: hr.FailureReason}
+	}
+	res := &AnnounceResponse{
+		Interval:       time.Duration(hr.Interval) * time.Second,
+		MinInterval:    time.Duration(hr.MinInterval) * time.Second,
+		TrackerID:      hr.TrackerID,
+		Seeders:        hr.Complete,
+		Leechers:       hr.Incomplete,
+		WarningMessage: hr.WarningMessage,
+	}
+	res.Peers, err = parsePeers(hr.Peers)
+	if err != nil {
+		return nil, err
+	}
+	peers6, err := parseCompactPeers([]byte(hr.Peers6), 16)
+	if err != nil {
+		return nil, err
+	}
+	res.Peers = append(res.Peers, peers6...)
+	return res, nil
+}
+
+func (c *HTTPClient) get(ctx context.Context, url string) ([]byte, error) {
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	host := hreq.URL.Hostname()
+	if q := c.Query[host]; len(q) > 0 {
+		if hreq.URL.RawQuery != "" {
+			hreq.URL.RawQuery += "&"
+		}
+		hreq.URL.RawQuery += q.Encode()
+	}
+	for k, vs := range c.Header[host] {
+		for _, v := range vs {
+			hreq.Header.Add(k, v)
+		}
+	}
+	if c.UserAgent != "" {
+		hreq.Header.Set("User-Agent", c.UserAgent)
+	}
+	resp, err := c.client().Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrHTTPStatus, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+}
+
+func (c *HTTPClient) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	if c.Proxy == nil {
+		return http.DefaultClient
+	}
+	c.proxyOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(c.Proxy)
+		c.proxyClient = &http.Client{Transport: transport}
+	})
+	return c.proxyClient
+}
+
+// parsePeers decodes peers given either as a compact IPv4 string or as a
+// list of dictionaries. Peers named by host name are left out.
+func parsePeers(raw bencode.RawMessage) ([]Peer, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if raw[0] == 'l' {
+		var list []dictPeer
+		err := bencode.Unmarshal(bytes.NewReader(raw), &list)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPeers, err)
+		}
+		var peers []Peer
+		for _, p := range list {
+			addr, err := netip.ParseAddr(p.IP)
+			if err != nil || p.Port <= 0 || p.Port > 0xffff {
+				continue
+			}
+			peers = append(peers, Peer{Addr: netip.AddrPortFrom(addr.Unmap(), uint16(p.Port)), ID: p.PeerID})
+		}
+		return peers, nil
+	}
+	var compact string
+	err := bencode.Unmarshal(bytes.NewReader(raw), &compact)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPeers, err)
+	}
+	return parseCompactPeers([]byte(compact), 4)
+}
+
+// AnnounceURL returns the announce URL for req, keeping any query the
+// tracker URL already has, such as a passkey.
+func AnnounceURL(announce string, req *AnnounceRequest) string {
+	var b strings.Builder
+	b.WriteString(announce)
+	sep := byte('?')
+	if strings.Contains(announce, "?") {
+		sep = '&'
+	}
+	add := func(key, value string) {
+		b.WriteByte(sep)
+		sep = '&'
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(value)
+	}
+	add("info_hash", escapeBytes(req.InfoHash[:]))
+	add("peer_id", escapeBytes(req.PeerID[:]))
+	add("port", strconv.Itoa(req.Port))
+	add("uploaded", strconv.FormatInt(req.Uploaded, 10))
+	add("downloaded", strconv.FormatInt(req.Downloaded, 10))
+	add("left", strconv.FormatInt(req.Left, 10))
+	add("compact", "1")
+	if req.Event != None {
+		add("event", req.Event.String())
+	}
+	if req.NumWant != 0 {
+		add("numwant", strconv.Itoa(req.NumWant))
+	}
+	if req.Key != 0 {
+		add("key", fmt.Sprintf("%08x", req.Key))
+	}
+	if req.TrackerID != "" {
+		add("trackerid", escapeBytes([]byte(req.TrackerID)))
+	}
+	if req.IP.IsValid() {
+		add("ip", escapeBytes([]byte(req.IP.Unmap().String())))
+	}
+	return b.String()
+}
+
+// escapeBytes percent-encodes every byte but the unreserved characters of
+// RFC 3986, which is safe for binary hashes unlike form encoding.
+func escapeBytes(b []byte) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for _, c := range b {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(hex[c>>4])
+		sb.WriteByte(hex[c&15])
+	}
+	return sb.String()
+}
@@ -0,0 +1,260 @@
+package tracker
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRequest() *AnnounceRequest {
+	req := &AnnounceRequest{
+		Port:       6881,
+		Uploaded:   1 << 40,
+		Downloaded: 512,
+		Left:       1024,
+		Event:      Started,
+		NumWant:    30,
+		Key:        0xbeef,
+		TrackerID:  "t 1",
+	}
+	for i := range req.InfoHash {
+		req.InfoHash[i] = byte(i * 13)
+	}
+	copy(req.PeerID[:], "-GB0001-\x00\xff abc~._-xy")
+	return req
+}
+
+func TestAnnounceURL(t *testing.T) {
+	req := testRequest()
+	assert.Equal(t, "http://t.example/announce?info_hash=%00%0D%1A%274AN%5Bhu%82%8F%9C%A9%B6%C3%D0%DD%EA%F7"+
+		"&peer_id=-GB0001-%00%FF%20abc~._-xy&port=6881&uploaded=1099511627776&downloaded=512&left=1024"+
+		"&compact=1&event=started&numwant=30&key=0000beef&trackerid=t%201",
+		AnnounceURL("http://t.example/announce", req))
+
+	req = &AnnounceRequest{}
+	u := AnnounceURL("http://t.example/a?passkey=abc", req)
+	assert.Contains(t, u, "/a?passkey=abc&info_hash=")
+	assert.NotContains(t, u, "event=")
+	assert.NotContains(t, u, "key=0")
+	assert.NotContains(t, u, "ip=")
+
+	req.IP = netip.MustParseAddr("2001:db8::1")
+	assert.Contains(t, AnnounceURL("http://t.example/a", req), "&ip=2001%3Adb8%3A%3A1")
+}
+
+func TestHTTPAnnounce(t *testing.T) {
+	var handler func(w http.ResponseWriter, r *http.Request)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+	}))
+	defer ts.Close()
+	client := &HTTPClient{}
+	ctx := context.Background()
+
+	t.Run("Compact", func(t *testing.T) {
+		req := testRequest()
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			assert.Equal(t, string(req.InfoHash[:]), q.Get("info_hash"))
+			assert.Equal(t, string(req.PeerID[:]), q.Get("peer_id"))
+			assert.Equal(t, "started", q.Get("event"))
+			peers := wire.AppendCompactPeer(nil, netip.MustParseAddrPort("10.0.0.1:6881"))
+			peers = wire.AppendCompactPeer(peers, netip.MustParseAddrPort("192.168.1.2:51413"))
+			peers6 := wire.AppendCompactPeer(nil, netip.MustParseAddrPort("[2001:db8::1]:6881"))
+			w.Write([]byte("d8:completei5e10:incompletei3e8:intervali1800e12:min intervali60e5:peers" +
+				"12:" + string(peers) + "6:peers618:" + string(peers6) + "10:tracker id3:abc15:warning message4:slowe"))
+		}
+		res, err := client.Announce(ctx, ts.URL+"/announce", req)
+		require.NoError(t, err)
+		assert.Equal(t, &AnnounceResponse{
+			Interval:       30 * time.Minute,
+			MinInterval:    time.Minute,
+			TrackerID:      "abc",
+			Seeders:        5,
+			Leechers:       3,
+			WarningMessage: "slow",
+			Peers: []Peer{
+				{Addr: netip.MustParseAddrPort("10.0.0.1:6881")},
+				{Addr: netip.MustParseAddrPort("192.168.1.2:51413")},
+				{Addr: netip.MustParseAddrPort("[2001:db8::1]:6881")},
+			},
+		}, res)
+	})
+
+	t.Run("DictPeers", func(t *testing.T) {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("d8:intervali900e5:peersl" +
+				"d2:ip8:10.0.0.17:peer id20:aaaaaaaaaaaaaaaaaaaa4:porti1ee" +
+				"d2:ip11:example.com4:porti2ee" +
+				"d2:ip3:::14:porti3ee" +
+				"ee"))
+		}
+		res, err := client.Announce(ctx, ts.URL, testRequest())
+		require.NoError(t, err)
+		assert.Equal(t, []Peer{
+			{Addr: netip.MustParseAddrPort("10.0.0.1:1"), ID: "aaaaaaaaaaaaaaaaaaaa"},
+			{Addr: netip.MustParseAddrPort("[::1]:3")},
+		}, res.Peers)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("d14:failure reason17:torrent not founde"))
+		}
+		_, err := client.Announce(ctx, ts.URL, testRequest())
+		var fe *FailureError
+		require.True(t, errors.As(err, &fe))
+		assert.Equal(t, "torrent not found", fe.Reason)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			status int
+			body   string
+			err    error
+		}{
+			{"Status", http.StatusNotFound, "", ErrHTTPStatus},
+			{"PartialPeer", http.StatusOK, "d8:intervali1e5:peers5:abcdee", ErrCompactPeers},
+			{"PartialPeer6", http.StatusOK, "d8:intervali1e6:peers64:abcde", ErrCompactPeers},
+			{"BadPeers", http.StatusOK, "d8:intervali1e5:peersi3ee", ErrPeers},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+				}
+				_, err := client.Announce(ctx, ts.URL, testRequest())
+				assert.ErrorIs(t, err, tt.err)
+			})
+		}
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("not bencode"))
+		}
+		_, err := client.Announce(ctx, ts.URL, testRequest())
+		assert.Error(t, err)
+	})
+}
+
+func TestHTTPClientConfig(t *testing.T) {
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte("d8:intervali60e5:peers0:e"))
+	}))
+	defer ts.Close()
+	client := &HTTPClient{
+		UserAgent: "corp-seeder/1.0",
+		Query: map[string]url.Values{
+			"127.0.0.1": {"auth": {"a&b"}, "site": {"x"}},
+			"t.example": {"other": {"1"}},
+		},
+		Header: map[string]http.Header{"127.0.0.1": {"X-Api-Key": {"k"}}},
+	}
+	req := testRequest()
+	req.IP = netip.MustParseAddr("192.0.2.7")
+	_, err := client.Announce(context.Background(), ts.URL+"/announce?passkey=p", req)
+	require.NoError(t, err)
+	q := got.URL.Query()
+	assert.Equal(t, "p", q.Get("passkey"))
+	assert.Equal(t, "a&b", q.Get("auth"))
+	assert.Equal(t, "x", q.Get("site"))
+	assert.False(t, q.Has("other"))
+	assert.Equal(t, "192.0.2.7", q.Get("ip"))
+	assert.Equal(t, "corp-seeder/1.0", got.UserAgent())
+	assert.Equal(t, "k", got.Header.Get("X-Api-Key"))
+
+	_, err = client.Scrape(context.Background(), ts.URL+"/announce", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/scrape", got.URL.Path)
+	assert.Equal(t, "a&b", got.URL.Query().Get("auth"))
+}
+
+// httpProxyStandIn is an HTTP proxy on localhost that forwards requests
+// and tunnels CONNECTs, noting the method and host of each.
+func httpProxyStandIn(t *testing.T) (*url.URL, func() []string) {
+	var mu sync.Mutex
+	var seen []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Method+" "+r.Host+" "+r.Header.Get("Proxy-Authorization"))
+		mu.Unlock()
+		if r.Method != http.MethodConnect {
+			r.RequestURI = ""
+			r.Header.Del("Proxy-Authorization")
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}))
+	t.Cleanup(ts.Close)
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	u.User = url.UserPassword("alice", "secret")
+	return u, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	proxy, seen := httpProxyStandIn(t)
+	client := &HTTPClient{Proxy: proxy}
+	ctx := context.Background()
+	auth := "Basic YWxpY2U6c2VjcmV0"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:completei4e8:intervali60e5:peers0:e"))
+	}))
+	defer ts.Close()
+	res, err := client.Announce(ctx, ts.URL+"/announce", testRequest())
+	require.NoError(t, err)
+	assert.Equal(t, 4, res.Seeders)
+	assert.Equal(t, []string{"GET " + ts.Listener.Addr().String() + " " + auth}, seen())
+
+	// HTTPS trackers are tunneled; the test certificate is not trusted
+	secure := httptest.NewUnstartedServer(ts.Config.Handler)
+	secure.Config.ErrorLog = log.New(io.Discard, "", 0)
+	secure.StartTLS()
+	defer secure.Close()
+	_, err = client.Announce(ctx, secure.URL+"/announce", testRequest())
+	var cerr *tls.CertificateVerificationError
+	assert.ErrorAs(t, err, &cerr)
+	assert.Equal(t, "CONNECT "+secure.Listener.Addr().String()+" "+auth, seen()[1])
+}
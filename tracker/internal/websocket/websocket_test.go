@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo serves WebSocket connections sending every message back.
+func echo(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if c.WriteMessage(msg) != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestEcho(t *testing.T) {
+	ts := httptest.NewServer(echo(t))
+	defer ts.Close()
+	c, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http")+"/announce", nil)
+	require.NoError(t, err)
+	defer c.Close()
+	for _, n := range []int{0, 1, 125, 126, 1000, 65535, 65536, 300000} {
+		msg := bytes.Repeat([]byte{'x'}, n)
+		require.NoError(t, c.WriteMessage(msg))
+		got, err := c.ReadMessage()
+		require.NoError(t, err, n)
+		assert.True(t, bytes.Equal(msg, got), n)
+	}
+	require.NoError(t, c.WriteMessage(make([]byte, MaxMessage+1)))
+	_, err = c.ReadMessage()
+	assert.Error(t, err)
+}
+
+func TestTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(echo(t))
+	defer ts.Close()
+	cfg := &tls.Config{RootCAs: ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	c, err := Dial(context.Background(), "wss"+strings.TrimPrefix(ts.URL, "https"), cfg)
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.WriteMessage([]byte("hi")))
+	got, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(got))
+}
+
+func TestHandshakeRefused(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	_, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	assert.ErrorIs(t, err, ErrHandshake)
+
+	ts = httptest.NewServer(echo(t))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	_, err = Dial(context.Background(), "http://example.com", nil)
+	assert.ErrorIs(t, err, ErrHandshake)
+}
+
+// rawClient does the handshake by hand so tests can send any frames.
+func rawClient(t *testing.T, ts *httptest.Server) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	// the example of RFC 6455 section 1.3
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return conn, r
+}
+
+func maskedFrame(b0 byte, payload string) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{b0, 0x80 | byte(len(payload))}, mask...)
+	for i := range len(payload) {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	return frame
+}
+
+func TestFragmentsAndControl(t *testing.T) {
+	ts := httptest.NewServer(echo(t))
+	defer ts.Close()
+	conn, r := rawClient(t, ts)
+
+	var frames []byte
+	frames = append(frames, maskedFrame(opText, "hel")...)
+	frames = append(frames, maskedFrame(0x80|opPing, "p")...)
+	frames = append(frames, maskedFrame(0x80|opContinuation, "lo")...)
+	_, err := conn.Write(frames)
+	require.NoError(t, err)
+
+	// the pong comes first, then the whole message, unmasked
+	buf := make([]byte, 3+7)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x80 | opPong, 1, 'p', 0x80 | opText, 5, 'h', 'e', 'l', 'l', 'o'}, buf)
+
+	// closing is echoed
+	_, err = conn.Write(maskedFrame(0x80|opClose, "\x03\xe8"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(r, buf[:4])
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x80 | opClose, 2, 0x03, 0xe8}, buf[:4])
+}
+
+func TestUnmaskedFromClient(t *testing.T) {
+	ts := httptest.NewServer(echo(t))
+	defer ts.Close()
+	conn, r := rawClient(t, ts)
+	_, err := conn.Write([]byte{0x80 | opText, 1, 'x'})
+	require.NoError(t, err)
+	// the server drops the connection after a close frame
+	rest, _ := io.ReadAll(r)
+	assert.Equal(t, []byte{0x80 | opClose, 2, 0x03, 0xe8}, rest)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := &Conn{conn: a, r: bufio.NewReader(a), WriteTimeout: 20 * time.Millisecond}
+	// nothing reads from b
+	err := c.WriteMessage([]byte("x"))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	// the connection is closed and stays so
+	assert.ErrorIs(t, c.WriteMessage([]byte("x")), net.ErrClosed)
+	_, err = b.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,118 @@
+// Package webtorrent holds the JSON messages of WebTorrent's WebSocket
+// tracker protocol.
+package webtorrent
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+)
+
+var ErrBinary = errors.New("binary string holds a code point above 255")
+
+// Binary is a byte string sent as the JSON string whose code points equal
+// its bytes, the way JavaScript trackers send info hashes and peer IDs.
+type Binary string
+
+func (b Binary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Latin1(string(b)))
+}
+
+func (b *Binary) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	out, err := FromLatin1(s)
+	*b = Binary(out)
+	return err
+}
+
+// Latin1 returns s with every byte turned into the code point of its
+// value.
+func Latin1(s string) string {
+	r := make([]rune, len(s))
+	for i := range len(s) {
+		r[i] = rune(s[i])
+	}
+	return string(r)
+}
+
+// FromLatin1 undoes Latin1.
+func FromLatin1(s string) (string, error) {
+	out := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r > 0xff {
+			return "", ErrBinary
+		}
+		out = append(out, byte(r))
+		s = s[size:]
+	}
+	return string(out), nil
+}
+
+// Hashes is the info_hash of a message: one Binary, or a list of them in
+// scrapes.
+type Hashes []Binary
+
+func (h Hashes) MarshalJSON() ([]byte, error) {
+	if len(h) == 1 {
+		return json.Marshal(h[0])
+	}
+	return json.Marshal([]Binary(h))
+}
+
+func (h *Hashes) UnmarshalJSON(data []byte) error {
+	var one Binary
+	if json.Unmarshal(data, &one) == nil {
+		*h = Hashes{one}
+		return nil
+	}
+	var list []Binary
+	err := json.Unmarshal(data, &list)
+	*h = list
+	return err
+}
+
+// Message is any message of the protocol; which fields are set depends on
+// the action and direction.
+type Message struct {
+	Action   string `json:"action,omitempty"`
+	InfoHash Hashes `json:"info_hash,omitempty"`
+	PeerID   Binary `json:"peer_id,omitempty"`
+
+	// announces
+	Event      string  `json:"event,omitempty"`
+	NumWant    *int    `json:"numwant,omitempty"`
+	Uploaded   *int64  `json:"uploaded,omitempty"`
+	Downloaded *int64  `json:"downloaded,omitempty"`
+	Left       *int64  `json:"left,omitempty"`
+	Offers     []Offer `json:"offers,omitempty"`
+
+	// relayed offers and answers
+	ToPeerID Binary          `json:"to_peer_id,omitempty"`
+	OfferID  Binary          `json:"offer_id,omitempty"`
+	Offer    json.RawMessage `json:"offer,omitempty"`
+	Answer   json.RawMessage `json:"answer,omitempty"`
+
+	// responses
+	Interval       int             `json:"interval,omitempty"`
+	Complete       *int            `json:"complete,omitempty"`
+	Incomplete     *int            `json:"incomplete,omitempty"`
+	Files          map[string]File `json:"files,omitempty"` // keys are Latin1 info hashes
+	FailureReason  string          `json:"failure reason,omitempty"`
+	WarningMessage string          `json:"warning message,omitempty"`
+}
+
+type Offer struct {
+	Offer   json.RawMessage `json:"offer"`
+	OfferID Binary          `json:"offer_id"`
+}
+
+type File struct {
+	Complete   int `json:"complete"`
+	Incomplete int `json:"incomplete"`
+	Downloaded int `json:"downloaded"`
+}
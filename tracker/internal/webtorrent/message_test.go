@@ -0,0 +1,36 @@
+package webtorrent
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinary(t *testing.T) {
+	b := Binary("\x00\x7f\x80\xff\"")
+	data, err := json.Marshal(b)
+	require.NoError(t, err)
+	assert.Equal(t, "\"\\u0000\x7f\u0080ÿ\\\"\"", string(data))
+	var got Binary
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, b, got)
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`"€"`), &got), ErrBinary)
+}
+
+func TestHashes(t *testing.T) {
+	var m Message
+	require.NoError(t, json.Unmarshal([]byte(`{"action":"scrape","info_hash":"ab"}`), &m))
+	assert.Equal(t, Hashes{"ab"}, m.InfoHash)
+	require.NoError(t, json.Unmarshal([]byte(`{"action":"scrape","info_hash":["ab","ÿ"]}`), &m))
+	assert.Equal(t, Hashes{"ab", "\xff"}, m.InfoHash)
+
+	data, err := json.Marshal(Message{Action: "scrape", InfoHash: Hashes{"a", "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"action":"scrape","info_hash":["a","b"]}`, string(data))
+	data, err = json.Marshal(Message{Action: "scrape", InfoHash: Hashes{"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"action":"scrape","info_hash":"a"}`, string(data))
+}
@@ -0,0 +1,78 @@
+// Package wire holds the binary encodings tracker clients and servers
+// share: the constants of the UDP tracker protocol (BEP 15), its URL data
+// options (BEP 41) and compact peer lists (BEP 23 and BEP 7).
+package wire
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	// ProtocolID opens every connect request.
+	ProtocolID = 0x41727101980
+
+	ActionConnect  = 0
+	ActionAnnounce = 1
+	ActionScrape   = 2
+	ActionError    = 3
+
+	// MaxPacket bounds the size of one UDP tracker packet.
+	MaxPacket = 2048
+	// MaxScrapeHashes is how many info hashes fit in one UDP scrape.
+	MaxScrapeHashes = 74
+)
+
+const (
+	optionEnd     = 0
+	optionNoOp    = 1
+	optionURLData = 2
+)
+
+// AppendURLData appends the path and query of an announce URL as URL data
+// options, followed by the end of options. Nothing is appended for an
+// empty path.
+func AppendURLData(b []byte, requestURI string) []byte {
+	if requestURI == "" || requestURI == "/" {
+		return b
+	}
+	for data := requestURI; len(data) > 0; {
+		n := min(len(data), 255)
+		b = append(b, optionURLData, byte(n))
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return append(b, optionEnd)
+}
+
+// URLData joins the URL data options following an announce, skipping
+// options of other kinds and stopping at a truncated one.
+func URLData(opts []byte) string {
+	var data []byte
+	for len(opts) > 0 {
+		switch opts[0] {
+		case optionEnd:
+			return string(data)
+		case optionNoOp:
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			break
+		}
+		n := 2 + int(opts[1])
+		if opts[0] == optionURLData {
+			data = append(data, opts[2:n]...)
+		}
+		opts = opts[n:]
+	}
+	return string(data)
+}
+
+// AppendCompactPeer appends the compact form of addr: its address, as
+// IPv4 when mapped, then its port, big-endian. The address must be of the
+// family the list holds.
+func AppendCompactPeer(b []byte, addr netip.AddrPort) []byte {
+	b = append(b, addr.Addr().Unmap().AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
@@ -0,0 +1,31 @@
+package wire
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLData(t *testing.T) {
+	long := "/" + strings.Repeat("a", 300) + "?k=v"
+	for _, uri := range []string{"/announce", "/k1/announce?x=1", long} {
+		assert.Equal(t, uri, URLData(AppendURLData(nil, uri)), uri)
+	}
+	assert.Empty(t, AppendURLData(nil, "/"))
+	assert.Empty(t, AppendURLData(nil, ""))
+
+	// no-ops and unknown options are skipped, and truncation ends the data
+	assert.Equal(t, "/ab", URLData([]byte{1, 2, 2, '/', 'a', 9, 1, 'x', 2, 1, 'b', 0, 2, 1, 'c'}))
+	assert.Equal(t, "/a", URLData([]byte{2, 2, '/', 'a', 2, 5, 'b'}))
+	assert.Empty(t, URLData(nil))
+}
+
+func TestAppendCompactPeer(t *testing.T) {
+	b := AppendCompactPeer(nil, netip.MustParseAddrPort("10.0.0.1:6881"))
+	b = AppendCompactPeer(b, netip.MustParseAddrPort("[::ffff:10.0.0.2]:258"))
+	assert.Equal(t, []byte{10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 1, 2}, b)
+	b = AppendCompactPeer(nil, netip.MustParseAddrPort("[2001:db8::1]:1"))
+	assert.Equal(t, []byte{0x20, 0x01, 0x0d, 0xb8, 11: 0, 15: 1, 16: 0, 17: 1}, b)
+}
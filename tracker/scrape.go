@@ -0,0 +1,218 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+// Scraper asks a tracker, named by its announce URL, for swarm stats.
+type Scraper interface {
+	Scrape(ctx context.Context, announce string, hashes []metainfo.InfoHash) (*ScrapeResponse, error)
+}
+
+// ScrapeURL derives the scrape URL of an HTTP tracker by the convention of
+// BEP 48: the last path element must start with "announce", which becomes
+// "scrape". UDP and WebSocket trackers scrape at their announce URL.
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "udp", "ws", "wss":
+		return announce, nil
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("%w %q", ErrScheme, u.Scheme)
+	}
+	i := strings.LastIndexByte(u.Path, '/')
+	if !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", ErrNoScrape
+	}
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+type httpScrapeResponse struct {
+	FailureReason string                    `bencode:"failure reason,omitempty"`
+	Files         map[string]httpScrapeFile `bencode:"files,omitempty"`
+	Flags         struct {
+		MinRequestInterval int `bencode:"min_request_interval,omitempty"`
+	} `bencode:"flags"`
+}
+
+type httpScrapeFile struct {
+	Complete   int    `bencode:"complete"`
+	Downloaded int    `bencode:"downloaded"`
+	Incomplete int    `bencode:"incomplete"`
+	Name       string `bencode:"name,omitempty"`
+}
+
+// Scrape fetches the stats of the given swarms in one request; with no
+// hashes a tracker may answer for every swarm it has.
+func (c *HTTPClient) Scrape(ctx context.Context, announce string, hashes []metainfo.InfoHash) (*ScrapeResponse, error) {
+	scrape, err := ScrapeURL(announce)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString(scrape)
+	sep := byte('?')
+	if strings.Contains(scrape, "?") {
+		sep = '&'
+	}
+	for _, h := range hashes {
+		b.WriteByte(sep)
+		sep = '&'
+		b.WriteString("info_hash=")
+		b.WriteString(escapeBytes(h[:]))
+	}
+	body, err := c.get(ctx, b.String())
+	if err != nil {
+		return nil, err
+	}
+	var hr httpScrapeResponse
+	err = bencode.Unmarshal(bytes.NewReader(body), &hr)
+	if err != nil {
+		return nil, fmt.Errorf("decoding scrape response: %w", err)
+	}
+	if hr.FailureReason != "" {
+		return nil, &FailureError{Reason: hr.FailureReason}
+	}
+	res := &ScrapeResponse{
+		Files:              make(map[metainfo.InfoHash]ScrapeStats, len(hr.Files)),
+		MinRequestInterval: time.Duration(hr.Flags.MinRequestInterval) * time.Second,
+	}
+	for k, f := range hr.Files {
+		if len(k) != len(metainfo.InfoHash{}) {
+			continue
+		}
+		res.Files[metainfo.InfoHash([]byte(k))] = ScrapeStats{
+			Seeders:    f.Complete,
+			Leechers:   f.Incomplete,
+			Downloaded: f.Downloaded,
+			Name:       f.Name,
+		}
+	}
+	return res, nil
+}
+
+// BatchScraper scrapes any number of swarms, splitting the info hashes
+// into batches and keeping to each tracker's min_request_interval between
+// requests. It is safe for concurrent use.
+type BatchScraper struct {
+	Clients   map[string]Scraper // by URL scheme; HTTP, UDP and WebSocket work by default
+	BatchSize int                // 0 means 74, the most a UDP scrape holds
+	Clock     Clock              // nil uses the system clock
+
+	mu   sync.Mutex
+	ws   *WebSocketClient     // made by client, closed by Close
+	next map[string]time.Time // earliest next request per tracker
+}
+
+func (b *BatchScraper) Scrape(ctx context.Context, announce string, hashes []metainfo.InfoHash) (*ScrapeResponse, error) {
+	s, err := b.client(announce)
+	if err != nil {
+		return nil, err
+	}
+	clock := b.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	size := b.BatchSize
+	if size <= 0 {
+		size = wire.MaxScrapeHashes
+	}
+	res := &ScrapeResponse{Files: make(map[metainfo.InfoHash]ScrapeStats, len(hashes))}
+	for start := 0; start < len(hashes); start += size {
+		err = b.wait(ctx, clock, announce)
+		if err != nil {
+			return nil, err
+		}
+		batch, err := s.Scrape(ctx, announce, hashes[start:min(start+size, len(hashes))])
+		if err != nil {
+			return nil, err
+		}
+		b.mu.Lock()
+		if b.next == nil {
+			b.next = make(map[string]time.Time)
+		}
+		b.next[announce] = clock.Now().Add(batch.MinRequestInterval)
+		b.mu.Unlock()
+		for h, st := range batch.Files {
+			res.Files[h] = st
+		}
+		res.MinRequestInterval = max(res.MinRequestInterval, batch.MinRequestInterval)
+	}
+	return res, nil
+}
+
+func (b *BatchScraper) wait(ctx context.Context, clock Clock, announce string) error {
+	b.mu.Lock()
+	next := b.next[announce]
+	b.mu.Unlock()
+	wait := next.Sub(clock.Now())
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.After(wait):
+		return nil
+	}
+}
+
+// Close closes the connections of the WebSocket client the scraper made
+// for ws and wss trackers; clients given in Clients are the caller's to
+// close.
+func (b *BatchScraper) Close() error {
+	b.mu.Lock()
+	ws := b.ws
+	b.mu.Unlock()
+	if ws == nil {
+		return nil
+	}
+	return ws.Close()
+}
+
+func (b *BatchScraper) client(announce string) (Scraper, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s, ok := b.Clients[u.Scheme]; ok {
+		return s, nil
+	}
+	if b.Clients == nil {
+		b.Clients = make(map[string]Scraper)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		s := &HTTPClient{}
+		b.Clients["http"], b.Clients["https"] = s, s
+		return s, nil
+	case "udp":
+		s := &UDPClient{}
+		b.Clients["udp"] = s
+		return s, nil
+	case "ws", "wss":
+		s := &WebSocketClient{}
+		b.Clients["ws"], b.Clients["wss"] = s, s
+		b.ws = s
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrScheme, u.Scheme)
+}
@@ -0,0 +1,139 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		announce, scrape string
+		err              error
+	}{
+		{"http://example.com/announce", "http://example.com/scrape", nil},
+		{"http://example.com/x/announce", "http://example.com/x/scrape", nil},
+		{"http://example.com/announce.php", "http://example.com/scrape.php", nil},
+		{"http://example.com/announce?x2%0644", "http://example.com/scrape?x2%0644", nil},
+		{"https://example.com/a/announce?passkey=abc", "https://example.com/a/scrape?passkey=abc", nil},
+		{"udp://tracker.example.com:80", "udp://tracker.example.com:80", nil},
+		{"http://example.com/a", "", ErrNoScrape},
+		{"http://example.com/announce/x", "", ErrNoScrape},
+		{"http://example.com/x%064announce", "", ErrNoScrape},
+		{"wss://example.com/announce", "wss://example.com/announce", nil},
+		{"ftp://example.com/announce", "", ErrScheme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.announce, func(t *testing.T) {
+			got, err := ScrapeURL(tt.announce)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.scrape, got)
+		})
+	}
+}
+
+func TestHTTPScrape(t *testing.T) {
+	a, b := metainfo.InfoHash{0xaa, ' '}, metainfo.InfoHash{0xbb, '&'}
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/scrape", r.URL.Path)
+		assert.Equal(t, []string{string(a[:]), string(b[:])}, r.URL.Query()["info_hash"])
+		assert.Equal(t, "k", r.URL.Query().Get("passkey"))
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	body = "d5:filesd20:" + string(a[:]) + "d8:completei5e10:downloadedi50e10:incompletei10e4:name5:alphae" +
+		"20:" + string(b[:]) + "d8:completei0e10:downloadedi1e10:incompletei2ee" +
+		"3:badd8:completei1e10:downloadedi1e10:incompletei1eee" +
+		"5:flagsd20:min_request_intervali900eee"
+	res, err := (&HTTPClient{}).Scrape(context.Background(), ts.URL+"/announce?passkey=k", []metainfo.InfoHash{a, b})
+	require.NoError(t, err)
+	assert.Equal(t, &ScrapeResponse{
+		Files: map[metainfo.InfoHash]ScrapeStats{
+			a: {Seeders: 5, Leechers: 10, Downloaded: 50, Name: "alpha"},
+			b: {Seeders: 0, Leechers: 2, Downloaded: 1},
+		},
+		MinRequestInterval: 15 * time.Minute,
+	}, res)
+
+	body = "d14:failure reason9:no scrapee"
+	_, err = (&HTTPClient{}).Scrape(context.Background(), ts.URL+"/announce?passkey=k", []metainfo.InfoHash{a, b})
+	var fe *FailureError
+	assert.ErrorAs(t, err, &fe)
+
+	_, err = (&HTTPClient{}).Scrape(context.Background(), ts.URL+"/tracker", nil)
+	assert.ErrorIs(t, err, ErrNoScrape)
+}
+
+func TestBatchScraper(t *testing.T) {
+	hashes := make([]metainfo.InfoHash, 100)
+	for i := range hashes {
+		hashes[i][0], hashes[i][1] = byte(i), 0x5c
+	}
+
+	t.Run("UDP", func(t *testing.T) {
+		s := newUDPStandIn(t, "udp4", "127.0.0.1:0")
+		s.setHandle(func(req []byte, n int) [][]byte {
+			resp := udpHeader(wire.ActionScrape, req)
+			for i := range (len(req) - 16) / 20 {
+				// seeders are the first byte of the hash
+				resp = binary.BigEndian.AppendUint32(resp, uint32(req[16+20*i]))
+				resp = binary.BigEndian.AppendUint32(resp, 0)
+				resp = binary.BigEndian.AppendUint32(resp, 0)
+			}
+			return [][]byte{resp}
+		})
+		res, err := (&BatchScraper{}).Scrape(context.Background(), s.url(""), hashes)
+		require.NoError(t, err)
+		require.Len(t, res.Files, 100)
+		for i, h := range hashes {
+			assert.Equal(t, i, res.Files[h].Seeders)
+		}
+		_, requests := s.seen()
+		require.Len(t, requests, 2)
+		assert.Len(t, requests[0], 16+74*20)
+		assert.Len(t, requests[1], 16+26*20)
+	})
+
+	t.Run("MinRequestInterval", func(t *testing.T) {
+		requests := make(chan int, 10)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- len(r.URL.Query()["info_hash"])
+			w.Write([]byte("d5:filesde5:flagsd20:min_request_intervali60eee"))
+		}))
+		defer ts.Close()
+		clock := newFakeClock()
+		bs := &BatchScraper{BatchSize: 40, Clock: clock}
+		done := make(chan error)
+		go func() {
+			_, err := bs.Scrape(context.Background(), ts.URL+"/announce", hashes)
+			done <- err
+		}()
+
+		assert.Equal(t, 40, <-requests)
+		for _, want := range []int{40, 20} {
+			require.Eventually(t, func() bool {
+				clock.mu.Lock()
+				defer clock.mu.Unlock()
+				return len(clock.waiters) == 1
+			}, time.Second, time.Millisecond)
+			select {
+			case <-requests:
+				t.Fatal("scraped before min_request_interval")
+			default:
+			}
+			clock.Advance(time.Minute)
+			assert.Equal(t, want, <-requests)
+		}
+		require.NoError(t, <-done)
+	})
+}
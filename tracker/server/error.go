@@ -0,0 +1,14 @@
+package server
+
+import "errors"
+
+var (
+	ErrNotAllowed = errors.New("torrent not allowed on this tracker")
+	ErrInfoHash   = errors.New("invalid info_hash")
+	ErrPeerID     = errors.New("invalid peer_id")
+	ErrPort       = errors.New("invalid port")
+	ErrParam      = errors.New("invalid parameter")
+	ErrTooMany    = errors.New("too many info hashes")
+	ErrPasskey    = errors.New("unregistered passkey")
+	ErrClient     = errors.New("client not allowed")
+)
@@ -0,0 +1,541 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+const (
+	snapshotName = "snapshot"
+	logName      = "log"
+	// defaultCompactAfter is how many log records trigger a snapshot.
+	defaultCompactAfter = 10000
+	// maxRecord bounds one log record, guarding against a corrupt length.
+	maxRecord = 1 << 20
+)
+
+// FileStore is a Store held in memory and made durable in a directory.
+// Every change is appended to a log as a checksummed bencoded record; once
+// the log grows long enough the whole state is written to a snapshot and
+// the log starts over. Opening replays the log over the snapshot, dropping
+// a record torn by a crash.
+//
+// Log records set state rather than change it, so replaying a log that a
+// crash left behind after its snapshot was written is harmless.
+//
+// By default the log is synced after every change, and an announce makes
+// two or three of them under the lock of the Server, which bounds a
+// tracker to a few hundred announces a second on most disks. SyncInterval
+// lets the changes of many announces share one sync.
+type FileStore struct {
+	// CompactAfter is how many log records trigger a snapshot; 0 means
+	// 10000.
+	CompactAfter int
+	// NoSync skips syncing the log after every change, trading the last
+	// changes before a machine crash for speed.
+	NoSync bool
+	// SyncInterval, when positive, syncs the log that long after the first
+	// change not yet synced instead of after every change, so a machine
+	// crash loses at most that much. A failed sync is returned by the next
+	// change. It has no effect with NoSync.
+	SyncInterval time.Duration
+
+	syncMu    sync.Mutex
+	syncTimer *time.Timer // pending sync of the log, nil when none
+	syncErr   error
+
+	dir     string
+	mem     MemoryStore
+	log     *os.File
+	size    int64 // of the log up to its last whole record
+	records int
+}
+
+type storedSnapshot struct {
+	Swarms map[string]storedSwarm `bencode:"swarms"`
+	Users  map[string]storedUser  `bencode:"users"`
+}
+
+type storedSwarm struct {
+	Downloaded int                   `bencode:"downloaded"`
+	Peers      map[string]storedPeer `bencode:"peers"`
+	Pruned     map[string]storedPeer `bencode:"pruned,omitempty"`
+}
+
+type storedPeer struct {
+	Addr       string `bencode:"addr"`
+	Completed  int    `bencode:"completed,omitempty"`
+	Downloaded int    `bencode:"downloaded"`
+	Expires    int    `bencode:"expires"` // Unix nanoseconds
+	Left       int    `bencode:"left"`
+	Passkey    string `bencode:"passkey,omitempty"`
+	Uploaded   int    `bencode:"uploaded"`
+}
+
+type storedUser struct {
+	Downloaded int `bencode:"downloaded"`
+	Uploaded   int `bencode:"uploaded"`
+}
+
+// storedRecord is one log entry; Value holds the storedPeer of "peer" and
+// "pruned", the count of "downloaded" and the storedUser of "user".
+type storedRecord struct {
+	InfoHash string             `bencode:"info hash,omitempty"`
+	Op       string             `bencode:"op"`
+	Passkey  string             `bencode:"passkey,omitempty"`
+	PeerID   string             `bencode:"peer id,omitempty"`
+	Value    bencode.RawMessage `bencode:"value,omitempty"`
+}
+
+// OpenFileStore opens the store in dir, creating the directory if needed.
+func OpenFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileStore{dir: dir}
+	err = f.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	f.log, err = os.OpenFile(filepath.Join(dir, logName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	err = f.replay()
+	if err != nil {
+		f.log.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close syncs the changes left unsynced by SyncInterval and closes the log.
+func (f *FileStore) Close() error {
+	f.syncMu.Lock()
+	pending := f.syncTimer != nil && f.syncTimer.Stop()
+	f.syncTimer = nil
+	err := f.syncErr
+	f.syncMu.Unlock()
+	if pending && err == nil {
+		err = f.log.Sync()
+	}
+	if err1 := f.log.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (f *FileStore) Swarm(h metainfo.InfoHash) (*Swarm, error) {
+	return f.mem.Swarm(h)
+}
+
+func (f *FileStore) InfoHashes() ([]metainfo.InfoHash, error) {
+	return f.mem.InfoHashes()
+}
+
+func (f *FileStore) PutPeer(h metainfo.InfoHash, id tracker.PeerID, p Peer) error {
+	v, err := bencode.AppendBencode(nil, encodePeer(p))
+	if err != nil {
+		return err
+	}
+	err = f.append(storedRecord{Op: "peer", InfoHash: string(h[:]), PeerID: string(id[:]), Value: v})
+	if err != nil {
+		return err
+	}
+	f.mem.PutPeer(h, id, p)
+	return f.compact()
+}
+
+func (f *FileStore) DeletePeer(h metainfo.InfoHash, id tracker.PeerID) error {
+	err := f.append(storedRecord{Op: "unpeer", InfoHash: string(h[:]), PeerID: string(id[:])})
+	if err != nil {
+		return err
+	}
+	f.mem.DeletePeer(h, id)
+	return f.compact()
+}
+
+func (f *FileStore) PrunePeer(h metainfo.InfoHash, id tracker.PeerID) error {
+	p, ok := f.mem.swarms[h].peer(id)
+	if !ok {
+		return nil
+	}
+	v, err := bencode.AppendBencode(nil, encodePeer(p))
+	if err != nil {
+		return err
+	}
+	err = f.append(storedRecord{Op: "pruned", InfoHash: string(h[:]), PeerID: string(id[:]), Value: v})
+	if err != nil {
+		return err
+	}
+	f.mem.PrunePeer(h, id)
+	return f.compact()
+}
+
+func (f *FileStore) AddDownload(h metainfo.InfoHash) error {
+	sw := f.mem.swarms[h]
+	if sw == nil {
+		return nil
+	}
+	v, err := bencode.AppendBencode(nil, sw.Downloaded+1)
+	if err != nil {
+		return err
+	}
+	err = f.append(storedRecord{Op: "downloaded", InfoHash: string(h[:]), Value: v})
+	if err != nil {
+		return err
+	}
+	f.mem.AddDownload(h)
+	return f.compact()
+}
+
+func (f *FileStore) User(passkey string) (User, bool, error) {
+	return f.mem.User(passkey)
+}
+
+func (f *FileStore) Users() ([]User, error) {
+	return f.mem.Users()
+}
+
+func (f *FileStore) PutUser(u User) error {
+	v, err := bencode.AppendBencode(nil, storedUser{Downloaded: int(u.Downloaded), Uploaded: int(u.Uploaded)})
+	if err != nil {
+		return err
+	}
+	err = f.append(storedRecord{Op: "user", Passkey: u.Passkey, Value: v})
+	if err != nil {
+		return err
+	}
+	f.mem.PutUser(u)
+	return f.compact()
+}
+
+func (f *FileStore) DeleteUser(passkey string) error {
+	err := f.append(storedRecord{Op: "unuser", Passkey: passkey})
+	if err != nil {
+		return err
+	}
+	f.mem.DeleteUser(passkey)
+	return f.compact()
+}
+
+// append writes r to the log framed by its length and CRC-32.
+func (f *FileStore) append(r storedRecord) error {
+	body, err := bencode.AppendBencode(nil, r)
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(body))
+	frame = append(frame, body...)
+	_, err = f.log.Write(frame)
+	if err != nil {
+		// cut a partial record so later ones stay readable
+		f.log.Truncate(f.size)
+		f.log.Seek(f.size, io.SeekStart)
+		return err
+	}
+	f.size += int64(len(frame))
+	f.records++
+	switch {
+	case f.NoSync:
+		return nil
+	case f.SyncInterval > 0:
+		return f.syncLater()
+	default:
+		return f.log.Sync()
+	}
+}
+
+// syncLater arranges for the log to be synced SyncInterval from now unless
+// a sync is pending already, and returns the error of the last one.
+func (f *FileStore) syncLater() error {
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+	err := f.syncErr
+	f.syncErr = nil
+	if f.syncTimer == nil {
+		f.syncTimer = time.AfterFunc(f.SyncInterval, func() {
+			err := f.log.Sync()
+			f.syncMu.Lock()
+			f.syncTimer = nil
+			if f.syncErr == nil {
+				f.syncErr = err
+			}
+			f.syncMu.Unlock()
+		})
+	}
+	return err
+}
+
+// compact snapshots once the log is long enough; call it after a change
+// has reached f.mem.
+func (f *FileStore) compact() error {
+	compactAfter := f.CompactAfter
+	if compactAfter <= 0 {
+		compactAfter = defaultCompactAfter
+	}
+	if f.records < compactAfter {
+		return nil
+	}
+	return f.Snapshot()
+}
+
+// Snapshot writes the whole state to the snapshot and empties the log.
+// The snapshot replaces the old one by rename, so a crash leaves either.
+func (f *FileStore) Snapshot() error {
+	snap := storedSnapshot{
+		Swarms: make(map[string]storedSwarm, len(f.mem.swarms)),
+		Users:  make(map[string]storedUser, len(f.mem.users)),
+	}
+	for h, sw := range f.mem.swarms {
+		ss := storedSwarm{Downloaded: sw.Downloaded, Peers: make(map[string]storedPeer, len(sw.Peers))}
+		for id, p := range sw.Peers {
+			ss.Peers[string(id[:])] = encodePeer(p)
+		}
+		for id, p := range sw.Pruned {
+			if ss.Pruned == nil {
+				ss.Pruned = make(map[string]storedPeer, len(sw.Pruned))
+			}
+			ss.Pruned[string(id[:])] = encodePeer(p)
+		}
+		snap.Swarms[string(h[:])] = ss
+	}
+	for k, u := range f.mem.users {
+		snap.Users[k] = storedUser{Downloaded: int(u.Downloaded), Uploaded: int(u.Uploaded)}
+	}
+	data, err := bencode.AppendBencode(nil, snap)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(f.dir, snapshotName)
+	tmp, err := os.CreateTemp(f.dir, snapshotName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	err = syncDir(f.dir)
+	if err != nil {
+		return err
+	}
+	err = f.log.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.log.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	f.size = 0
+	f.records = 0
+	return f.log.Sync()
+}
+
+func (f *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(f.dir, snapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap storedSnapshot
+	err = bencode.Unmarshal(bytes.NewReader(data), &snap)
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	for k, ss := range snap.Swarms {
+		h, err := infoHash(k)
+		if err != nil {
+			return fmt.Errorf("reading snapshot: %w", err)
+		}
+		for op, peers := range map[string]map[string]storedPeer{"peer": ss.Peers, "pruned": ss.Pruned} {
+			for id, sp := range peers {
+				r := storedRecord{Op: op, InfoHash: k, PeerID: id}
+				r.Value, err = bencode.AppendBencode(nil, sp)
+				if err == nil {
+					err = f.apply(r)
+				}
+				if err != nil {
+					return fmt.Errorf("reading snapshot: %w", err)
+				}
+			}
+		}
+		if ss.Downloaded > 0 {
+			f.mem.setDownloaded(h, ss.Downloaded)
+		}
+	}
+	for k, su := range snap.Users {
+		f.mem.PutUser(User{Passkey: k, Uploaded: int64(su.Uploaded), Downloaded: int64(su.Downloaded)})
+	}
+	return nil
+}
+
+// replay applies the log records up to the first torn or corrupt one and
+// cuts the log there, leaving it positioned for appending.
+func (f *FileStore) replay() error {
+	data, err := io.ReadAll(f.log)
+	if err != nil {
+		return err
+	}
+	off := 0
+	for len(data)-off >= 8 {
+		n := int(binary.BigEndian.Uint32(data[off:]))
+		sum := binary.BigEndian.Uint32(data[off+4:])
+		if n > maxRecord || len(data)-off-8 < n {
+			break
+		}
+		body := data[off+8 : off+8+n]
+		if crc32.ChecksumIEEE(body) != sum {
+			break
+		}
+		var r storedRecord
+		err = bencode.Unmarshal(bytes.NewReader(body), &r)
+		if err == nil {
+			err = f.apply(r)
+		}
+		if err != nil {
+			return fmt.Errorf("replaying log at %d: %w", off, err)
+		}
+		off += 8 + n
+		f.records++
+	}
+	if off < len(data) {
+		err = f.log.Truncate(int64(off))
+		if err != nil {
+			return err
+		}
+	}
+	f.size = int64(off)
+	_, err = f.log.Seek(f.size, io.SeekStart)
+	return err
+}
+
+// apply makes the change of r to the state in memory.
+func (f *FileStore) apply(r storedRecord) error {
+	switch r.Op {
+	case "peer", "unpeer", "pruned":
+		h, err := infoHash(r.InfoHash)
+		if err != nil {
+			return err
+		}
+		if len(r.PeerID) != len(tracker.PeerID{}) {
+			return ErrPeerID
+		}
+		id := tracker.PeerID([]byte(r.PeerID))
+		if r.Op == "unpeer" {
+			return f.mem.DeletePeer(h, id)
+		}
+		var sp storedPeer
+		err = bencode.Unmarshal(bytes.NewReader(r.Value), &sp)
+		if err != nil {
+			return err
+		}
+		p, err := decodePeer(sp)
+		if err != nil {
+			return err
+		}
+		if r.Op == "pruned" {
+			f.mem.setPruned(h, id, p)
+			return nil
+		}
+		return f.mem.PutPeer(h, id, p)
+	case "downloaded":
+		h, err := infoHash(r.InfoHash)
+		if err != nil {
+			return err
+		}
+		n, err := bencode.DecodeInt(bytes.NewReader(r.Value))
+		if err != nil {
+			return err
+		}
+		f.mem.setDownloaded(h, n)
+	case "user":
+		var su storedUser
+		err := bencode.Unmarshal(bytes.NewReader(r.Value), &su)
+		if err != nil {
+			return err
+		}
+		return f.mem.PutUser(User{Passkey: r.Passkey, Uploaded: int64(su.Uploaded), Downloaded: int64(su.Downloaded)})
+	case "unuser":
+		return f.mem.DeleteUser(r.Passkey)
+	default:
+		return fmt.Errorf("unknown record %q", r.Op)
+	}
+	return nil
+}
+
+func encodePeer(p Peer) storedPeer {
+	sp := storedPeer{
+		Addr:       p.Addr.String(),
+		Downloaded: int(p.Downloaded),
+		Expires:    int(p.Expires.UnixNano()),
+		Left:       int(p.Left),
+		Passkey:    p.Passkey,
+		Uploaded:   int(p.Uploaded),
+	}
+	if p.Completed {
+		sp.Completed = 1
+	}
+	return sp
+}
+
+func decodePeer(sp storedPeer) (Peer, error) {
+	addr, err := netip.ParseAddrPort(sp.Addr)
+	if err != nil {
+		return Peer{}, err
+	}
+	return Peer{
+		Addr:       addr,
+		Left:       int64(sp.Left),
+		Completed:  sp.Completed != 0,
+		Expires:    time.Unix(0, int64(sp.Expires)),
+		Passkey:    sp.Passkey,
+		Uploaded:   int64(sp.Uploaded),
+		Downloaded: int64(sp.Downloaded),
+	}, nil
+}
+
+func infoHash(s string) (metainfo.InfoHash, error) {
+	if len(s) != len(metainfo.InfoHash{}) {
+		return metainfo.InfoHash{}, ErrInfoHash
+	}
+	return metainfo.InfoHash([]byte(s)), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
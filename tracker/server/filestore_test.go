@@ -0,0 +1,342 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeOps is a run of changes made to a store, one per call.
+func storeOps() []func(Store) error {
+	h1, h2 := metainfo.InfoHash{1}, metainfo.InfoHash{2}
+	peer := func(i int) Peer {
+		return Peer{
+			Addr:     netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}), 6881),
+			Left:     int64(i * 100),
+			Expires:  time.Unix(1700000000, int64(i)),
+			Passkey:  "k",
+			Uploaded: int64(i) << 33,
+		}
+	}
+	return []func(Store) error{
+		func(s Store) error { return s.PutUser(User{Passkey: "k"}) },
+		func(s Store) error { return s.PutPeer(h1, peerID(1), peer(1)) },
+		func(s Store) error { return s.PutPeer(h1, peerID(2), peer(2)) },
+		func(s Store) error { return s.PutPeer(h2, peerID(1), peer(3)) },
+		func(s Store) error { return s.AddDownload(h1) },
+		func(s Store) error { return s.PutUser(User{Passkey: "k", Uploaded: 5, Downloaded: 7}) },
+		func(s Store) error { return s.DeletePeer(h1, peerID(1)) },
+		func(s Store) error { return s.PutUser(User{Passkey: "j", Uploaded: 1}) },
+		func(s Store) error { return s.DeletePeer(h2, peerID(1)) },
+		func(s Store) error { return s.DeletePeer(h1, peerID(2)) },
+		func(s Store) error { return s.AddDownload(h1) },
+		func(s Store) error { return s.DeleteUser("j") },
+		func(s Store) error {
+			p := peer(4)
+			p.Completed = true
+			return s.PutPeer(h2, peerID(4), p)
+		},
+		func(s Store) error { return s.PutPeer(h1, peerID(5), peer(5)) },
+		func(s Store) error { return s.PrunePeer(h2, peerID(4)) },
+		func(s Store) error { return s.PrunePeer(h1, peerID(5)) },
+		func(s Store) error { return s.PutPeer(h2, peerID(4), peer(6)) },
+		func(s Store) error { return s.PutPeer(h1, peerID(6), peer(6)) },
+		func(s Store) error { return s.PrunePeer(h1, peerID(6)) },
+		func(s Store) error { return s.DeletePeer(h1, peerID(5)) },
+		func(s Store) error { return s.PrunePeer(h1, peerID(7)) },
+	}
+}
+
+// storeState reads everything out of s for comparison.
+func storeState(t *testing.T, s Store) (map[metainfo.InfoHash]Swarm, []User) {
+	t.Helper()
+	hashes, err := s.InfoHashes()
+	require.NoError(t, err)
+	swarms := make(map[metainfo.InfoHash]Swarm)
+	for _, h := range hashes {
+		sw, err := s.Swarm(h)
+		require.NoError(t, err)
+		swarms[h] = *sw
+	}
+	users, err := s.Users()
+	require.NoError(t, err)
+	return swarms, users
+}
+
+// wantState is the state after the first n of ops.
+func wantState(t *testing.T, ops []func(Store) error, n int) (map[metainfo.InfoHash]Swarm, []User) {
+	t.Helper()
+	m := &MemoryStore{}
+	for _, op := range ops[:n] {
+		require.NoError(t, op(m))
+	}
+	return storeState(t, m)
+}
+
+func assertState(t *testing.T, s Store, ops []func(Store) error, n int) {
+	t.Helper()
+	wantSwarms, wantUsers := wantState(t, ops, n)
+	swarms, users := storeState(t, s)
+	assert.Equal(t, wantSwarms, swarms)
+	assert.Equal(t, wantUsers, users)
+}
+
+func TestFileStore(t *testing.T) {
+	ops := storeOps()
+	for _, compactAfter := range []int{0, 1, 4} {
+		t.Run(fmt.Sprint(compactAfter), func(t *testing.T) {
+			dir := t.TempDir()
+			f, err := OpenFileStore(dir)
+			require.NoError(t, err)
+			f.CompactAfter = compactAfter
+			for i, op := range ops {
+				require.NoError(t, op(f))
+				assertState(t, f, ops, i+1)
+			}
+			require.NoError(t, f.Close())
+
+			f, err = OpenFileStore(dir)
+			require.NoError(t, err)
+			defer f.Close()
+			assertState(t, f, ops, len(ops))
+			_, err = os.Stat(filepath.Join(dir, snapshotName))
+			assert.Equal(t, compactAfter != 0, err == nil)
+		})
+	}
+}
+
+func TestFileStoreSyncInterval(t *testing.T) {
+	ops := storeOps()
+	for _, interval := range []time.Duration{time.Millisecond, time.Hour} {
+		t.Run(interval.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			f, err := OpenFileStore(dir)
+			require.NoError(t, err)
+			f.SyncInterval = interval
+			for _, op := range ops {
+				require.NoError(t, op(f))
+			}
+			// one sync is pending for all the changes, until it runs
+			pending := func() bool {
+				f.syncMu.Lock()
+				defer f.syncMu.Unlock()
+				return f.syncTimer != nil
+			}
+			if interval == time.Hour {
+				assert.True(t, pending())
+			} else {
+				assert.Eventually(t, func() bool { return !pending() }, time.Second, time.Millisecond)
+			}
+			require.NoError(t, f.Close())
+			assert.False(t, pending())
+
+			f, err = OpenFileStore(dir)
+			require.NoError(t, err)
+			defer f.Close()
+			assertState(t, f, ops, len(ops))
+		})
+	}
+}
+
+func TestFileStoreTornWrite(t *testing.T) {
+	ops := storeOps()
+	dir := t.TempDir()
+	f, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	f.CompactAfter = 8
+	// the record ends of the log after the snapshot
+	var ends []int64
+	for i, op := range ops {
+		require.NoError(t, op(f))
+		if i == 7 {
+			// no snapshot after the first
+			f.CompactAfter = len(ops)
+		}
+		if i >= 8 {
+			ends = append(ends, f.size)
+		}
+	}
+	require.NoError(t, f.Close())
+	log, err := os.ReadFile(filepath.Join(dir, logName))
+	require.NoError(t, err)
+	require.Equal(t, ends[len(ends)-1], int64(len(log)))
+
+	for cut := range len(log) + 1 {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, logName), log[:cut], 0o644))
+		f, err := OpenFileStore(dir)
+		require.NoError(t, err, cut)
+		whole := 0
+		for whole < len(ends) && ends[whole] <= int64(cut) {
+			whole++
+		}
+		assertState(t, f, ops, 8+whole)
+		if whole > 0 {
+			assert.Equal(t, ends[whole-1], f.size)
+		}
+		require.NoError(t, f.Close())
+	}
+
+	// a corrupt record ends the log
+	bad := append([]byte(nil), log...)
+	bad[ends[1]+10] ^= 0xff
+	require.NoError(t, os.WriteFile(filepath.Join(dir, logName), bad, 0o644))
+	f, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	assertState(t, f, ops, 8+2)
+	// appending goes on after the last good record
+	require.NoError(t, ops[11](f))
+	require.NoError(t, f.Close())
+	f, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	defer f.Close()
+	assertState(t, f, append(ops[:10:10], ops[11]), 11)
+}
+
+func TestFileStoreSnapshotCrash(t *testing.T) {
+	// a crash after the snapshot is renamed in but before the log is
+	// emptied leaves a log the snapshot already holds
+	ops := storeOps()
+	dir := t.TempDir()
+	f, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	for _, op := range ops {
+		require.NoError(t, op(f))
+	}
+	log, err := os.ReadFile(filepath.Join(dir, logName))
+	require.NoError(t, err)
+	require.NoError(t, f.Snapshot())
+	require.NoError(t, f.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, logName), log, 0o644))
+
+	f, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	defer f.Close()
+	assertState(t, f, ops, len(ops))
+}
+
+// TestFileStoreKill kills a process writing to a store at random moments
+// and checks that each reload holds a prefix of its writes.
+func TestFileStoreKill(t *testing.T) {
+	if dir := os.Getenv("FILESTORE_KILL_DIR"); dir != "" {
+		killWriter(dir)
+		return
+	}
+	if testing.Short() {
+		t.Skip("spawns processes")
+	}
+	dir := t.TempDir()
+	var last int64
+	pruned := 0
+	for round := range 8 {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestFileStoreKill$")
+		cmd.Env = append(os.Environ(), "FILESTORE_KILL_DIR="+dir)
+		require.NoError(t, cmd.Start())
+		time.Sleep(time.Duration(20+round*15) * time.Millisecond)
+		require.NoError(t, cmd.Process.Kill())
+		cmd.Wait()
+
+		f, err := OpenFileStore(dir)
+		require.NoError(t, err)
+		u, ok, err := f.User("k")
+		require.NoError(t, err)
+		sw, err := f.Swarm(metainfo.InfoHash{1})
+		require.NoError(t, err)
+		if ok && sw != nil {
+			// the user is written before the peers of every step
+			p := sw.Peers[peerID(1)]
+			assert.Contains(t, []int64{p.Uploaded, p.Uploaded + 1}, u.Uploaded, "round %d", round)
+			// and the second peer is listed, then pruned
+			q, listed := sw.Peers[peerID(2)]
+			if !listed {
+				q = sw.Pruned[peerID(2)]
+				pruned++
+			}
+			assert.Contains(t, []int64{p.Uploaded - 1, p.Uploaded}, q.Uploaded, "round %d", round)
+		}
+		assert.GreaterOrEqual(t, u.Uploaded, last)
+		last = u.Uploaded
+		require.NoError(t, f.Close())
+	}
+	assert.Positive(t, last)
+	assert.Positive(t, pruned)
+}
+
+// killWriter keeps writing to the store in dir until it is killed,
+// carrying on from what is there.
+func killWriter(dir string) {
+	f, err := OpenFileStore(dir)
+	if err != nil {
+		panic(err)
+	}
+	f.CompactAfter = 50
+	u, _, _ := f.User("k")
+	for i := u.Uploaded + 1; ; i++ {
+		err = f.PutUser(User{Passkey: "k", Uploaded: i})
+		for id := byte(1); id <= 2 && err == nil; id++ {
+			err = f.PutPeer(metainfo.InfoHash{1}, peerID(id), Peer{
+				Addr:     netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, id}), 1),
+				Uploaded: i,
+			})
+		}
+		if err == nil {
+			err = f.PrunePeer(metainfo.InfoHash{1}, peerID(2))
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestServerFileStore(t *testing.T) {
+	dir := t.TempDir()
+	f, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	s := &Server{Private: true, Store: f}
+	require.NoError(t, s.AddUser("k"))
+	req := &tracker.AnnounceRequest{InfoHash: metainfo.InfoHash{1}, PeerID: peerID(1), Port: 1, Uploaded: 10, Left: 0, Event: tracker.Completed}
+	_, err = s.announce(req, netip.MustParseAddr("10.0.0.1"), "k")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// a restarted tracker picks up where it stopped
+	f, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	s = &Server{Private: true, Store: f}
+	req.Uploaded, req.Event = 25, tracker.None
+	res, err := s.announce(req, netip.MustParseAddr("10.0.0.1"), "k")
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Seeders)
+	u, _, err := s.User("k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(25), u.Uploaded)
+	files, err := s.scrape(nil, "k")
+	require.NoError(t, err)
+	assert.Equal(t, tracker.ScrapeStats{Seeders: 1, Downloaded: 1}, files[req.InfoHash])
+
+	// the counters of an expired peer outlive a restart too
+	clock := &testClock{now: time.Now().Add(3 * defaultInterval)}
+	s.Clock = clock
+	files, err = s.scrape(nil, "k")
+	require.NoError(t, err)
+	assert.Equal(t, tracker.ScrapeStats{Downloaded: 1}, files[req.InfoHash])
+	require.NoError(t, f.Close())
+	f, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	defer f.Close()
+	s = &Server{Private: true, Store: f, Clock: clock}
+	req.Uploaded = 40
+	_, err = s.announce(req, netip.MustParseAddr("10.0.0.1"), "k")
+	require.NoError(t, err)
+	u, _, err = s.User("k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), u.Uploaded)
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/websocket"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+// maxScrapeHashes bounds the info hashes of one HTTP scrape.
+const maxScrapeHashes = 256
+
+type announceResponse struct {
+	Complete       int                `bencode:"complete"`
+	Incomplete     int                `bencode:"incomplete"`
+	Interval       int                `bencode:"interval"`
+	MinInterval    int                `bencode:"min interval,omitempty"`
+	Peers          bencode.RawMessage `bencode:"peers"`
+	Peers6         string             `bencode:"peers6,omitempty"`
+	WarningMessage string             `bencode:"warning message,omitempty"`
+}
+
+type dictPeer struct {
+	IP     string `bencode:"ip"`
+	PeerID string `bencode:"peer id,omitempty"`
+	Port   int    `bencode:"port"`
+}
+
+type scrapeResponse struct {
+	Files map[string]scrapeFile `bencode:"files"`
+}
+
+type scrapeFile struct {
+	Complete   int `bencode:"complete"`
+	Downloaded int `bencode:"downloaded"`
+	Incomplete int `bencode:"incomplete"`
+}
+
+type failureResponse struct {
+	FailureReason string `bencode:"failure reason"`
+}
+
+// ServeHTTP answers announces and scrapes at any path whose last element
+// is "announce" or "scrape". Refused requests get a bencoded failure
+// reason. WebSocket upgrades are accepted at any path.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsUpgrade(r) {
+		s.serveWebSocket(w, r)
+		return
+	}
+	switch r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:] {
+	case "announce":
+		s.serveAnnounce(w, r)
+	case "scrape":
+		s.serveScrape(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveAnnounce(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req, err := parseAnnounce(q)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	ip, err := remoteAddr(r)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	res, err := s.announce(req, ip, passkey(r.URL.Path))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	ar := announceResponse{
+		Complete:    res.Seeders,
+		Incomplete:  res.Leechers,
+		Interval:    int(res.Interval.Seconds()),
+		MinInterval: int(res.MinInterval.Seconds()),
+	}
+	if q.Get("compact") == "0" {
+		peers := []dictPeer{}
+		for _, p := range res.Peers {
+			dp := dictPeer{IP: p.Addr.Addr().String(), Port: int(p.Addr.Port())}
+			if q.Get("no_peer_id") != "1" {
+				dp.PeerID = p.ID
+			}
+			peers = append(peers, dp)
+		}
+		ar.Peers, err = bencode.AppendBencode(nil, peers)
+	} else {
+		var peers, peers6 []byte
+		for _, p := range res.Peers {
+			if p.Addr.Addr().Is4() {
+				peers = wire.AppendCompactPeer(peers, p.Addr)
+			} else {
+				peers6 = wire.AppendCompactPeer(peers6, p.Addr)
+			}
+		}
+		ar.Peers, err = bencode.AppendBencode(nil, string(peers))
+		ar.Peers6 = string(peers6)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBencode(w, ar)
+}
+
+func (s *Server) serveScrape(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query()["info_hash"]
+	if len(raw) > maxScrapeHashes {
+		writeFailure(w, ErrTooMany)
+		return
+	}
+	var hashes []metainfo.InfoHash
+	for _, h := range raw {
+		if len(h) != len(metainfo.InfoHash{}) {
+			writeFailure(w, ErrInfoHash)
+			return
+		}
+		hashes = append(hashes, metainfo.InfoHash([]byte(h)))
+	}
+	files, err := s.scrape(hashes, passkey(r.URL.Path))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	sr := scrapeResponse{Files: make(map[string]scrapeFile)}
+	for h, st := range files {
+		sr.Files[string(h[:])] = scrapeFile{
+			Complete:   st.Seeders,
+			Downloaded: st.Downloaded,
+			Incomplete: st.Leechers,
+		}
+	}
+	writeBencode(w, sr)
+}
+
+func parseAnnounce(q url.Values) (*tracker.AnnounceRequest, error) {
+	req := &tracker.AnnounceRequest{}
+	h := q.Get("info_hash")
+	if len(h) != len(req.InfoHash) {
+		return nil, ErrInfoHash
+	}
+	copy(req.InfoHash[:], h)
+	id := q.Get("peer_id")
+	if len(id) != len(req.PeerID) {
+		return nil, ErrPeerID
+	}
+	copy(req.PeerID[:], id)
+	port, err := strconv.ParseUint(q.Get("port"), 10, 16)
+	if err != nil || port == 0 {
+		return nil, ErrPort
+	}
+	req.Port = int(port)
+	for _, c := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"uploaded", &req.Uploaded},
+		{"downloaded", &req.Downloaded},
+		{"left", &req.Left},
+	} {
+		v := q.Get(c.name)
+		if v == "" {
+			continue
+		}
+		*c.dst, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || *c.dst < 0 {
+			return nil, fmt.Errorf("%w %s", ErrParam, c.name)
+		}
+	}
+	switch q.Get("event") {
+	case "", "empty":
+	case "started":
+		req.Event = tracker.Started
+	case "completed":
+		req.Event = tracker.Completed
+	case "stopped":
+		req.Event = tracker.Stopped
+	default:
+		return nil, fmt.Errorf("%w event", ErrParam)
+	}
+	if v := q.Get("numwant"); v != "" {
+		req.NumWant, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w numwant", ErrParam)
+		}
+	}
+	return req, nil
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return ip.Unmap(), nil
+}
+
+func writeFailure(w http.ResponseWriter, err error) {
+	writeBencode(w, failureResponse{FailureReason: err.Error()})
+}
+
+func writeBencode(w http.ResponseWriter, v any) {
+	b, err := bencode.AppendBencode(nil, v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(b)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRequest(id byte, port int) *tracker.AnnounceRequest {
+	return &tracker.AnnounceRequest{
+		InfoHash: metainfo.InfoHash{0xaa, '&', ' ', '%'},
+		PeerID:   peerID(id),
+		Port:     port,
+		Left:     100,
+		Event:    tracker.Started,
+	}
+}
+
+func TestHTTPAnnounce(t *testing.T) {
+	s := &Server{Interval: 10 * time.Minute, MinInterval: time.Minute}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := &tracker.HTTPClient{}
+	ctx := context.Background()
+	announce := ts.URL + "/announce"
+
+	// a peer announced over IPv6 comes back in peers6
+	_, err := s.announce(testRequest(9, 51413), netip.MustParseAddr("2001:db8::9"), "")
+	require.NoError(t, err)
+	res, err := client.Announce(ctx, announce, testRequest(1, 6881))
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, res.Interval)
+	assert.Equal(t, time.Minute, res.MinInterval)
+	assert.Equal(t, 2, res.Leechers)
+	assert.Equal(t, []tracker.Peer{{Addr: netip.MustParseAddrPort("[2001:db8::9]:51413")}}, res.Peers)
+
+	req := testRequest(2, 6882)
+	req.Left = 0
+	res, err = client.Announce(ctx, announce, req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Seeders)
+	assert.ElementsMatch(t, []netip.AddrPort{
+		netip.MustParseAddrPort("127.0.0.1:6881"),
+		netip.MustParseAddrPort("[2001:db8::9]:51413"),
+	}, addrs(res.Peers))
+
+	scrape, err := client.Scrape(ctx, announce, []metainfo.InfoHash{req.InfoHash, {1}})
+	require.NoError(t, err)
+	assert.Equal(t, map[metainfo.InfoHash]tracker.ScrapeStats{
+		req.InfoHash: {Seeders: 1, Leechers: 2},
+		{1}:          {},
+	}, scrape.Files)
+
+	req.Event = tracker.Stopped
+	_, err = client.Announce(ctx, ts.URL+"/x/announce", req)
+	require.NoError(t, err)
+	assert.Equal(t, tracker.ScrapeStats{Leechers: 2}, scrapeAll(t, s)[req.InfoHash])
+}
+
+func TestHTTPNonCompact(t *testing.T) {
+	s := &Server{}
+	_, err := s.announce(testRequest(9, 51413), netip.MustParseAddr("10.0.0.9"), "")
+	require.NoError(t, err)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	get := func(query string) string {
+		resp, err := http.Get(ts.URL + "/announce?info_hash=%aa%26%20%25" +
+			"%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&peer_id=-XX0001-000000000000&port=1" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+	id := peerID(9)
+	assert.Equal(t, "d8:completei0e10:incompletei2e8:intervali1800e5:peersld2:ip8:10.0.0.97:peer id20:"+string(id[:])+"4:porti51413eeee",
+		get("&left=5&compact=0"))
+	assert.Equal(t, "d8:completei0e10:incompletei2e8:intervali1800e5:peersld2:ip8:10.0.0.94:porti51413eeee",
+		get("&left=5&compact=0&no_peer_id=1"))
+	assert.Equal(t, "d8:completei0e10:incompletei2e8:intervali1800e5:peers6:\x0a\x00\x00\x09\xc8\xd5e",
+		get("&left=5"))
+	assert.Equal(t, "d14:failure reason22:invalid parameter lefte", get("&left=-1"))
+	assert.Equal(t, "d14:failure reason23:invalid parameter evente", get("&left=5&event=paused"))
+
+	resp, err := http.Get(ts.URL + "/announce?info_hash=abc&peer_id=-XX0001-000000000000&port=1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "d14:failure reason17:invalid info_hashe", string(b))
+}
+
+func TestHTTPNotFound(t *testing.T) {
+	ts := httptest.NewServer(&Server{})
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/announcer")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
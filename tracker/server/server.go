@@ -0,0 +1,341 @@
+// Package server implements a BitTorrent tracker speaking the HTTP
+// (BEP 3, BEP 23, BEP 7, BEP 48) and UDP (BEP 15) protocols, and the
+// WebTorrent protocol over WebSocket.
+package server
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/websocket"
+)
+
+const (
+	defaultInterval = 30 * time.Minute
+	defaultNumWant  = 50
+	defaultMaxPeers = 200
+
+	// prunedTTL is how long the counters of a peer of a private tracker
+	// are remembered after it expires, in case it announces again.
+	prunedTTL = 7 * 24 * time.Hour
+)
+
+// Server tracks swarms in its Store. Configure it before serving; the zero
+// value tracks every torrent in memory with a 30 minute interval.
+type Server struct {
+	Interval    time.Duration // 0 means 30 minutes
+	MinInterval time.Duration // sent when non-zero
+	// PeerTTL is how long a peer stays listed without announcing again;
+	// 0 means twice the interval. Expired peers are dropped when their
+	// swarm is next used, and from every swarm once an interval.
+	PeerTTL  time.Duration
+	MaxPeers int // most peers in one response; 0 means 200
+
+	// Whitelist, when non-nil, lists the only torrents tracked. Blacklist
+	// lists torrents refused.
+	Whitelist map[metainfo.InfoHash]bool
+	Blacklist map[metainfo.InfoHash]bool
+
+	// Private requires every request to carry a registered passkey as the
+	// path element before "announce" or "scrape", as in /<passkey>/announce,
+	// and accounts each user's transfer from the deltas between announces.
+	Private bool
+	// Clients, when non-empty, lists the peer ID prefixes of the only
+	// clients allowed, such as "-qB" or "-TR".
+	Clients []string
+
+	Clock tracker.Clock // nil uses the system clock
+	Store Store         // nil keeps the state in memory
+
+	mu sync.Mutex
+	// WebSocket peers are only listed while connected, so their swarms
+	// stay in memory whatever the Store.
+	wsSwarms MemoryStore
+	wsConns  map[wsPeerKey]*websocket.Conn
+	swept    time.Time // last sweep of every swarm
+
+	secretOnce sync.Once
+	secret     [16]byte
+}
+
+func (s *Server) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock.Now()
+}
+
+func (s *Server) interval() time.Duration {
+	if s.Interval <= 0 {
+		return defaultInterval
+	}
+	return s.Interval
+}
+
+func (s *Server) allowed(h metainfo.InfoHash) bool {
+	if s.Whitelist != nil && !s.Whitelist[h] {
+		return false
+	}
+	return !s.Blacklist[h]
+}
+
+// announce records the peer at ip sending req with passkey and returns
+// the response with up to req.NumWant other peers of both address
+// families.
+func (s *Server) announce(req *tracker.AnnounceRequest, ip netip.Addr, passkey string) (*tracker.AnnounceResponse, error) {
+	return s.announceLimited(req, ip, passkey, nil, 0)
+}
+
+// announceLimited is announce returning only the peers whose address match
+// accepts, when given, and no more than limit of them when positive.
+func (s *Server) announceLimited(req *tracker.AnnounceRequest, ip netip.Addr, passkey string, match func(netip.Addr) bool, limit int) (*tracker.AnnounceResponse, error) {
+	if !s.clientAllowed(req.PeerID) {
+		return nil, ErrClient
+	}
+	if !s.allowed(req.InfoHash) {
+		return nil, ErrNotAllowed
+	}
+	now := s.now()
+	ttl := s.PeerTTL
+	if ttl <= 0 {
+		ttl = 2 * s.interval()
+	}
+	numWant := req.NumWant
+	if numWant <= 0 {
+		numWant = defaultNumWant
+	}
+	maxPeers := s.MaxPeers
+	if maxPeers <= 0 {
+		maxPeers = defaultMaxPeers
+	}
+	numWant = min(numWant, maxPeers)
+	if limit > 0 {
+		numWant = min(numWant, limit)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.sweep(now)
+	if err != nil {
+		return nil, err
+	}
+	st := s.store()
+	var user User
+	if s.Private {
+		var ok bool
+		user, ok, err = st.User(passkey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrPasskey
+		}
+	}
+	sw, err := s.swarm(req.InfoHash, now)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := sw.peer(req.PeerID)
+	var gone Peer
+	var pruned bool
+	if sw != nil {
+		gone, pruned = sw.Pruned[req.PeerID]
+	}
+	if ok && p.Passkey != passkey {
+		// another user's peer ID: start the accounting afresh
+		p.Passkey, p.Uploaded, p.Downloaded = passkey, 0, 0
+	}
+	if s.Private {
+		var last *Peer
+		if ok {
+			last = &p
+		} else if pruned && gone.Passkey == passkey {
+			last = &gone
+		}
+		user.account(last, req)
+		err = st.PutUser(user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if req.Event == tracker.Stopped {
+		if ok || pruned {
+			err = st.DeletePeer(req.InfoHash, req.PeerID)
+		}
+	} else {
+		if !ok {
+			p = Peer{Passkey: passkey}
+		}
+		// a download counts once, when a leecher reports it is done
+		download := false
+		if !p.Completed && req.Left == 0 {
+			p.Completed = true
+			download = req.Event == tracker.Completed || ok && p.Left > 0
+		}
+		p.Addr = netip.AddrPortFrom(ip.Unmap(), uint16(req.Port))
+		p.Left = req.Left
+		p.Uploaded = req.Uploaded
+		p.Downloaded = req.Downloaded
+		p.Expires = now.Add(ttl)
+		err = st.PutPeer(req.InfoHash, req.PeerID, p)
+		if err == nil && download {
+			err = st.AddDownload(req.InfoHash)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sw, err = st.Swarm(req.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &tracker.AnnounceResponse{Interval: s.interval(), MinInterval: s.MinInterval}
+	res.Seeders, res.Leechers = sw.counts()
+	if req.Event == tracker.Stopped {
+		return res, nil
+	}
+	for id, p := range sw.Peers {
+		if len(res.Peers) == numWant {
+			break
+		}
+		// seeds have no use for other seeds
+		if id == req.PeerID || req.Left == 0 && p.Left == 0 {
+			continue
+		}
+		if match != nil && !match(p.Addr.Addr()) {
+			continue
+		}
+		res.Peers = append(res.Peers, tracker.Peer{Addr: p.Addr, ID: string(id[:])})
+	}
+	return res, nil
+}
+
+// scrape returns the stats of the given swarms, or of all when hashes is
+// empty. Unknown and refused torrents are reported empty.
+func (s *Server) scrape(hashes []metainfo.InfoHash, passkey string) (map[metainfo.InfoHash]tracker.ScrapeStats, error) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.sweep(now)
+	if err != nil {
+		return nil, err
+	}
+	st := s.store()
+	if s.Private {
+		_, ok, err := st.User(passkey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrPasskey
+		}
+	}
+	if len(hashes) == 0 {
+		hashes, err = st.InfoHashes()
+		if err != nil {
+			return nil, err
+		}
+	}
+	files := make(map[metainfo.InfoHash]tracker.ScrapeStats, len(hashes))
+	for _, h := range hashes {
+		var stats tracker.ScrapeStats
+		if s.allowed(h) {
+			sw, err := s.swarm(h, now)
+			if err != nil {
+				return nil, err
+			}
+			stats.Seeders, stats.Leechers = sw.counts()
+			if sw != nil {
+				stats.Downloaded = sw.Downloaded
+			}
+		}
+		files[h] = stats
+	}
+	return files, nil
+}
+
+func (s *Server) clientAllowed(id tracker.PeerID) bool {
+	if len(s.Clients) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.Clients, func(prefix string) bool {
+		return strings.HasPrefix(string(id[:]), prefix)
+	})
+}
+
+// store returns the Store in use; s.mu must be held.
+func (s *Server) store() Store {
+	if s.Store == nil {
+		s.Store = &MemoryStore{}
+	}
+	return s.Store
+}
+
+// sweep drops the expired peers of every swarm, so that swarms nobody
+// announces to or scrapes are reclaimed too. It runs once an interval;
+// s.mu must be held.
+func (s *Server) sweep(now time.Time) error {
+	if now.Before(s.swept.Add(s.interval())) {
+		return nil
+	}
+	s.swept = now
+	hashes, err := s.store().InfoHashes()
+	if err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		_, err = s.swarm(h, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// swarm returns the swarm of h, or nil, after dropping its peers expired
+// at now; s.mu must be held. A private tracker keeps the peers dropped as
+// pruned for prunedTTL.
+func (s *Server) swarm(h metainfo.InfoHash, now time.Time) (*Swarm, error) {
+	st := s.store()
+	sw, err := st.Swarm(h)
+	if err != nil || sw == nil {
+		return nil, err
+	}
+	var expired, forgotten []tracker.PeerID
+	for id, p := range sw.Peers {
+		if now.After(p.Expires) {
+			expired = append(expired, id)
+		}
+	}
+	for id, p := range sw.Pruned {
+		if now.After(p.Expires.Add(prunedTTL)) {
+			forgotten = append(forgotten, id)
+		}
+	}
+	if len(expired) == 0 && len(forgotten) == 0 {
+		return sw, nil
+	}
+	for _, id := range expired {
+		if s.Private {
+			err = st.PrunePeer(h, id)
+		} else {
+			err = st.DeletePeer(h, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range forgotten {
+		err = st.DeletePeer(h, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return st.Swarm(h)
+}
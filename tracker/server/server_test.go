@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time {
+	panic("not used")
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func peerID(b byte) tracker.PeerID {
+	var id tracker.PeerID
+	id[0] = b
+	return id
+}
+
+func announceAs(t *testing.T, s *Server, h metainfo.InfoHash, id byte, ip string, left int64, event tracker.Event) *tracker.AnnounceResponse {
+	t.Helper()
+	res, err := s.announce(&tracker.AnnounceRequest{
+		InfoHash: h,
+		PeerID:   peerID(id),
+		Port:     6881,
+		Left:     left,
+		Event:    event,
+	}, netip.MustParseAddr(ip), "")
+	require.NoError(t, err)
+	return res
+}
+
+func scrape(t *testing.T, s *Server, hashes []metainfo.InfoHash) map[metainfo.InfoHash]tracker.ScrapeStats {
+	t.Helper()
+	files, err := s.scrape(hashes, "")
+	require.NoError(t, err)
+	return files
+}
+
+func scrapeAll(t *testing.T, s *Server) map[metainfo.InfoHash]tracker.ScrapeStats {
+	t.Helper()
+	return scrape(t, s, nil)
+}
+
+func TestSwarm(t *testing.T) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := &Server{Interval: time.Minute, Clock: clock}
+	h := metainfo.InfoHash{1}
+
+	res := announceAs(t, s, h, 1, "10.0.0.1", 100, tracker.Started)
+	assert.Equal(t, time.Minute, res.Interval)
+	assert.Empty(t, res.Peers)
+	assert.Equal(t, 1, res.Leechers)
+
+	announceAs(t, s, h, 2, "10.0.0.2", 0, tracker.Started)
+	res = announceAs(t, s, h, 3, "2001:db8::3", 50, tracker.Started)
+	assert.Equal(t, 1, res.Seeders)
+	assert.Equal(t, 2, res.Leechers)
+	assert.ElementsMatch(t, []netip.AddrPort{
+		netip.MustParseAddrPort("10.0.0.1:6881"),
+		netip.MustParseAddrPort("10.0.0.2:6881"),
+	}, addrs(res.Peers))
+
+	// a seed is not sent other seeds
+	res = announceAs(t, s, h, 2, "10.0.0.2", 0, tracker.None)
+	assert.ElementsMatch(t, []netip.AddrPort{
+		netip.MustParseAddrPort("10.0.0.1:6881"),
+		netip.MustParseAddrPort("[2001:db8::3]:6881"),
+	}, addrs(res.Peers))
+
+	// completing counts a download once
+	announceAs(t, s, h, 1, "10.0.0.1", 0, tracker.Completed)
+	announceAs(t, s, h, 1, "10.0.0.1", 0, tracker.None)
+	assert.Equal(t, tracker.ScrapeStats{Seeders: 2, Leechers: 1, Downloaded: 1}, scrape(t, s, []metainfo.InfoHash{h})[h])
+
+	announceAs(t, s, h, 3, "2001:db8::3", 50, tracker.Stopped)
+	assert.Equal(t, tracker.ScrapeStats{Seeders: 2, Downloaded: 1}, scrapeAll(t, s)[h])
+
+	// peers not heard from for twice the interval expire
+	clock.Advance(90 * time.Second)
+	announceAs(t, s, h, 1, "10.0.0.1", 0, tracker.None)
+	clock.Advance(40 * time.Second)
+	assert.Equal(t, tracker.ScrapeStats{Seeders: 1, Downloaded: 1}, scrapeAll(t, s)[h])
+
+	assert.Equal(t, tracker.ScrapeStats{}, scrape(t, s, []metainfo.InfoHash{{2}})[metainfo.InfoHash{2}])
+}
+
+func TestSweep(t *testing.T) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	st := &MemoryStore{}
+	s := &Server{Interval: time.Minute, Clock: clock, Store: st}
+	a, b := metainfo.InfoHash{1}, metainfo.InfoHash{2}
+	announceAs(t, s, a, 1, "10.0.0.1", 100, tracker.Started)
+
+	// a is never used again, yet its expired peer goes with the next sweep
+	clock.Advance(90 * time.Second)
+	announceAs(t, s, b, 2, "10.0.0.2", 100, tracker.Started)
+	hashes, _ := st.InfoHashes()
+	assert.ElementsMatch(t, []metainfo.InfoHash{a, b}, hashes)
+	clock.Advance(time.Minute)
+	announceAs(t, s, b, 2, "10.0.0.2", 100, tracker.None)
+	hashes, _ = st.InfoHashes()
+	assert.Equal(t, []metainfo.InfoHash{b}, hashes)
+}
+
+func TestNumWant(t *testing.T) {
+	s := &Server{MaxPeers: 5}
+	h := metainfo.InfoHash{1}
+	for i := range 10 {
+		announceAs(t, s, h, byte(i), "10.0.0.1", 1, tracker.None)
+	}
+	res, err := s.announce(&tracker.AnnounceRequest{InfoHash: h, PeerID: peerID(20), Port: 1, Left: 1, NumWant: 3}, netip.MustParseAddr("10.0.0.2"), "")
+	require.NoError(t, err)
+	assert.Len(t, res.Peers, 3)
+	res = announceAs(t, s, h, 21, "10.0.0.3", 1, tracker.None)
+	assert.Len(t, res.Peers, 5)
+}
+
+func TestAllowed(t *testing.T) {
+	a, b, c := metainfo.InfoHash{1}, metainfo.InfoHash{2}, metainfo.InfoHash{3}
+	s := &Server{
+		Whitelist: map[metainfo.InfoHash]bool{a: true, b: true},
+		Blacklist: map[metainfo.InfoHash]bool{b: true},
+	}
+	for h, want := range map[metainfo.InfoHash]error{a: nil, b: ErrNotAllowed, c: ErrNotAllowed} {
+		_, err := s.announce(&tracker.AnnounceRequest{InfoHash: h, Port: 1}, netip.MustParseAddr("10.0.0.1"), "")
+		assert.ErrorIs(t, err, want)
+	}
+	assert.Len(t, scrapeAll(t, s), 1)
+}
+
+func addrs(peers []tracker.Peer) []netip.AddrPort {
+	var out []netip.AddrPort
+	for _, p := range peers {
+		out = append(out, p.Addr)
+	}
+	return out
+}
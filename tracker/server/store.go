@@ -0,0 +1,210 @@
+package server
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+)
+
+// Store keeps the state of a Server: the peers and download count of every
+// swarm, and the user accounts. A Server serializes its calls, so stores
+// need not be safe for concurrent use.
+//
+// A swarm exists while it has peers, pruned peers or downloads: PutPeer
+// creates it and DeletePeer drops it once none is left.
+type Store interface {
+	// Swarm returns the swarm of h, or nil when there is none. The result
+	// may share memory with the store: it must not be modified and is only
+	// valid until the store is next changed.
+	Swarm(h metainfo.InfoHash) (*Swarm, error)
+	InfoHashes() ([]metainfo.InfoHash, error)
+	// PutPeer lists the peer id of h, taking it out of the pruned peers.
+	PutPeer(h metainfo.InfoHash, id tracker.PeerID, p Peer) error
+	// DeletePeer forgets the peer id of h, whether listed or pruned.
+	DeletePeer(h metainfo.InfoHash, id tracker.PeerID) error
+	// PrunePeer moves the listed peer id of h to the pruned peers.
+	PrunePeer(h metainfo.InfoHash, id tracker.PeerID) error
+	// AddDownload counts a completed download in the existing swarm of h.
+	AddDownload(h metainfo.InfoHash) error
+
+	// User returns the account of passkey, and false when it has none.
+	User(passkey string) (User, bool, error)
+	// Users returns every account sorted by passkey.
+	Users() ([]User, error)
+	PutUser(u User) error
+	DeleteUser(passkey string) error
+}
+
+// Swarm is the state of one torrent.
+type Swarm struct {
+	Peers map[tracker.PeerID]Peer
+	// Pruned holds the peers a private tracker dropped on expiry, whose
+	// last counters the accounting of their next announce starts from.
+	// It is nil when empty.
+	Pruned     map[tracker.PeerID]Peer
+	Downloaded int
+}
+
+// Peer is what a Server keeps of one peer of a swarm.
+type Peer struct {
+	Addr      netip.AddrPort
+	Left      int64
+	Completed bool // counted as a download already
+	Expires   time.Time
+	// Passkey, Uploaded and Downloaded are last announced by the peer, for
+	// the accounting of private trackers.
+	Passkey    string
+	Uploaded   int64
+	Downloaded int64
+}
+
+func (sw *Swarm) counts() (seeders, leechers int) {
+	if sw == nil {
+		return 0, 0
+	}
+	for _, p := range sw.Peers {
+		if p.Left == 0 {
+			seeders++
+		} else {
+			leechers++
+		}
+	}
+	return
+}
+
+// MemoryStore is a Store in memory only. The zero value is empty and
+// ready to use.
+type MemoryStore struct {
+	swarms map[metainfo.InfoHash]*Swarm
+	users  map[string]User
+}
+
+func (m *MemoryStore) Swarm(h metainfo.InfoHash) (*Swarm, error) {
+	return m.swarms[h], nil
+}
+
+func (m *MemoryStore) InfoHashes() ([]metainfo.InfoHash, error) {
+	hashes := make([]metainfo.InfoHash, 0, len(m.swarms))
+	for h := range m.swarms {
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+func (m *MemoryStore) PutPeer(h metainfo.InfoHash, id tracker.PeerID, p Peer) error {
+	if m.swarms == nil {
+		m.swarms = make(map[metainfo.InfoHash]*Swarm)
+	}
+	sw := m.swarms[h]
+	if sw == nil {
+		sw = &Swarm{Peers: make(map[tracker.PeerID]Peer)}
+		m.swarms[h] = sw
+	}
+	sw.Peers[id] = p
+	sw.unprune(id)
+	return nil
+}
+
+func (m *MemoryStore) DeletePeer(h metainfo.InfoHash, id tracker.PeerID) error {
+	sw := m.swarms[h]
+	if sw == nil {
+		return nil
+	}
+	delete(sw.Peers, id)
+	sw.unprune(id)
+	if len(sw.Peers) == 0 && sw.Pruned == nil && sw.Downloaded == 0 {
+		delete(m.swarms, h)
+	}
+	return nil
+}
+
+func (m *MemoryStore) PrunePeer(h metainfo.InfoHash, id tracker.PeerID) error {
+	if p, ok := m.swarms[h].peer(id); ok {
+		m.setPruned(h, id, p)
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddDownload(h metainfo.InfoHash) error {
+	if sw := m.swarms[h]; sw != nil {
+		sw.Downloaded++
+	}
+	return nil
+}
+
+// setDownloaded sets the download count of h, creating the swarm.
+func (m *MemoryStore) setDownloaded(h metainfo.InfoHash, n int) {
+	if m.swarms == nil {
+		m.swarms = make(map[metainfo.InfoHash]*Swarm)
+	}
+	sw := m.swarms[h]
+	if sw == nil {
+		sw = &Swarm{Peers: make(map[tracker.PeerID]Peer)}
+		m.swarms[h] = sw
+	}
+	sw.Downloaded = n
+}
+
+// setPruned makes p the pruned peer id of h, creating the swarm.
+func (m *MemoryStore) setPruned(h metainfo.InfoHash, id tracker.PeerID, p Peer) {
+	if m.swarms == nil {
+		m.swarms = make(map[metainfo.InfoHash]*Swarm)
+	}
+	sw := m.swarms[h]
+	if sw == nil {
+		sw = &Swarm{Peers: make(map[tracker.PeerID]Peer)}
+		m.swarms[h] = sw
+	}
+	delete(sw.Peers, id)
+	if sw.Pruned == nil {
+		sw.Pruned = make(map[tracker.PeerID]Peer)
+	}
+	sw.Pruned[id] = p
+}
+
+// peer returns the listed peer id of a swarm that may be nil.
+func (sw *Swarm) peer(id tracker.PeerID) (Peer, bool) {
+	if sw == nil {
+		return Peer{}, false
+	}
+	p, ok := sw.Peers[id]
+	return p, ok
+}
+
+func (sw *Swarm) unprune(id tracker.PeerID) {
+	delete(sw.Pruned, id)
+	if len(sw.Pruned) == 0 {
+		sw.Pruned = nil
+	}
+}
+
+func (m *MemoryStore) User(passkey string) (User, bool, error) {
+	u, ok := m.users[passkey]
+	return u, ok, nil
+}
+
+func (m *MemoryStore) Users() ([]User, error) {
+	users := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(a, b User) int { return strings.Compare(a.Passkey, b.Passkey) })
+	return users, nil
+}
+
+func (m *MemoryStore) PutUser(u User) error {
+	if m.users == nil {
+		m.users = make(map[string]User)
+	}
+	m.users[u.Passkey] = u
+	return nil
+}
+
+func (m *MemoryStore) DeleteUser(passkey string) error {
+	delete(m.users, passkey)
+	return nil
+}
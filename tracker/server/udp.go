@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+)
+
+// connectionIDWindow is how long one connection ID is issued for; IDs stay
+// valid through the following window too.
+const connectionIDWindow = time.Minute
+
+// ServeUDP answers BEP 15 requests arriving on conn until reading from it
+// fails and returns that error; closing conn stops it.
+// Connection IDs are derived from the client IP and a secret, so no
+// per-client state is kept.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, wire.MaxPacket)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		resp := s.handleUDP(buf[:n], addr.AddrPort())
+		if resp != nil {
+			conn.WriteTo(resp, from)
+		}
+	}
+}
+
+// handleUDP returns the reply to pkt from addr, or nil to drop it.
+func (s *Server) handleUDP(pkt []byte, addr netip.AddrPort) []byte {
+	if len(pkt) < 16 {
+		return nil
+	}
+	connID := binary.BigEndian.Uint64(pkt)
+	action := binary.BigEndian.Uint32(pkt[8:])
+	txid := pkt[12:16]
+	if action == wire.ActionConnect {
+		if connID != wire.ProtocolID {
+			return nil
+		}
+		resp := binary.BigEndian.AppendUint32(nil, wire.ActionConnect)
+		resp = append(resp, txid...)
+		return binary.BigEndian.AppendUint64(resp, s.connectionID(addr, s.now(), 0))
+	}
+	now := s.now()
+	if connID != s.connectionID(addr, now, 0) && connID != s.connectionID(addr, now, -1) {
+		return udpError(txid, "invalid connection id")
+	}
+	switch action {
+	case wire.ActionAnnounce:
+		return s.udpAnnounce(pkt, addr)
+	case wire.ActionScrape:
+		return s.udpScrape(pkt)
+	}
+	return udpError(txid, "unknown action")
+}
+
+func (s *Server) udpAnnounce(pkt []byte, addr netip.AddrPort) []byte {
+	txid := pkt[12:16]
+	if len(pkt) < 98 {
+		return udpError(txid, "announce too short")
+	}
+	req := &tracker.AnnounceRequest{
+		InfoHash:   metainfo.InfoHash(pkt[16:36]),
+		PeerID:     tracker.PeerID(pkt[36:56]),
+		Downloaded: int64(binary.BigEndian.Uint64(pkt[56:])),
+		Left:       int64(binary.BigEndian.Uint64(pkt[64:])),
+		Uploaded:   int64(binary.BigEndian.Uint64(pkt[72:])),
+		Event:      tracker.Event(binary.BigEndian.Uint32(pkt[80:])),
+		Key:        binary.BigEndian.Uint32(pkt[88:]),
+		NumWant:    int(int32(binary.BigEndian.Uint32(pkt[92:]))),
+		Port:       int(binary.BigEndian.Uint16(pkt[96:])),
+	}
+	if req.Event > tracker.Stopped {
+		return udpError(txid, "invalid event")
+	}
+	if req.Port == 0 {
+		return udpError(txid, ErrPort.Error())
+	}
+	ip := addr.Addr().Unmap()
+	// the reply holds whole peers of the requester's address family only
+	entrySize := 6
+	if !ip.Is4() {
+		entrySize = 18
+	}
+	sameFamily := func(a netip.Addr) bool { return a.Is4() == ip.Is4() }
+	res, err := s.announceLimited(req, ip, passkey(wire.URLData(pkt[98:])), sameFamily, (wire.MaxPacket-20)/entrySize)
+	if err != nil {
+		return udpError(txid, err.Error())
+	}
+	resp := binary.BigEndian.AppendUint32(nil, wire.ActionAnnounce)
+	resp = append(resp, txid...)
+	resp = binary.BigEndian.AppendUint32(resp, uint32(res.Interval.Seconds()))
+	resp = binary.BigEndian.AppendUint32(resp, uint32(res.Leechers))
+	resp = binary.BigEndian.AppendUint32(resp, uint32(res.Seeders))
+	for _, p := range res.Peers {
+		resp = wire.AppendCompactPeer(resp, p.Addr)
+	}
+	return resp
+}
+
+func (s *Server) udpScrape(pkt []byte) []byte {
+	txid := pkt[12:16]
+	body := pkt[16:]
+	if len(body)%20 != 0 || len(body)/20 > wire.MaxScrapeHashes {
+		return udpError(txid, ErrTooMany.Error())
+	}
+	var hashes []metainfo.InfoHash
+	for i := 0; i < len(body); i += 20 {
+		hashes = append(hashes, metainfo.InfoHash(body[i:i+20]))
+	}
+	// BEP 41 URL data rides on announces only, so a private tracker
+	// cannot tell who scrapes
+	files, err := s.scrape(hashes, "")
+	if err != nil {
+		return udpError(txid, err.Error())
+	}
+	resp := binary.BigEndian.AppendUint32(nil, wire.ActionScrape)
+	resp = append(resp, txid...)
+	for _, h := range hashes {
+		st := files[h]
+		resp = binary.BigEndian.AppendUint32(resp, uint32(st.Seeders))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(st.Downloaded))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(st.Leechers))
+	}
+	return resp
+}
+
+// connectionID derives the ID issued to the IP of addr in the window at
+// now moved by offset windows. The port is left out as clients may send
+// each request from a new socket.
+func (s *Server) connectionID(addr netip.AddrPort, now time.Time, offset int64) uint64 {
+	s.secretOnce.Do(func() { rand.Read(s.secret[:]) })
+	window := now.UnixNano()/int64(connectionIDWindow) + offset
+	h := sha256.New()
+	h.Write(s.secret[:])
+	b, _ := addr.Addr().Unmap().MarshalBinary()
+	h.Write(b)
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(window)))
+	id := binary.BigEndian.Uint64(h.Sum(nil))
+	if id == wire.ProtocolID {
+		id++
+	}
+	return id
+}
+
+func udpError(txid []byte, msg string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, wire.ActionError)
+	b = append(b, txid...)
+	return append(b, msg...)
+}
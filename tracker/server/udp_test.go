@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveUDP(t *testing.T, s *Server, network, addr string) string {
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go s.ServeUDP(conn)
+	return "udp://" + conn.LocalAddr().String()
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	s := &Server{Interval: 10 * time.Minute, Blacklist: map[metainfo.InfoHash]bool{{2}: true}}
+	announce := serveUDP(t, s, "udp4", "127.0.0.1:0")
+	client := &tracker.UDPClient{}
+	ctx := context.Background()
+
+	// the reply holds peers of the requester's family only
+	_, err := s.announce(testRequest(9, 51413), netip.MustParseAddr("2001:db8::9"), "")
+	require.NoError(t, err)
+	_, err = s.announce(testRequest(8, 51413), netip.MustParseAddr("10.0.0.8"), "")
+	require.NoError(t, err)
+	res, err := client.Announce(ctx, announce+"/announce", testRequest(1, 6881))
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, res.Interval)
+	assert.Equal(t, 3, res.Leechers)
+	assert.Equal(t, []tracker.Peer{{Addr: netip.MustParseAddrPort("10.0.0.8:51413")}}, res.Peers)
+
+	scrape, err := client.Scrape(ctx, announce, []metainfo.InfoHash{{1}, testRequest(0, 0).InfoHash})
+	require.NoError(t, err)
+	assert.Equal(t, map[metainfo.InfoHash]tracker.ScrapeStats{
+		{1}:                        {},
+		testRequest(0, 0).InfoHash: {Leechers: 3},
+	}, scrape.Files)
+
+	req := testRequest(1, 6881)
+	req.InfoHash = metainfo.InfoHash{2}
+	_, err = client.Announce(ctx, announce, req)
+	var fe *tracker.FailureError
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, ErrNotAllowed.Error(), fe.Reason)
+}
+
+func TestUDPAnnounceIPv6(t *testing.T) {
+	s := &Server{}
+	announce := serveUDP(t, s, "udp6", "[::1]:0")
+	_, err := s.announce(testRequest(9, 51413), netip.MustParseAddr("2001:db8::9"), "")
+	require.NoError(t, err)
+	_, err = s.announce(testRequest(8, 51413), netip.MustParseAddr("10.0.0.8"), "")
+	require.NoError(t, err)
+	res, err := (&tracker.UDPClient{}).Announce(context.Background(), announce, testRequest(1, 6881))
+	require.NoError(t, err)
+	assert.Equal(t, []tracker.Peer{{Addr: netip.MustParseAddrPort("[2001:db8::9]:51413")}}, res.Peers)
+}
+
+func TestUDPAnnounceFull(t *testing.T) {
+	s := &Server{MaxPeers: 500}
+	for i := range 150 {
+		_, err := s.announce(testRequest(byte(i), 51413), netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: byte(i)}), "")
+		require.NoError(t, err)
+	}
+	_, err := s.announce(testRequest(200, 51413), netip.MustParseAddr("10.0.0.8"), "")
+	require.NoError(t, err)
+
+	addr := netip.MustParseAddrPort("[2001:db8::ffff]:6881")
+	connect := binary.BigEndian.AppendUint64(nil, wire.ProtocolID)
+	connect = append(connect, 0, 0, 0, wire.ActionConnect, 1, 2, 3, 4)
+	pkt := append([]byte(nil), s.handleUDP(connect, addr)[8:16]...)
+	pkt = append(pkt, 0, 0, 0, wire.ActionAnnounce, 5, 6, 7, 8)
+	req := testRequest(201, 6881)
+	pkt = append(pkt, req.InfoHash[:]...)
+	pkt = append(pkt, req.PeerID[:]...)
+	pkt = append(pkt, make([]byte, 36)...)
+	pkt = binary.BigEndian.AppendUint32(pkt, 200)
+	pkt = binary.BigEndian.AppendUint16(pkt, 6881)
+	binary.BigEndian.PutUint64(pkt[64:], 100) // left
+
+	resp := s.handleUDP(pkt, addr)
+	require.Equal(t, uint32(wire.ActionAnnounce), binary.BigEndian.Uint32(resp))
+	// as many whole IPv6 peers as fit, and no IPv4 one taking a slot
+	assert.Len(t, resp, 20+(wire.MaxPacket-20)/18*18)
+	for i := 20; i < len(resp); i += 18 {
+		assert.Equal(t, []byte{0x20, 0x01, 0x0d, 0xb8}, resp[i:i+4])
+	}
+}
+
+func TestUDPConnectionID(t *testing.T) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := &Server{Clock: clock}
+	addr := netip.MustParseAddrPort("10.0.0.1:6881")
+	connect := binary.BigEndian.AppendUint64(nil, wire.ProtocolID)
+	connect = append(connect, 0, 0, 0, wire.ActionConnect, 1, 2, 3, 4)
+	resp := s.handleUDP(connect, addr)
+	require.Len(t, resp, 16)
+	assert.Equal(t, []byte{1, 2, 3, 4}, resp[4:8])
+
+	scrape := append(append([]byte(nil), resp[8:16]...), 0, 0, 0, wire.ActionScrape, 5, 6, 7, 8)
+	assert.Equal(t, uint32(wire.ActionScrape), binary.BigEndian.Uint32(s.handleUDP(scrape, addr)))
+	// another address cannot use the ID
+	assert.Equal(t, uint32(wire.ActionError), binary.BigEndian.Uint32(s.handleUDP(scrape, netip.MustParseAddrPort("10.0.0.2:6881"))))
+	// it stays valid into the next window only
+	clock.Advance(connectionIDWindow)
+	assert.Equal(t, uint32(wire.ActionScrape), binary.BigEndian.Uint32(s.handleUDP(scrape, addr)))
+	clock.Advance(connectionIDWindow)
+	assert.Equal(t, uint32(wire.ActionError), binary.BigEndian.Uint32(s.handleUDP(scrape, addr)))
+
+	// a connect without the protocol ID and short packets are dropped
+	assert.Nil(t, s.handleUDP(append(make([]byte, 8), connect[8:]...), addr))
+	assert.Nil(t, s.handleUDP(connect[:15], addr))
+}
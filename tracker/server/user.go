@@ -0,0 +1,83 @@
+package server
+
+import (
+	"math"
+	"strings"
+
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+)
+
+// User is the account of one passkey on a private tracker.
+type User struct {
+	Passkey    string
+	Uploaded   int64
+	Downloaded int64
+}
+
+// Ratio is uploaded over downloaded: +Inf for a user who only uploaded,
+// and 0 for one who moved nothing.
+func (u User) Ratio() float64 {
+	if u.Downloaded == 0 {
+		if u.Uploaded == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return float64(u.Uploaded) / float64(u.Downloaded)
+}
+
+// account adds the transfer req reports since p, the peer's previous
+// announce if any, to u. Counters going backwards mean the client started
+// them over.
+func (u *User) account(p *Peer, req *tracker.AnnounceRequest) {
+	up, down := req.Uploaded, req.Downloaded
+	if p != nil {
+		if up >= p.Uploaded {
+			up -= p.Uploaded
+		}
+		if down >= p.Downloaded {
+			down -= p.Downloaded
+		}
+	}
+	u.Uploaded += up
+	u.Downloaded += down
+}
+
+// AddUser registers passkey, keeping the account of one already known.
+func (s *Server) AddUser(passkey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok, err := s.store().User(passkey)
+	if err != nil || ok {
+		return err
+	}
+	return s.store().PutUser(User{Passkey: passkey})
+}
+
+// RemoveUser drops passkey, refusing its further requests.
+func (s *Server) RemoveUser(passkey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store().DeleteUser(passkey)
+}
+
+func (s *Server) User(passkey string) (User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store().User(passkey)
+}
+
+// Users returns every account sorted by passkey.
+func (s *Server) Users() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store().Users()
+}
+
+// passkey returns the path element before the last one of path, which
+// private trackers take for the passkey.
+func passkey(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	path = path[:max(strings.LastIndexByte(path, '/'), 0)]
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
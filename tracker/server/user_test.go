@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasskey(t *testing.T) {
+	for path, want := range map[string]string{
+		"/abc/announce":        "abc",
+		"/x/abc/scrape":        "abc",
+		"/announce":            "",
+		"announce":             "",
+		"/abc/announce?info=1": "abc",
+	} {
+		assert.Equal(t, want, passkey(path), path)
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	s := &Server{Private: true}
+	require.NoError(t, s.AddUser("alice"))
+	require.NoError(t, s.AddUser("bob"))
+	h := metainfo.InfoHash{1}
+	ip := netip.MustParseAddr("10.0.0.1")
+	announce := func(passkey string, id byte, up, down int64, event tracker.Event) error {
+		_, err := s.announce(&tracker.AnnounceRequest{
+			InfoHash: h, PeerID: peerID(id), Port: 1, Left: 1,
+			Uploaded: up, Downloaded: down, Event: event,
+		}, ip, passkey)
+		return err
+	}
+
+	require.NoError(t, announce("alice", 1, 0, 0, tracker.Started))
+	require.NoError(t, announce("alice", 1, 100, 400, tracker.None))
+	require.NoError(t, announce("alice", 1, 300, 500, tracker.None))
+	// a second torrent session of the same user adds up
+	require.NoError(t, announce("alice", 2, 50, 0, tracker.Started))
+	require.NoError(t, announce("alice", 1, 350, 500, tracker.Stopped))
+	// a client that restarted its counters reports them whole
+	require.NoError(t, announce("alice", 2, 10, 100, tracker.None))
+	alice, ok, err := s.User("alice")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, User{Passkey: "alice", Uploaded: 410, Downloaded: 600}, alice)
+
+	// a peer ID taken over by another user is not billed to the first
+	require.NoError(t, announce("bob", 2, 20, 150, tracker.None))
+	bob, _, err := s.User("bob")
+	require.NoError(t, err)
+	assert.Equal(t, User{Passkey: "bob", Uploaded: 20, Downloaded: 150}, bob)
+
+	assert.ErrorIs(t, announce("mallory", 3, 1<<40, 0, tracker.Started), ErrPasskey)
+	_, err = s.scrape(nil, "mallory")
+	assert.ErrorIs(t, err, ErrPasskey)
+	require.NoError(t, s.RemoveUser("bob"))
+	assert.ErrorIs(t, announce("bob", 2, 20, 150, tracker.None), ErrPasskey)
+	users, err := s.Users()
+	require.NoError(t, err)
+	assert.Equal(t, []User{alice}, users)
+}
+
+func TestAccountingAfterExpiry(t *testing.T) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := &Server{Private: true, Clock: clock, PeerTTL: time.Hour}
+	require.NoError(t, s.AddUser("alice"))
+	h := metainfo.InfoHash{1}
+	announce := func(id byte, up, down int64) {
+		_, err := s.announce(&tracker.AnnounceRequest{
+			InfoHash: h, PeerID: peerID(id), Port: 1, Left: 1, Uploaded: up, Downloaded: down,
+		}, netip.MustParseAddr("10.0.0.1"), "alice")
+		require.NoError(t, err)
+	}
+
+	announce(1, 100, 400)
+	announce(2, 10, 0)
+	clock.Advance(2 * time.Hour)
+	// peer 1 comes back after it was dropped, counting on from where it was
+	announce(1, 150, 500)
+	alice, _, err := s.User("alice")
+	require.NoError(t, err)
+	assert.Equal(t, User{Passkey: "alice", Uploaded: 160, Downloaded: 500}, alice)
+
+	// until it has been gone for too long
+	clock.Advance(prunedTTL + 2*time.Hour)
+	announce(3, 0, 0)
+	announce(2, 30, 0)
+	alice, _, err = s.User("alice")
+	require.NoError(t, err)
+	assert.Equal(t, User{Passkey: "alice", Uploaded: 190, Downloaded: 500}, alice)
+}
+
+func TestRatio(t *testing.T) {
+	assert.Equal(t, 0.0, User{}.Ratio())
+	assert.Equal(t, math.Inf(1), User{Uploaded: 1}.Ratio())
+	assert.Equal(t, 0.5, User{Uploaded: 1, Downloaded: 2}.Ratio())
+}
+
+func TestClients(t *testing.T) {
+	s := &Server{Clients: []string{"-qB", "-TR"}}
+	for id, want := range map[string]error{
+		"-qB4650-abcdefghijkl": nil,
+		"-TR3000-abcdefghijkl": nil,
+		"-UT3550-abcdefghijkl": ErrClient,
+	} {
+		_, err := s.announce(&tracker.AnnounceRequest{PeerID: tracker.PeerID([]byte(id)), Port: 1}, netip.MustParseAddr("10.0.0.1"), "")
+		assert.ErrorIs(t, err, want, id)
+	}
+}
+
+func TestPrivateTracker(t *testing.T) {
+	s := &Server{Private: true}
+	require.NoError(t, s.AddUser("k1"))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	udp := serveUDP(t, s, "udp4", "127.0.0.1:0")
+	ctx := context.Background()
+
+	req := testRequest(1, 6881)
+	req.Uploaded = 1000
+	for _, c := range []struct {
+		client   tracker.Client
+		announce string
+	}{
+		{&tracker.HTTPClient{}, ts.URL + "/k1/announce"},
+		{&tracker.UDPClient{}, udp + "/k1/announce"},
+	} {
+		_, err := c.client.Announce(ctx, c.announce, req)
+		require.NoError(t, err, c.announce)
+		req.Uploaded += 1000
+	}
+	u, _, err := s.User("k1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2000), u.Uploaded)
+
+	// rejected users get a failure reason
+	for _, c := range []struct {
+		client   tracker.Client
+		announce string
+	}{
+		{&tracker.HTTPClient{}, ts.URL + "/k2/announce"},
+		{&tracker.HTTPClient{}, ts.URL + "/announce"},
+		{&tracker.UDPClient{}, udp + "/k2/announce"},
+	} {
+		_, err := c.client.Announce(ctx, c.announce, req)
+		var fe *tracker.FailureError
+		require.ErrorAs(t, err, &fe, c.announce)
+		assert.Equal(t, ErrPasskey.Error(), fe.Reason)
+	}
+
+	_, err = (&tracker.HTTPClient{}).Scrape(ctx, ts.URL+"/k1/announce", []metainfo.InfoHash{req.InfoHash})
+	require.NoError(t, err)
+	_, err = (&tracker.HTTPClient{}).Scrape(ctx, ts.URL+"/k2/announce", []metainfo.InfoHash{req.InfoHash})
+	assert.Error(t, err)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/websocket"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/webtorrent"
+)
+
+// wsPeerKey names a WebSocket peer in a swarm.
+type wsPeerKey struct {
+	hash metainfo.InfoHash
+	id   tracker.PeerID
+}
+
+// wsSend is a message waiting to be written once s.mu is released.
+type wsSend struct {
+	conn *websocket.Conn
+	m    *webtorrent.Message
+}
+
+// serveWebSocket speaks the WebTorrent protocol on an upgraded connection.
+// Its peers form swarms of their own, which only list peers while their
+// connection is open, and get the offers of other peers relayed instead of
+// addresses.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	passkey := passkey(r.URL.Path)
+	announced := make(map[wsPeerKey]bool)
+	defer s.wsDisconnect(conn, announced)
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var m webtorrent.Message
+		if json.Unmarshal(data, &m) != nil {
+			return
+		}
+		var sends []wsSend
+		switch m.Action {
+		case "announce":
+			sends = s.wsAnnounce(conn, &m, passkey, announced)
+		case "scrape":
+			sends = []wsSend{{conn, s.wsScrape(&m, passkey)}}
+		default:
+			sends = []wsSend{{conn, wsFailure(&m, fmt.Errorf("%w action", ErrParam))}}
+		}
+		for _, send := range sends {
+			data, err := json.Marshal(send.m)
+			if err != nil {
+				continue
+			}
+			// a peer that fails a write, as when it stops reading, is
+			// closed, and its own goroutine then drops its peers
+			err = send.conn.WriteMessage(data)
+			if err != nil && send.conn == conn {
+				return
+			}
+		}
+	}
+}
+
+// wsAnnounce handles an announce, or an answer to relay, from conn and
+// returns the messages to send.
+func (s *Server) wsAnnounce(conn *websocket.Conn, m *webtorrent.Message, passkey string, announced map[wsPeerKey]bool) []wsSend {
+	if len(m.InfoHash) != 1 || len(m.InfoHash[0]) != len(metainfo.InfoHash{}) {
+		return []wsSend{{conn, wsFailure(m, ErrInfoHash)}}
+	}
+	if len(m.PeerID) != len(tracker.PeerID{}) {
+		return []wsSend{{conn, wsFailure(m, ErrPeerID)}}
+	}
+	h := metainfo.InfoHash([]byte(m.InfoHash[0]))
+	id := tracker.PeerID([]byte(m.PeerID))
+	if m.Answer != nil {
+		return s.wsAnswer(m, h, id)
+	}
+	req := &tracker.AnnounceRequest{InfoHash: h, PeerID: id, Left: 1}
+	switch m.Event {
+	case "", "update":
+	case "started":
+		req.Event = tracker.Started
+	case "completed":
+		req.Event = tracker.Completed
+	case "stopped":
+		req.Event = tracker.Stopped
+	default:
+		return []wsSend{{conn, wsFailure(m, fmt.Errorf("%w event", ErrParam))}}
+	}
+	if m.Left != nil {
+		req.Left = *m.Left
+	}
+	if m.Uploaded != nil {
+		req.Uploaded = *m.Uploaded
+	}
+	if m.Downloaded != nil {
+		req.Downloaded = *m.Downloaded
+	}
+	numWant := defaultNumWant
+	if m.NumWant != nil && *m.NumWant > 0 {
+		numWant = *m.NumWant
+	}
+	maxPeers := s.MaxPeers
+	if maxPeers <= 0 {
+		maxPeers = defaultMaxPeers
+	}
+	numWant = min(numWant, maxPeers, len(m.Offers))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seeders, leechers, err := s.wsRecord(conn, req, passkey)
+	if err != nil {
+		return []wsSend{{conn, wsFailure(m, err)}}
+	}
+	k := wsPeerKey{h, id}
+	announced[k] = req.Event != tracker.Stopped
+	reply := &webtorrent.Message{
+		Action:     "announce",
+		InfoHash:   m.InfoHash,
+		Interval:   int(s.interval().Seconds()),
+		Complete:   &seeders,
+		Incomplete: &leechers,
+	}
+	sends := []wsSend{{conn, reply}}
+	if req.Event == tracker.Stopped {
+		return sends
+	}
+	sw, _ := s.wsSwarms.Swarm(h)
+	for other, p := range sw.Peers {
+		if len(sends)-1 == numWant {
+			break
+		}
+		// seeds have no use for other seeds
+		if other == id || req.Left == 0 && p.Left == 0 {
+			continue
+		}
+		o := m.Offers[len(sends)-1]
+		sends = append(sends, wsSend{s.wsConns[wsPeerKey{h, other}], &webtorrent.Message{
+			Action:   "announce",
+			InfoHash: m.InfoHash,
+			PeerID:   m.PeerID,
+			OfferID:  o.OfferID,
+			Offer:    o.Offer,
+		}})
+	}
+	return sends
+}
+
+// wsRecord applies the checks and accounting of announce to req from conn
+// and records the peer; s.mu must be held.
+func (s *Server) wsRecord(conn *websocket.Conn, req *tracker.AnnounceRequest, passkey string) (seeders, leechers int, err error) {
+	if !s.clientAllowed(req.PeerID) {
+		return 0, 0, ErrClient
+	}
+	if !s.allowed(req.InfoHash) {
+		return 0, 0, ErrNotAllowed
+	}
+	st := s.store()
+	var user User
+	if s.Private {
+		var ok bool
+		user, ok, err = st.User(passkey)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !ok {
+			return 0, 0, ErrPasskey
+		}
+	}
+	sw, _ := s.wsSwarms.Swarm(req.InfoHash)
+	var p Peer
+	var ok bool
+	if sw != nil {
+		p, ok = sw.Peers[req.PeerID]
+	}
+	if ok && p.Passkey != passkey {
+		p.Passkey, p.Uploaded, p.Downloaded = passkey, 0, 0
+	}
+	if s.Private {
+		var last *Peer
+		if ok {
+			last = &p
+		}
+		user.account(last, req)
+		err = st.PutUser(user)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	k := wsPeerKey{req.InfoHash, req.PeerID}
+	if req.Event == tracker.Stopped {
+		s.wsSwarms.DeletePeer(req.InfoHash, req.PeerID)
+		delete(s.wsConns, k)
+	} else {
+		if !ok {
+			p = Peer{Passkey: passkey}
+		}
+		download := false
+		if !p.Completed && req.Left == 0 {
+			p.Completed = true
+			download = req.Event == tracker.Completed || ok && p.Left > 0
+		}
+		p.Left = req.Left
+		p.Uploaded = req.Uploaded
+		p.Downloaded = req.Downloaded
+		s.wsSwarms.PutPeer(req.InfoHash, req.PeerID, p)
+		if download {
+			s.wsSwarms.AddDownload(req.InfoHash)
+		}
+		if s.wsConns == nil {
+			s.wsConns = make(map[wsPeerKey]*websocket.Conn)
+		}
+		s.wsConns[k] = conn
+	}
+	sw, _ = s.wsSwarms.Swarm(req.InfoHash)
+	seeders, leechers = sw.counts()
+	return seeders, leechers, nil
+}
+
+// wsAnswer relays the answer of peer id to the peer that made the offer.
+// Nothing goes back to the sender, not even when the target left.
+func (s *Server) wsAnswer(m *webtorrent.Message, h metainfo.InfoHash, id tracker.PeerID) []wsSend {
+	if len(m.ToPeerID) != len(tracker.PeerID{}) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	to := s.wsConns[wsPeerKey{h, tracker.PeerID([]byte(m.ToPeerID))}]
+	if to == nil || s.wsConns[wsPeerKey{h, id}] == nil {
+		return nil
+	}
+	return []wsSend{{to, &webtorrent.Message{
+		Action:   "announce",
+		InfoHash: m.InfoHash,
+		PeerID:   m.PeerID,
+		OfferID:  m.OfferID,
+		Answer:   m.Answer,
+	}}}
+}
+
+// wsScrape returns the stats of the given WebSocket swarms, or of all
+// when m names none.
+func (s *Server) wsScrape(m *webtorrent.Message, passkey string) *webtorrent.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Private {
+		_, ok, err := s.store().User(passkey)
+		if err == nil && !ok {
+			err = ErrPasskey
+		}
+		if err != nil {
+			return wsFailure(m, err)
+		}
+	}
+	var hashes []metainfo.InfoHash
+	for _, b := range m.InfoHash {
+		if len(b) != len(metainfo.InfoHash{}) {
+			return wsFailure(m, ErrInfoHash)
+		}
+		hashes = append(hashes, metainfo.InfoHash([]byte(b)))
+	}
+	if len(hashes) == 0 {
+		hashes, _ = s.wsSwarms.InfoHashes()
+	}
+	reply := &webtorrent.Message{Action: "scrape", Files: make(map[string]webtorrent.File, len(hashes))}
+	for _, h := range hashes {
+		var f webtorrent.File
+		if s.allowed(h) {
+			sw, _ := s.wsSwarms.Swarm(h)
+			f.Complete, f.Incomplete = sw.counts()
+			if sw != nil {
+				f.Downloaded = sw.Downloaded
+			}
+		}
+		reply.Files[webtorrent.Latin1(string(h[:]))] = f
+	}
+	return reply
+}
+
+// wsDisconnect removes the peers conn announced that are still listed on
+// it.
+func (s *Server) wsDisconnect(conn *websocket.Conn, announced map[wsPeerKey]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, listed := range announced {
+		if listed && s.wsConns[k] == conn {
+			s.wsSwarms.DeletePeer(k.hash, k.id)
+			delete(s.wsConns, k)
+		}
+	}
+}
+
+// wsFailure returns the reply refusing m for err.
+func wsFailure(m *webtorrent.Message, err error) *webtorrent.Message {
+	return &webtorrent.Message{Action: m.Action, InfoHash: m.InfoHash, FailureReason: err.Error()}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wsPeer is a WebTorrent peer with its own connection to the tracker.
+type wsPeer struct {
+	*tracker.WebSocketClient
+	signals chan *tracker.Signal
+}
+
+func newWSPeer(t *testing.T) *wsPeer {
+	p := &wsPeer{signals: make(chan *tracker.Signal, 8)}
+	p.WebSocketClient = &tracker.WebSocketClient{OnSignal: func(_ string, s *tracker.Signal) { p.signals <- s }}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func (p *wsPeer) signal(t *testing.T) *tracker.Signal {
+	t.Helper()
+	select {
+	case s := <-p.signals:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("no signal relayed")
+		return nil
+	}
+}
+
+func wsURL(ts *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + path
+}
+
+func TestWebSocketSwarm(t *testing.T) {
+	s := &Server{Interval: 2 * time.Minute}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	announce := wsURL(ts, "/announce")
+	ctx := context.Background()
+	h := metainfo.InfoHash{0xaa}
+	a, b := newWSPeer(t), newWSPeer(t)
+
+	reqA := &tracker.AnnounceRequest{InfoHash: h, PeerID: peerID(1), Left: 0, Event: tracker.Started}
+	res, err := a.Announce(ctx, announce, reqA)
+	require.NoError(t, err)
+	assert.Equal(t, &tracker.AnnounceResponse{Interval: 2 * time.Minute, Seeders: 1}, res)
+
+	// b's offers go to a, whose answer goes back to b
+	reqB := &tracker.AnnounceRequest{InfoHash: h, PeerID: peerID(2), Left: 10, Event: tracker.Started}
+	res, err = b.AnnounceOffers(ctx, announce, reqB, []tracker.Offer{
+		{ID: "offer-1", Offer: json.RawMessage(`{"type":"offer","sdp":"1"}`)},
+		{ID: "offer-2", Offer: json.RawMessage(`{"type":"offer","sdp":"2"}`)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Seeders)
+	assert.Equal(t, 1, res.Leechers)
+	offer := a.signal(t)
+	assert.Equal(t, &tracker.Signal{
+		InfoHash: h,
+		PeerID:   peerID(2),
+		OfferID:  "offer-1",
+		Offer:    json.RawMessage(`{"type":"offer","sdp":"1"}`),
+	}, offer)
+	require.NoError(t, a.Answer(ctx, announce, reqA.PeerID, offer, json.RawMessage(`{"type":"answer","sdp":"a"}`)))
+	assert.Equal(t, &tracker.Signal{
+		InfoHash: h,
+		PeerID:   peerID(1),
+		OfferID:  "offer-1",
+		Answer:   json.RawMessage(`{"type":"answer","sdp":"a"}`),
+	}, b.signal(t))
+
+	// the WebSocket swarms are apart from the HTTP and UDP ones
+	reqB.Left, reqB.Event = 0, tracker.Completed
+	_, err = b.Announce(ctx, announce, reqB)
+	require.NoError(t, err)
+	scrape, err := a.Scrape(ctx, announce, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[metainfo.InfoHash]tracker.ScrapeStats{h: {Seeders: 2, Downloaded: 1}}, scrape.Files)
+	assert.Empty(t, scrapeAll(t, s))
+
+	// peers leave when they stop or hang up
+	reqB.Event = tracker.Stopped
+	res, err = b.Announce(ctx, announce, reqB)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Seeders)
+	require.NoError(t, a.Close())
+	require.Eventually(t, func() bool {
+		scrape, err := b.Scrape(ctx, announce, []metainfo.InfoHash{h})
+		return err == nil && scrape.Files[h] == tracker.ScrapeStats{Downloaded: 1}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketRefused(t *testing.T) {
+	h := metainfo.InfoHash{0xaa}
+	s := &Server{Private: true, Blacklist: map[metainfo.InfoHash]bool{{0xbb}: true}}
+	require.NoError(t, s.AddUser("alice"))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	ctx := context.Background()
+	p := newWSPeer(t)
+	req := &tracker.AnnounceRequest{InfoHash: h, PeerID: peerID(1), Left: 10, Uploaded: 30, Event: tracker.Started}
+
+	var fe *tracker.FailureError
+	_, err := p.Announce(ctx, wsURL(ts, "/bob/announce"), req)
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, ErrPasskey.Error(), fe.Reason)
+	_, err = p.Scrape(ctx, wsURL(ts, "/bob/announce"), nil)
+	assert.ErrorAs(t, err, &fe)
+
+	_, err = p.Announce(ctx, wsURL(ts, "/alice/announce"), &tracker.AnnounceRequest{InfoHash: metainfo.InfoHash{0xbb}, PeerID: peerID(1)})
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, ErrNotAllowed.Error(), fe.Reason)
+
+	_, err = p.Announce(ctx, wsURL(ts, "/alice/announce"), req)
+	require.NoError(t, err)
+	alice, _, err := s.User("alice")
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), alice.Uploaded)
+}
@@ -0,0 +1,279 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksPasswordAuth = 2
+	socksNoMethod     = 0xff
+	socksUDPAssociate = 3
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+)
+
+var socksReplies = []string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "ttl expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// dialSOCKS5UDP asks the SOCKS5 proxy at proxy to relay datagrams to
+// address, which it resolves itself, and returns a connection through the
+// relay. The association lasts until the connection is closed.
+func dialSOCKS5UDP(ctx context.Context, proxy *url.URL, address string) (net.Conn, error) {
+	if proxy.Scheme != "socks5" && proxy.Scheme != "socks5h" {
+		return nil, fmt.Errorf("%w: udp trackers need a socks5 proxy, not %q", ErrProxy, proxy.Scheme)
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker port %q", portStr)
+	}
+	target, err := appendSOCKSAddr(nil, host, uint16(port))
+	if err != nil {
+		return nil, err
+	}
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), "1080")
+	}
+
+	var d net.Dialer
+	ctrl, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	// cancellation interrupts the handshake
+	stop := context.AfterFunc(ctx, func() {
+		ctrl.SetDeadline(time.Unix(1, 0))
+	})
+	// the client sends from an address it does not know yet
+	relay, err := socksHandshake(ctrl, proxy.User, socksUDPAssociate, []byte{socksIPv4, 0, 0, 0, 0, 0, 0})
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	ctrl.SetDeadline(time.Time{})
+	if !relay.Addr().IsValid() || relay.Addr().IsUnspecified() {
+		// the relay is at the address of the proxy itself
+		proxyIP, _ := netip.ParseAddrPort(ctrl.RemoteAddr().String())
+		relay = netip.AddrPortFrom(proxyIP.Addr(), relay.Port())
+	}
+	conn, err := d.DialContext(ctx, "udp", relay.String())
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	c := &socksUDPConn{Conn: conn, ctrl: ctrl, header: append([]byte{0, 0, 0}, target...)}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		c.remote = net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port)))
+	}
+	return c, nil
+}
+
+// socksHandshake authenticates on conn as user, when given, and sends the
+// command cmd for the address addr, encoded as in requests. It returns
+// the bound address of the reply, which is invalid when given by name.
+func socksHandshake(conn net.Conn, user *url.Userinfo, cmd byte, addr []byte) (netip.AddrPort, error) {
+	greeting := []byte{socksVersion, 1, socksNoAuth}
+	if user != nil {
+		greeting = []byte{socksVersion, 2, socksNoAuth, socksPasswordAuth}
+	}
+	_, err := conn.Write(greeting)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	var b [4]byte
+	_, err = io.ReadFull(conn, b[:2])
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if b[0] != socksVersion {
+		return netip.AddrPort{}, fmt.Errorf("%w: not a socks5 proxy", ErrProxy)
+	}
+	switch b[1] {
+	case socksNoAuth:
+	case socksPasswordAuth:
+		if user == nil {
+			return netip.AddrPort{}, fmt.Errorf("%w: authentication required", ErrProxy)
+		}
+		name := user.Username()
+		password, _ := user.Password()
+		if len(name) > 255 || len(password) > 255 {
+			return netip.AddrPort{}, fmt.Errorf("%w: user name or password too long", ErrProxy)
+		}
+		auth := []byte{1, byte(len(name))}
+		auth = append(auth, name...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		_, err = conn.Write(auth)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		_, err = io.ReadFull(conn, b[:2])
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		if b[1] != 0 {
+			return netip.AddrPort{}, fmt.Errorf("%w: authentication refused", ErrProxy)
+		}
+	default:
+		return netip.AddrPort{}, fmt.Errorf("%w: no acceptable authentication method", ErrProxy)
+	}
+
+	req := append([]byte{socksVersion, cmd, 0}, addr...)
+	_, err = conn.Write(req)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	_, err = io.ReadFull(conn, b[:4])
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if b[1] != 0 {
+		reason := "unknown error"
+		if int(b[1]) < len(socksReplies) {
+			reason = socksReplies[b[1]]
+		}
+		return netip.AddrPort{}, fmt.Errorf("%w: %s", ErrProxy, reason)
+	}
+	var bound []byte
+	switch b[3] {
+	case socksIPv4:
+		bound = make([]byte, 4+2)
+	case socksIPv6:
+		bound = make([]byte, 16+2)
+	case socksDomain:
+		_, err = io.ReadFull(conn, b[:1])
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		bound = make([]byte, int(b[0])+2)
+	default:
+		return netip.AddrPort{}, fmt.Errorf("%w: bad address type %d", ErrProxy, b[3])
+	}
+	_, err = io.ReadFull(conn, bound)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	port := binary.BigEndian.Uint16(bound[len(bound)-2:])
+	if b[3] == socksDomain {
+		return netip.AddrPortFrom(netip.Addr{}, port), nil
+	}
+	ip, _ := netip.AddrFromSlice(bound[:len(bound)-2])
+	return netip.AddrPortFrom(ip, port), nil
+}
+
+// appendSOCKSAddr appends host and port as a SOCKS5 address: IP addresses
+// as such and anything else as a name.
+func appendSOCKSAddr(b []byte, host string, port uint16) ([]byte, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			b = append(b, socksIPv4)
+		} else {
+			b = append(b, socksIPv6)
+		}
+		b = append(b, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("%w: host name too long", ErrProxy)
+		}
+		b = append(b, socksDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, port), nil
+}
+
+// socksUDPConn sends datagrams to one address through a SOCKS5 relay.
+type socksUDPConn struct {
+	net.Conn // to the relay
+	ctrl     net.Conn
+	header   []byte // of every datagram: reserved, no fragment and the target
+
+	mu     sync.Mutex
+	remote *net.UDPAddr // the target as the relay last named it
+}
+
+func (c *socksUDPConn) Write(b []byte) (int, error) {
+	pkt := append(c.header[:len(c.header):len(c.header)], b...)
+	_, err := c.Conn.Write(pkt)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read returns the next whole datagram relayed; fragments are dropped.
+func (c *socksUDPConn) Read(b []byte) (int, error) {
+	buf := make([]byte, len(b)+4+256+2)
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		pkt := buf[:n]
+		if n < 4 || pkt[2] != 0 {
+			continue
+		}
+		var size int
+		switch pkt[3] {
+		case socksIPv4:
+			size = 4
+		case socksIPv6:
+			size = 16
+		default:
+			continue
+		}
+		if n < 4+size+2 {
+			continue
+		}
+		ip, _ := netip.AddrFromSlice(pkt[4 : 4+size])
+		port := binary.BigEndian.Uint16(pkt[4+size:])
+		c.mu.Lock()
+		c.remote = net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, port))
+		c.mu.Unlock()
+		return copy(b, pkt[4+size+2:]), nil
+	}
+}
+
+// RemoteAddr returns the address of the target once known, or else that
+// of the relay.
+func (c *socksUDPConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *socksUDPConn) Close() error {
+	c.ctrl.Close()
+	return c.Conn.Close()
+}
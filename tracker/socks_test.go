@@ -0,0 +1,248 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socksStandIn is a SOCKS5 proxy on localhost serving CONNECT and UDP
+// ASSOCIATE, which notes the command and target of each request and of
+// each datagram relayed.
+type socksStandIn struct {
+	ln             net.Listener
+	user, password string // required when set
+
+	mu   sync.Mutex
+	seen []string
+}
+
+func newSOCKSStandIn(t *testing.T, user, password string) *socksStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &socksStandIn{ln: ln, user: user, password: password}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socksStandIn) url(user, password string) *url.URL {
+	u := &url.URL{Scheme: "socks5", Host: s.ln.Addr().String()}
+	if user != "" {
+		u.User = url.UserPassword(user, password)
+	}
+	return u
+}
+
+func (s *socksStandIn) note(format string, args ...any) {
+	s.mu.Lock()
+	s.seen = append(s.seen, fmt.Sprintf(format, args...))
+	s.mu.Unlock()
+}
+
+func (s *socksStandIn) noted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen
+}
+
+func readSOCKSAddr(r io.Reader) (string, error) {
+	var b [256]byte
+	_, err := io.ReadFull(r, b[:1])
+	if err != nil {
+		return "", err
+	}
+	var host string
+	switch b[0] {
+	case socksIPv4, socksIPv6:
+		size := map[byte]int{socksIPv4: 4, socksIPv6: 16}[b[0]]
+		_, err = io.ReadFull(r, b[:size])
+		ip, _ := netip.AddrFromSlice(b[:size])
+		host = ip.String()
+	case socksDomain:
+		_, err = io.ReadFull(r, b[:1])
+		if err == nil {
+			n := int(b[0])
+			_, err = io.ReadFull(r, b[:n])
+			host = string(b[:n])
+		}
+	default:
+		return "", fmt.Errorf("address type %d", b[0])
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = io.ReadFull(r, b[:2])
+	return net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(b[:]))), err
+}
+
+func (s *socksStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	var b [256]byte
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return
+	}
+	methods := make([]byte, b[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	if s.user == "" {
+		conn.Write([]byte{socksVersion, socksNoAuth})
+	} else {
+		if !bytes.Contains(methods, []byte{socksPasswordAuth}) {
+			conn.Write([]byte{socksVersion, socksNoMethod})
+			return
+		}
+		conn.Write([]byte{socksVersion, socksPasswordAuth})
+		var creds [2]string
+		io.ReadFull(conn, b[:1])
+		for i := range creds {
+			io.ReadFull(conn, b[:1])
+			n := int(b[0])
+			io.ReadFull(conn, b[:n])
+			creds[i] = string(b[:n])
+		}
+		if creds != [2]string{s.user, s.password} {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+	if _, err := io.ReadFull(conn, b[:3]); err != nil {
+		return
+	}
+	cmd := b[1]
+	target, err := readSOCKSAddr(conn)
+	if err != nil {
+		return
+	}
+	s.note("%d %s", cmd, target)
+	reply := func(rep byte, bound netip.AddrPort) {
+		r := []byte{socksVersion, rep, 0}
+		r, _ = appendSOCKSAddr(r, bound.Addr().String(), bound.Port())
+		conn.Write(r)
+	}
+	switch cmd {
+	case 1: // CONNECT
+		out, err := net.Dial("tcp", target)
+		if err != nil {
+			reply(5, netip.AddrPort{})
+			return
+		}
+		defer out.Close()
+		reply(0, netip.MustParseAddrPort(out.LocalAddr().String()))
+		go io.Copy(out, conn)
+		io.Copy(conn, out)
+	case socksUDPAssociate:
+		relay, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			reply(1, netip.AddrPort{})
+			return
+		}
+		defer relay.Close()
+		// the relay is given as unspecified, meaning the proxy's address
+		reply(0, netip.AddrPortFrom(netip.IPv4Unspecified(), netip.MustParseAddrPort(relay.LocalAddr().String()).Port()))
+		go s.relay(relay)
+		io.Copy(io.Discard, conn) // the association ends with the connection
+	default:
+		reply(7, netip.AddrPort{})
+	}
+}
+
+func (s *socksStandIn) relay(relay net.PacketConn) {
+	buf := make([]byte, 2048)
+	var client net.Addr
+	for {
+		n, from, err := relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || from.String() == client.String() {
+			client = from
+			r := bytes.NewReader(buf[3:n])
+			target, err := readSOCKSAddr(r)
+			if err != nil {
+				continue
+			}
+			addr, err := net.ResolveUDPAddr("udp4", target)
+			if err != nil {
+				continue
+			}
+			s.note("datagram %s", target)
+			payload, _ := io.ReadAll(r)
+			relay.WriteTo(payload, addr)
+			continue
+		}
+		src := netip.MustParseAddrPort(from.String())
+		pkt, _ := appendSOCKSAddr([]byte{0, 0, 0}, src.Addr().String(), src.Port())
+		relay.WriteTo(append(pkt, buf[:n]...), client)
+	}
+}
+
+func TestSOCKS5UDP(t *testing.T) {
+	s := newUDPStandIn(t, "udp4", "127.0.0.1:0")
+	peer := netip.MustParseAddrPort("10.1.2.3:6881")
+	s.setHandle(func(req []byte, n int) [][]byte {
+		return [][]byte{announceReply(req, peer)}
+	})
+	proxy := newSOCKSStandIn(t, "alice", "secret")
+	ctx := context.Background()
+	_, port, _ := net.SplitHostPort(s.conn.LocalAddr().String())
+	// the proxy resolves the tracker's name
+	announce := "udp://localhost:" + port + "/announce"
+
+	client := &UDPClient{Proxy: proxy.url("alice", "secret")}
+	req := testRequest()
+	req.IP = netip.MustParseAddr("192.0.2.7")
+	res, err := client.Announce(ctx, announce, req)
+	require.NoError(t, err)
+	assert.Equal(t, []Peer{{Addr: peer}}, res.Peers)
+	_, requests := s.seen()
+	assert.Equal(t, []byte{192, 0, 2, 7}, requests[0][84:88])
+	// the connect and the announce share one association
+	assert.Equal(t, []string{
+		"3 0.0.0.0:0",
+		"datagram localhost:" + port,
+		"datagram localhost:" + port,
+	}, proxy.noted())
+
+	_, err = (&UDPClient{Proxy: proxy.url("alice", "wrong")}).Announce(ctx, announce, req)
+	assert.ErrorIs(t, err, ErrProxy)
+	_, err = (&UDPClient{Proxy: proxy.url("", "")}).Announce(ctx, announce, req)
+	assert.ErrorIs(t, err, ErrProxy)
+	_, err = (&UDPClient{Proxy: &url.URL{Scheme: "http", Host: proxy.ln.Addr().String()}}).Announce(ctx, announce, req)
+	assert.ErrorIs(t, err, ErrProxy)
+}
+
+func TestSOCKS5HTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:completei4e8:intervali60e5:peers0:e"))
+	}))
+	defer ts.Close()
+	proxy := newSOCKSStandIn(t, "", "")
+
+	res, err := (&HTTPClient{Proxy: proxy.url("", "")}).Announce(context.Background(), ts.URL+"/announce", testRequest())
+	require.NoError(t, err)
+	assert.Equal(t, 4, res.Seeders)
+	assert.Equal(t, []string{"1 " + ts.Listener.Addr().String()}, proxy.noted())
+}
@@ -0,0 +1,98 @@
+// Package tracker implements the client side of BitTorrent trackers.
+package tracker
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+)
+
+type PeerID [20]byte
+
+// Event is the event of an announce; regular updates have none.
+type Event int
+
+const (
+	None Event = iota
+	Completed
+	Started
+	Stopped
+)
+
+func (e Event) String() string {
+	switch e {
+	case Completed:
+		return "completed"
+	case Started:
+		return "started"
+	case Stopped:
+		return "stopped"
+	}
+	return ""
+}
+
+// AnnounceRequest holds what a client tells a tracker about its download.
+type AnnounceRequest struct {
+	InfoHash   metainfo.InfoHash
+	PeerID     PeerID
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	NumWant    int    // peers wanted; 0 leaves it to the tracker
+	Key        uint32 // identifies the client across IP changes; sent when non-zero
+	TrackerID  string // tracker id from an earlier response
+	// IP, when valid, is announced in place of the address requests come
+	// from. UDP trackers only take IPv4 addresses.
+	IP netip.Addr
+}
+
+// AnnounceResponse is a tracker's answer to an announce.
+type AnnounceResponse struct {
+	Interval       time.Duration
+	MinInterval    time.Duration // 0 when not given
+	TrackerID      string
+	Seeders        int // complete
+	Leechers       int // incomplete
+	Peers          []Peer
+	WarningMessage string
+}
+
+// ScrapeResponse holds the stats of the swarms a scrape asked about.
+type ScrapeResponse struct {
+	Files map[metainfo.InfoHash]ScrapeStats
+	// MinRequestInterval is how long to wait before scraping again, from
+	// flags.min_request_interval; 0 when not given.
+	MinRequestInterval time.Duration
+}
+
+type ScrapeStats struct {
+	Seeders    int    // complete
+	Leechers   int    // incomplete
+	Downloaded int    // completed downloads so far
+	Name       string // only sent by some HTTP trackers
+}
+
+type Peer struct {
+	Addr netip.AddrPort
+	ID   string // only sent in non-compact dictionary peer lists
+}
+
+// parseCompactPeers decodes the compact peer format of BEP 23 and BEP 7:
+// addresses of size bytes followed by a big-endian port.
+func parseCompactPeers(b []byte, size int) ([]Peer, error) {
+	n := size + 2
+	if len(b)%n != 0 {
+		return nil, ErrCompactPeers
+	}
+	peers := make([]Peer, 0, len(b)/n)
+	for ; len(b) > 0; b = b[n:] {
+		addr, _ := netip.AddrFromSlice(b[:size])
+		port := binary.BigEndian.Uint16(b[size:n])
+		peers = append(peers, Peer{Addr: netip.AddrPortFrom(addr, port)})
+	}
+	return peers, nil
+}
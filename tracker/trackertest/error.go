@@ -0,0 +1,8 @@
+package trackertest
+
+import "errors"
+
+var (
+	ErrNoExchange = errors.New("no recorded tracker exchange")
+	ErrFixture    = errors.New("invalid tracker fixture")
+)
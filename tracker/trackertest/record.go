@@ -0,0 +1,362 @@
+package trackertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+// Exchange is one request to a tracker with its response, as kept in
+// fixture files.
+type Exchange struct {
+	// Addr is the address dialed for UDP, host and port.
+	Addr string `bencode:"addr,omitempty"`
+	// Location is the redirect target of an HTTP response.
+	Location string `bencode:"location,omitempty"`
+	Network  string `bencode:"network"` // "http" or "udp"
+	// Remote is the address a UDP tracker was reached at, which tells
+	// the address family of its peers.
+	Remote string `bencode:"remote,omitempty"`
+	// Request is the URL of an HTTP request, or a UDP packet.
+	Request  string `bencode:"request"`
+	Response string `bencode:"response"`
+	Status   int    `bencode:"status,omitempty"` // of an HTTP response
+}
+
+type fixture struct {
+	Exchanges []Exchange `bencode:"exchanges"`
+}
+
+// Recorder records the exchanges of the clients it hands out with real
+// trackers. It is safe for concurrent use.
+type Recorder struct {
+	Transport   http.RoundTripper // nil uses http.DefaultTransport
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// HTTPClient returns an HTTP tracker client recording through r.
+func (r *Recorder) HTTPClient() *tracker.HTTPClient {
+	return &tracker.HTTPClient{Client: &http.Client{Transport: r}}
+}
+
+// UDPClient returns a UDP tracker client recording through r.
+func (r *Recorder) UDPClient() *tracker.UDPClient {
+	return &tracker.UDPClient{DialContext: r.dial}
+}
+
+// Exchanges returns what was recorded so far, in order.
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.exchanges)
+}
+
+// Save writes what was recorded so far to the fixture file at path.
+func (r *Recorder) Save(path string) error {
+	b, err := bencode.AppendBencode(nil, fixture{Exchanges: r.Exchanges()})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+func (r *Recorder) add(e Exchange) {
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, e)
+	r.mu.Unlock()
+}
+
+// RoundTrip sends req with the transport and records it with its
+// response.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	r.add(Exchange{
+		Network:  "http",
+		Request:  req.URL.String(),
+		Response: string(body),
+		Status:   resp.StatusCode,
+		Location: resp.Header.Get("Location"),
+	})
+	return resp, nil
+}
+
+func (r *Recorder) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := r.DialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &recordConn{Conn: conn, r: r, addr: address}, nil
+}
+
+// recordConn records every response read with the last request written
+// that has its transaction ID.
+type recordConn struct {
+	net.Conn
+	r    *Recorder
+	addr string
+
+	mu   sync.Mutex
+	sent [][]byte
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.sent = append(c.sent, append([]byte(nil), b...))
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n < 8 {
+		return n, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.sent) - 1; i >= 0; i-- {
+		req := c.sent[i]
+		if len(req) >= 16 && bytes.Equal(req[12:16], b[4:8]) {
+			c.r.add(Exchange{
+				Network:  "udp",
+				Addr:     c.addr,
+				Remote:   c.RemoteAddr().String(),
+				Request:  string(req),
+				Response: string(b[:n]),
+			})
+			break
+		}
+	}
+	return n, err
+}
+
+// Replayer answers the requests of the clients it hands out from recorded
+// exchanges, without any network. Each exchange answers once, in the
+// order recorded among those matching; a request none matches fails with
+// ErrNoExchange. UDP requests match whatever their connection and
+// transaction IDs. It is safe for concurrent use.
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	used      []bool
+}
+
+// NewReplayer returns a Replayer of exchanges.
+func NewReplayer(exchanges []Exchange) *Replayer {
+	return &Replayer{exchanges: exchanges, used: make([]bool, len(exchanges))}
+}
+
+// Load returns a Replayer of the fixture file at path.
+func Load(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var fx fixture
+	err = bencode.Unmarshal(f, &fx)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrFixture, path, err)
+	}
+	return NewReplayer(fx.Exchanges), nil
+}
+
+// HTTPClient returns an HTTP tracker client replaying from r.
+func (r *Replayer) HTTPClient() *tracker.HTTPClient {
+	return &tracker.HTTPClient{Client: &http.Client{Transport: r}}
+}
+
+// UDPClient returns a UDP tracker client replaying from r.
+func (r *Replayer) UDPClient() *tracker.UDPClient {
+	return &tracker.UDPClient{DialContext: r.dial}
+}
+
+// take marks the first unused exchange match accepts as used and returns
+// it.
+func (r *Replayer) take(match func(e *Exchange) bool) (Exchange, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.exchanges {
+		if !r.used[i] && match(&r.exchanges[i]) {
+			r.used[i] = true
+			return r.exchanges[i], true
+		}
+	}
+	return Exchange{}, false
+}
+
+// RoundTrip answers req with the recorded response to its URL.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+	e, ok := r.take(func(e *Exchange) bool {
+		return e.Network == "http" && e.Request == url
+	})
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrNoExchange, url)
+	}
+	resp := &http.Response{
+		Status:     http.StatusText(e.Status),
+		StatusCode: e.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(e.Response)),
+		Request:    req,
+	}
+	if e.Location != "" {
+		resp.Header.Set("Location", e.Location)
+	}
+	return resp, nil
+}
+
+func (r *Replayer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	r.mu.Lock()
+	i := slices.IndexFunc(r.exchanges, func(e Exchange) bool {
+		return e.Network == "udp" && e.Addr == address
+	})
+	r.mu.Unlock()
+	if i < 0 {
+		return nil, fmt.Errorf("%w for %s", ErrNoExchange, address)
+	}
+	remote, err := netip.ParseAddrPort(r.exchanges[i].Remote)
+	if err != nil {
+		return nil, fmt.Errorf("%w: remote %q", ErrFixture, r.exchanges[i].Remote)
+	}
+	return &replayConn{
+		r:       r,
+		addr:    address,
+		remote:  net.UDPAddrFromAddrPort(remote),
+		replies: make(chan []byte, 1),
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+// replayConn answers each packet written with the recorded response,
+// carrying the transaction ID of the packet.
+type replayConn struct {
+	r       *Replayer
+	addr    string
+	remote  *net.UDPAddr
+	replies chan []byte
+
+	mu       sync.Mutex
+	deadline time.Time
+	closed   chan struct{}
+}
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	if len(b) < 16 {
+		return 0, fmt.Errorf("%w: short udp packet", ErrNoExchange)
+	}
+	e, ok := c.r.take(func(e *Exchange) bool {
+		return e.Network == "udp" && e.Addr == c.addr && sameUDPRequest([]byte(e.Request), b)
+	})
+	if !ok {
+		return 0, fmt.Errorf("%w for udp action %d to %s", ErrNoExchange, binary.BigEndian.Uint32(b[8:]), c.addr)
+	}
+	reply := []byte(e.Response)
+	if len(reply) >= 8 {
+		copy(reply[4:8], b[12:16])
+	}
+	select {
+	case c.replies <- reply:
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+	return len(b), nil
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case reply := <-c.replies:
+		return copy(b, reply), nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *replayConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	return nil
+}
+
+func (c *replayConn) LocalAddr() net.Addr  { return &net.UDPAddr{IP: net.IPv4zero} }
+func (c *replayConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *replayConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *replayConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// sameUDPRequest reports whether two packets are the same request but for
+// the connection and transaction IDs, which change between runs.
+func sameUDPRequest(a, b []byte) bool {
+	if len(a) != len(b) || len(a) < 16 {
+		return false
+	}
+	if !bytes.Equal(a[8:12], b[8:12]) || !bytes.Equal(a[16:], b[16:]) {
+		return false
+	}
+	// the protocol ID of connects stays
+	return binary.BigEndian.Uint32(a[8:]) != wire.ActionConnect || bytes.Equal(a[:8], b[:8])
+}
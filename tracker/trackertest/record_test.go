@@ -0,0 +1,72 @@
+package trackertest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// session announces and scrapes over HTTP and UDP, returning what came
+// back, errors included.
+func session(http *tracker.HTTPClient, udp *tracker.UDPClient, httpURL, udpURL string) []any {
+	ctx := context.Background()
+	req := testRequest()
+	var got []any
+	add := func(v any, err error) {
+		got = append(got, v, err)
+	}
+	add(http.Announce(ctx, httpURL, req))
+	add(http.Announce(ctx, httpURL, req))
+	add(http.Scrape(ctx, httpURL, []metainfo.InfoHash{req.InfoHash}))
+	add(udp.Announce(ctx, udpURL, req))
+	add(udp.Announce(ctx, udpURL, req))
+	add(udp.Announce(ctx, udpURL, req))
+	add(udp.Scrape(ctx, udpURL, []metainfo.InfoHash{req.InfoHash}))
+	return got
+}
+
+func TestRecordReplay(t *testing.T) {
+	tr := NewTracker()
+	files := map[metainfo.InfoHash]tracker.ScrapeStats{testRequest().InfoHash: {Seeders: 4}}
+	tr.Respond(
+		Response{Redirect: "/moved/announce"},
+		Response{Interval: time.Minute, Peers: testPeers, Warning: "w"},
+		Response{Failure: "http failure"},
+		Response{Files: files},
+		Response{Seeders: 2, Peers: testPeers},
+		Response{Failure: "udp failure"},
+		Response{Leechers: 5},
+		Response{Files: files},
+	)
+	httpURL, udpURL := tr.HTTPURL(), tr.UDPURL()+"/a"
+	rec := &Recorder{}
+	want := session(rec.HTTPClient(), rec.UDPClient(), httpURL, udpURL)
+	tr.Close()
+	assert.Len(t, rec.Exchanges(), 10) // with a redirect and two UDP connects
+	var fe *tracker.FailureError
+	require.ErrorAs(t, want[3].(error), &fe)
+	require.ErrorAs(t, want[9].(error), &fe)
+
+	path := filepath.Join(t.TempDir(), "session.torrent-fixture")
+	require.NoError(t, rec.Save(path))
+	rep, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, session(rep.HTTPClient(), rep.UDPClient(), httpURL, udpURL))
+
+	// every exchange answers once
+	_, err = rep.HTTPClient().Announce(context.Background(), httpURL, testRequest())
+	assert.ErrorIs(t, err, ErrNoExchange)
+	_, err = rep.UDPClient().Scrape(context.Background(), "udp://tracker.example.com:80", nil)
+	assert.ErrorIs(t, err, ErrNoExchange)
+
+	require.NoError(t, os.WriteFile(path, []byte("d9:exchangesl"), 0o644))
+	_, err = Load(path)
+	assert.ErrorIs(t, err, ErrFixture)
+}
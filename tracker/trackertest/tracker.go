@@ -0,0 +1,388 @@
+// Package trackertest provides a programmable fake tracker and the
+// recording and replay of tracker exchanges, for testing code that
+// announces without reaching real trackers.
+package trackertest
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+	"github.com/MysticalDevil/gobittorrent/bencode"
+)
+
+// Tracker is a fake tracker on localhost speaking HTTP and UDP. It answers
+// each request with the next queued Response, or with the default one when
+// none is queued, and records every request it gets.
+type Tracker struct {
+	HTTP *httptest.Server
+	UDP  net.PacketConn
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	queue    []Response
+	def      Response
+	requests []Request
+}
+
+// Response is what the fake tracker answers with. The zero value is an
+// empty successful response.
+type Response struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	TrackerID   string
+	Seeders     int
+	Leechers    int
+	Peers       []tracker.Peer // of both families over HTTP, of the tracker's over UDP
+	Files       map[metainfo.InfoHash]tracker.ScrapeStats
+
+	Failure string // sent as the failure reason instead of a response
+	Warning string // HTTP only
+
+	// Delay holds the response back, as a slow or unreachable tracker
+	// would; requests given up meanwhile get nothing.
+	Delay time.Duration
+	// Drop leaves the request unanswered, so UDP clients retransmit;
+	// HTTP connections are closed.
+	Drop bool
+	// Redirect, over HTTP, sends the client to this URL, which may be
+	// relative, with a 302 Found.
+	Redirect string
+	// Status, over HTTP, is sent instead of 200 OK.
+	Status int
+	// Body, when non-nil, is sent in place of the encoded response, such
+	// as malformed bencode. Over UDP it follows the action and transaction
+	// ID of the reply.
+	Body []byte
+}
+
+// Request is a request the fake tracker got.
+type Request struct {
+	Network  string                   // "http" or "udp"
+	Path     string                   // the HTTP request URI, or the URL data of a UDP announce (BEP 41)
+	Header   http.Header              // HTTP only
+	Announce *tracker.AnnounceRequest // nil for scrapes
+	Scrape   []metainfo.InfoHash      // the info hashes of a scrape
+}
+
+// NewTracker starts a fake tracker, which must be closed when done. It
+// panics when it cannot listen, as httptest.NewServer does.
+func NewTracker() *Tracker {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic("trackertest: listening: " + err.Error())
+	}
+	t := &Tracker{UDP: udp, closed: make(chan struct{})}
+	t.HTTP = httptest.NewServer(http.HandlerFunc(t.serveHTTP))
+	t.wg.Add(1)
+	go t.serveUDP()
+	return t
+}
+
+// Close stops the tracker, cutting short delayed responses.
+func (t *Tracker) Close() {
+	close(t.closed)
+	t.UDP.Close()
+	t.HTTP.CloseClientConnections()
+	t.HTTP.Close()
+	t.wg.Wait()
+}
+
+// HTTPURL returns the HTTP announce URL of the tracker.
+func (t *Tracker) HTTPURL() string {
+	return t.HTTP.URL + "/announce"
+}
+
+// UDPURL returns the UDP announce URL of the tracker.
+func (t *Tracker) UDPURL() string {
+	return "udp://" + t.UDP.LocalAddr().String()
+}
+
+// Respond queues responses for the next requests, one each.
+func (t *Tracker) Respond(rs ...Response) {
+	t.mu.Lock()
+	t.queue = append(t.queue, rs...)
+	t.mu.Unlock()
+}
+
+// SetDefault sets the response used when none is queued.
+func (t *Tracker) SetDefault(r Response) {
+	t.mu.Lock()
+	t.def = r
+	t.mu.Unlock()
+}
+
+// Requests returns the announces and scrapes received so far. UDP connects
+// are left out.
+func (t *Tracker) Requests() []Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Request(nil), t.requests...)
+}
+
+// next records req and returns the response to it.
+func (t *Tracker) next(req Request) Response {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = append(t.requests, req)
+	if len(t.queue) == 0 {
+		return t.def
+	}
+	r := t.queue[0]
+	t.queue = t.queue[1:]
+	return r
+}
+
+// wait sleeps for the delay of r and reports whether to answer at all.
+func (t *Tracker) wait(r *Response, gone <-chan struct{}) bool {
+	if r.Drop {
+		return false
+	}
+	if r.Delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(r.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-gone:
+	case <-t.closed:
+	}
+	return false
+}
+
+type httpAnnounce struct {
+	Complete       int    `bencode:"complete"`
+	Incomplete     int    `bencode:"incomplete"`
+	Interval       int    `bencode:"interval"`
+	MinInterval    int    `bencode:"min interval,omitempty"`
+	Peers          string `bencode:"peers"`
+	Peers6         string `bencode:"peers6,omitempty"`
+	TrackerID      string `bencode:"tracker id,omitempty"`
+	WarningMessage string `bencode:"warning message,omitempty"`
+}
+
+type httpScrape struct {
+	Files map[string]httpFile `bencode:"files"`
+}
+
+type httpFile struct {
+	Complete   int    `bencode:"complete"`
+	Downloaded int    `bencode:"downloaded"`
+	Incomplete int    `bencode:"incomplete"`
+	Name       string `bencode:"name,omitempty"`
+}
+
+type httpFailure struct {
+	FailureReason string `bencode:"failure reason"`
+}
+
+func (t *Tracker) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req := Request{Network: "http", Path: r.URL.RequestURI(), Header: r.Header.Clone()}
+	q := r.URL.Query()
+	scrape := strings.HasPrefix(r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:], "scrape")
+	if scrape {
+		for _, h := range q["info_hash"] {
+			if len(h) == len(metainfo.InfoHash{}) {
+				req.Scrape = append(req.Scrape, metainfo.InfoHash([]byte(h)))
+			}
+		}
+	} else {
+		req.Announce = parseQuery(q)
+	}
+	res := t.next(req)
+	if !t.wait(&res, r.Context().Done()) {
+		if res.Drop {
+			// hang up without a response
+			if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+				conn.Close()
+			}
+		}
+		return
+	}
+	if res.Redirect != "" {
+		http.Redirect(w, r, res.Redirect, http.StatusFound)
+		return
+	}
+	if res.Status != 0 {
+		w.WriteHeader(res.Status)
+	}
+	body := res.Body
+	if body == nil {
+		body = encodeHTTP(&res, scrape)
+	}
+	w.Write(body)
+}
+
+func encodeHTTP(r *Response, scrape bool) []byte {
+	var v any
+	switch {
+	case r.Failure != "":
+		v = httpFailure{FailureReason: r.Failure}
+	case scrape:
+		s := httpScrape{Files: make(map[string]httpFile, len(r.Files))}
+		for h, f := range r.Files {
+			s.Files[string(h[:])] = httpFile{
+				Complete:   f.Seeders,
+				Downloaded: f.Downloaded,
+				Incomplete: f.Leechers,
+				Name:       f.Name,
+			}
+		}
+		v = s
+	default:
+		a := httpAnnounce{
+			Complete:       r.Seeders,
+			Incomplete:     r.Leechers,
+			Interval:       int(r.Interval.Seconds()),
+			MinInterval:    int(r.MinInterval.Seconds()),
+			TrackerID:      r.TrackerID,
+			WarningMessage: r.Warning,
+		}
+		a.Peers = string(compactPeers(r.Peers, true))
+		a.Peers6 = string(compactPeers(r.Peers, false))
+		v = a
+	}
+	b, err := bencode.AppendBencode(nil, v)
+	if err != nil {
+		panic("trackertest: encoding response: " + err.Error())
+	}
+	return b
+}
+
+// parseQuery reads the announce of an HTTP query, leaving malformed
+// numbers at zero.
+func parseQuery(q url.Values) *tracker.AnnounceRequest {
+	req := &tracker.AnnounceRequest{TrackerID: q.Get("trackerid")}
+	copy(req.InfoHash[:], q.Get("info_hash"))
+	copy(req.PeerID[:], q.Get("peer_id"))
+	req.Port, _ = strconv.Atoi(q.Get("port"))
+	req.Uploaded, _ = strconv.ParseInt(q.Get("uploaded"), 10, 64)
+	req.Downloaded, _ = strconv.ParseInt(q.Get("downloaded"), 10, 64)
+	req.Left, _ = strconv.ParseInt(q.Get("left"), 10, 64)
+	req.NumWant, _ = strconv.Atoi(q.Get("numwant"))
+	key, _ := strconv.ParseUint(q.Get("key"), 16, 32)
+	req.Key = uint32(key)
+	switch q.Get("event") {
+	case "started":
+		req.Event = tracker.Started
+	case "completed":
+		req.Event = tracker.Completed
+	case "stopped":
+		req.Event = tracker.Stopped
+	}
+	return req
+}
+
+// compactPeers encodes the IPv4 or the IPv6 peers of peers.
+func compactPeers(peers []tracker.Peer, ipv4 bool) []byte {
+	var b []byte
+	for _, p := range peers {
+		if p.Addr.Addr().Unmap().Is4() == ipv4 {
+			b = wire.AppendCompactPeer(b, p.Addr)
+		}
+	}
+	return b
+}
+
+func (t *Tracker) serveUDP() {
+	defer t.wg.Done()
+	buf := make([]byte, wire.MaxPacket)
+	for {
+		n, from, err := t.UDP.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pkt := append([]byte(nil), buf[:n]...)
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			if reply := t.handleUDP(pkt); reply != nil {
+				t.UDP.WriteTo(reply, from)
+			}
+		}()
+	}
+}
+
+// handleUDP returns the reply to pkt, or nil for none. Any connection ID
+// is accepted.
+func (t *Tracker) handleUDP(pkt []byte) []byte {
+	if len(pkt) < 16 {
+		return nil
+	}
+	action := binary.BigEndian.Uint32(pkt[8:])
+	reply := binary.BigEndian.AppendUint32(nil, action)
+	reply = append(reply, pkt[12:16]...)
+	req := Request{Network: "udp"}
+	body := pkt[16:]
+	switch action {
+	case wire.ActionConnect:
+		if binary.BigEndian.Uint64(pkt) != wire.ProtocolID {
+			return nil
+		}
+		return binary.BigEndian.AppendUint64(reply, 1)
+	case wire.ActionAnnounce:
+		if len(body) < 82 {
+			return nil
+		}
+		a := &tracker.AnnounceRequest{
+			InfoHash:   metainfo.InfoHash(body[:20]),
+			PeerID:     tracker.PeerID(body[20:40]),
+			Downloaded: int64(binary.BigEndian.Uint64(body[40:])),
+			Left:       int64(binary.BigEndian.Uint64(body[48:])),
+			Uploaded:   int64(binary.BigEndian.Uint64(body[56:])),
+			Event:      tracker.Event(binary.BigEndian.Uint32(body[64:])),
+			Key:        binary.BigEndian.Uint32(body[72:]),
+			NumWant:    int(int32(binary.BigEndian.Uint32(body[76:]))),
+			Port:       int(binary.BigEndian.Uint16(body[80:])),
+		}
+		if a.NumWant < 0 {
+			a.NumWant = 0
+		}
+		req.Announce = a
+		req.Path = wire.URLData(body[82:])
+	case wire.ActionScrape:
+		for b := body; len(b) >= 20; b = b[20:] {
+			req.Scrape = append(req.Scrape, metainfo.InfoHash(b[:20]))
+		}
+	default:
+		return nil
+	}
+	res := t.next(req)
+	if !t.wait(&res, nil) {
+		return nil
+	}
+	switch {
+	case res.Body != nil:
+		return append(reply, res.Body...)
+	case res.Failure != "":
+		binary.BigEndian.PutUint32(reply, wire.ActionError)
+		return append(reply, res.Failure...)
+	case action == wire.ActionScrape:
+		for _, h := range req.Scrape {
+			f := res.Files[h]
+			reply = binary.BigEndian.AppendUint32(reply, uint32(f.Seeders))
+			reply = binary.BigEndian.AppendUint32(reply, uint32(f.Downloaded))
+			reply = binary.BigEndian.AppendUint32(reply, uint32(f.Leechers))
+		}
+		return reply
+	}
+	reply = binary.BigEndian.AppendUint32(reply, uint32(res.Interval.Seconds()))
+	reply = binary.BigEndian.AppendUint32(reply, uint32(res.Leechers))
+	reply = binary.BigEndian.AppendUint32(reply, uint32(res.Seeders))
+	local := netip.MustParseAddrPort(t.UDP.LocalAddr().String())
+	return append(reply, compactPeers(res.Peers, local.Addr().Is4())...)
+}
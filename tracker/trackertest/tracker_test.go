@@ -0,0 +1,156 @@
+package trackertest
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRequest() *tracker.AnnounceRequest {
+	return &tracker.AnnounceRequest{
+		InfoHash: metainfo.InfoHash{0xaa, '&'},
+		PeerID:   tracker.PeerID([]byte("-GO0001-abcdefghijkl")),
+		Port:     6881,
+		Left:     100,
+		Event:    tracker.Started,
+		NumWant:  10,
+		Key:      0xbeef,
+	}
+}
+
+var testPeers = []tracker.Peer{
+	{Addr: netip.MustParseAddrPort("10.0.0.1:6881")},
+	{Addr: netip.MustParseAddrPort("[2001:db8::1]:6882")},
+}
+
+func TestHTTP(t *testing.T) {
+	tr := NewTracker()
+	defer tr.Close()
+	c := &tracker.HTTPClient{}
+	ctx := context.Background()
+	req := testRequest()
+	var fe *tracker.FailureError
+
+	tr.Respond(
+		Response{Interval: time.Minute, Seeders: 2, Leechers: 3, Peers: testPeers, Warning: "slow down", TrackerID: "t1"},
+		Response{Failure: "unregistered torrent"},
+		Response{Redirect: "/elsewhere/announce"},
+		Response{Seeders: 7},
+		Response{Body: []byte("d8:intervali")},
+		Response{Status: http.StatusBadGateway},
+		Response{Delay: time.Minute},
+	)
+	res, err := c.Announce(ctx, tr.HTTPURL(), req)
+	require.NoError(t, err)
+	assert.Equal(t, &tracker.AnnounceResponse{
+		Interval:       time.Minute,
+		TrackerID:      "t1",
+		Seeders:        2,
+		Leechers:       3,
+		Peers:          testPeers,
+		WarningMessage: "slow down",
+	}, res)
+
+	_, err = c.Announce(ctx, tr.HTTPURL(), req)
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, "unregistered torrent", fe.Reason)
+
+	res, err = c.Announce(ctx, tr.HTTPURL(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 7, res.Seeders)
+
+	_, err = c.Announce(ctx, tr.HTTPURL(), req)
+	assert.ErrorContains(t, err, "decoding tracker response")
+
+	_, err = c.Announce(ctx, tr.HTTPURL(), req)
+	assert.ErrorIs(t, err, tracker.ErrHTTPStatus)
+
+	ctx2, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Announce(ctx2, tr.HTTPURL(), req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	reqs := tr.Requests()
+	require.Len(t, reqs, 7)
+	assert.Equal(t, "http", reqs[0].Network)
+	assert.Equal(t, req, reqs[0].Announce)
+	assert.Equal(t, "/elsewhere/announce", reqs[3].Path)
+
+	tr.SetDefault(Response{Files: map[metainfo.InfoHash]tracker.ScrapeStats{
+		req.InfoHash: {Seeders: 1, Leechers: 2, Downloaded: 3, Name: "x"},
+	}})
+	scrape, err := c.Scrape(ctx, tr.HTTPURL(), []metainfo.InfoHash{req.InfoHash})
+	require.NoError(t, err)
+	assert.Equal(t, map[metainfo.InfoHash]tracker.ScrapeStats{
+		req.InfoHash: {Seeders: 1, Leechers: 2, Downloaded: 3, Name: "x"},
+	}, scrape.Files)
+	reqs = tr.Requests()
+	assert.Equal(t, []metainfo.InfoHash{req.InfoHash}, reqs[len(reqs)-1].Scrape)
+}
+
+func TestUDP(t *testing.T) {
+	tr := NewTracker()
+	defer tr.Close()
+	c := &tracker.UDPClient{Timeout: 20 * time.Millisecond}
+	ctx := context.Background()
+	req := testRequest()
+	var fe *tracker.FailureError
+
+	tr.Respond(
+		Response{Interval: time.Minute, Seeders: 2, Leechers: 3, Peers: testPeers},
+		Response{Failure: "unregistered torrent"},
+		Response{Drop: true},
+		Response{Seeders: 7},
+		Response{Body: []byte{0, 0}},
+		Response{Delay: time.Minute},
+	)
+	res, err := c.Announce(ctx, tr.UDPURL()+"/a?k=1", req)
+	require.NoError(t, err)
+	// a tracker on IPv4 only sends IPv4 peers
+	assert.Equal(t, &tracker.AnnounceResponse{
+		Interval: time.Minute,
+		Seeders:  2,
+		Leechers: 3,
+		Peers:    testPeers[:1],
+	}, res)
+
+	_, err = c.Announce(ctx, tr.UDPURL(), req)
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, "unregistered torrent", fe.Reason)
+
+	// a dropped request is sent again
+	res, err = c.Announce(ctx, tr.UDPURL(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 7, res.Seeders)
+
+	_, err = c.Announce(ctx, tr.UDPURL(), req)
+	assert.ErrorIs(t, err, tracker.ErrUDPResponse)
+
+	// a client retransmitting sooner would get the next response
+	ctx2, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = (&tracker.UDPClient{}).Announce(ctx2, tr.UDPURL(), req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	reqs := tr.Requests()
+	require.Len(t, reqs, 6)
+	assert.Equal(t, Request{Network: "udp", Path: "/a?k=1", Announce: req}, reqs[0])
+	assert.Equal(t, reqs[2], reqs[3])
+
+	tr.SetDefault(Response{Files: map[metainfo.InfoHash]tracker.ScrapeStats{
+		req.InfoHash: {Seeders: 1, Leechers: 2, Downloaded: 3},
+	}})
+	scrape, err := c.Scrape(ctx, tr.UDPURL(), []metainfo.InfoHash{req.InfoHash, {1}})
+	require.NoError(t, err)
+	assert.Equal(t, map[metainfo.InfoHash]tracker.ScrapeStats{
+		req.InfoHash: {Seeders: 1, Leechers: 2, Downloaded: 3},
+		{1}:          {},
+	}, scrape.Files)
+}
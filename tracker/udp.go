@@ -0,0 +1,272 @@
+package tracker
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+)
+
+// connectionIDLifetime is how long a client may use a connection ID.
+const connectionIDLifetime = time.Minute
+
+// UDPClient talks to UDP trackers (BEP 15), including the URL data
+// extension of BEP 41. It caches connection IDs per tracker host and is
+// safe for concurrent use once configured.
+type UDPClient struct {
+	// Timeout is how long to wait before the first retransmission; it
+	// doubles after each. 0 means the 15 seconds of BEP 15.
+	Timeout time.Duration
+	// MaxRetransmits is how often a request is resent before giving up;
+	// 0 means 8, the limit of BEP 15.
+	MaxRetransmits int
+	// DialContext, when set, opens the connection to a tracker in place of
+	// net.Dialer. The remote address the connection reports once answered
+	// tells the address family of the peers.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+	// Proxy, when set and DialContext is nil, is the socks5 URL of the
+	// proxy to relay through with UDP ASSOCIATE (RFC 1928), with any
+	// user name and password of the URL.
+	Proxy *url.URL
+
+	mu    sync.Mutex
+	conns map[string]connectionID
+}
+
+type connectionID struct {
+	id      uint64
+	expires time.Time
+}
+
+// udpRequest is one request with its response, exchanged in full on every
+// retransmission.
+type udpRequest struct {
+	action uint32
+	body   []byte
+	minLen int // shortest valid response, headers included
+}
+
+func (c *UDPClient) Announce(ctx context.Context, announce string, req *AnnounceRequest) (*AnnounceResponse, error) {
+	body := make([]byte, 0, 82)
+	body = append(body, req.InfoHash[:]...)
+	body = append(body, req.PeerID[:]...)
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Downloaded))
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Left))
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Uploaded))
+	body = binary.BigEndian.AppendUint32(body, uint32(req.Event))
+	var ip [4]byte // 0 is the sender's
+	if req.IP.Unmap().Is4() {
+		ip = req.IP.Unmap().As4()
+	}
+	body = append(body, ip[:]...)
+	body = binary.BigEndian.AppendUint32(body, req.Key)
+	numWant := int32(req.NumWant)
+	if numWant == 0 {
+		numWant = -1
+	}
+	body = binary.BigEndian.AppendUint32(body, uint32(numWant))
+	body = binary.BigEndian.AppendUint16(body, uint16(req.Port))
+
+	resp, ipv6, err := c.do(ctx, announce, udpRequest{action: wire.ActionAnnounce, body: body, minLen: 20})
+	if err != nil {
+		return nil, err
+	}
+	res := &AnnounceResponse{
+		Interval: time.Duration(binary.BigEndian.Uint32(resp[8:])) * time.Second,
+		Leechers: int(binary.BigEndian.Uint32(resp[12:])),
+		Seeders:  int(binary.BigEndian.Uint32(resp[16:])),
+	}
+	size := 4
+	if ipv6 {
+		size = 16
+	}
+	res.Peers, err = parseCompactPeers(resp[20:], size)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Scrape asks for the stats of up to 74 swarms at once.
+func (c *UDPClient) Scrape(ctx context.Context, announce string, hashes []metainfo.InfoHash) (*ScrapeResponse, error) {
+	if len(hashes) > wire.MaxScrapeHashes {
+		return nil, fmt.Errorf("%w: at most %d info hashes per udp scrape", ErrScrape, wire.MaxScrapeHashes)
+	}
+	body := make([]byte, 0, len(hashes)*20)
+	for _, h := range hashes {
+		body = append(body, h[:]...)
+	}
+	resp, _, err := c.do(ctx, announce, udpRequest{action: wire.ActionScrape, body: body, minLen: 8 + 12*len(hashes)})
+	if err != nil {
+		return nil, err
+	}
+	res := &ScrapeResponse{Files: make(map[metainfo.InfoHash]ScrapeStats, len(hashes))}
+	for i, h := range hashes {
+		b := resp[8+12*i:]
+		res.Files[h] = ScrapeStats{
+			Seeders:    int(binary.BigEndian.Uint32(b)),
+			Downloaded: int(binary.BigEndian.Uint32(b[4:])),
+			Leechers:   int(binary.BigEndian.Uint32(b[8:])),
+		}
+	}
+	return res, nil
+}
+
+// do sends req to the tracker, connecting first when there is no fresh
+// connection ID, and returns the response and whether the tracker was
+// reached over IPv6.
+func (c *UDPClient) do(ctx context.Context, announce string, req udpRequest) (resp []byte, ipv6 bool, err error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, false, err
+	}
+	if u.Scheme != "udp" {
+		return nil, false, fmt.Errorf("%w %q", ErrScheme, u.Scheme)
+	}
+	conn, err := c.dial(ctx, u.Host)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	// closing the socket interrupts a pending read on cancellation
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	options := wire.AppendURLData(nil, u.RequestURI())
+	resp, err = c.exchange(ctx, conn, u.Host, func() ([]byte, error) {
+		id, err := c.connectionID(ctx, conn, u.Host)
+		if err != nil {
+			return nil, err
+		}
+		pkt := binary.BigEndian.AppendUint64(nil, id)
+		pkt = binary.BigEndian.AppendUint32(pkt, req.action)
+		pkt = append(pkt, 0, 0, 0, 0) // transaction ID, set by exchange
+		pkt = append(pkt, req.body...)
+		if req.action == wire.ActionAnnounce {
+			pkt = append(pkt, options...)
+		}
+		return pkt, nil
+	}, req.action, req.minLen)
+	if err != nil {
+		return nil, false, err
+	}
+	remote, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	return resp, err == nil && !remote.Addr().Unmap().Is4(), nil
+}
+
+func (c *UDPClient) dial(ctx context.Context, addr string) (net.Conn, error) {
+	switch {
+	case c.DialContext != nil:
+		return c.DialContext(ctx, "udp", addr)
+	case c.Proxy != nil:
+		return dialSOCKS5UDP(ctx, c.Proxy, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "udp", addr)
+}
+
+func (c *UDPClient) connectionID(ctx context.Context, conn net.Conn, addr string) (uint64, error) {
+	c.mu.Lock()
+	cid, ok := c.conns[addr]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cid.expires) {
+		return cid.id, nil
+	}
+	resp, err := c.exchange(ctx, conn, addr, func() ([]byte, error) {
+		pkt := binary.BigEndian.AppendUint64(nil, wire.ProtocolID)
+		pkt = binary.BigEndian.AppendUint32(pkt, wire.ActionConnect)
+		return append(pkt, 0, 0, 0, 0), nil
+	}, wire.ActionConnect, 16)
+	if err != nil {
+		return 0, err
+	}
+	cid = connectionID{
+		id:      binary.BigEndian.Uint64(resp[8:]),
+		expires: time.Now().Add(connectionIDLifetime),
+	}
+	c.mu.Lock()
+	if c.conns == nil {
+		c.conns = make(map[string]connectionID)
+	}
+	c.conns[addr] = cid
+	c.mu.Unlock()
+	return cid.id, nil
+}
+
+// forget drops the connection ID of addr, which the tracker no longer
+// accepts.
+func (c *UDPClient) forget(addr string) {
+	c.mu.Lock()
+	delete(c.conns, addr)
+	c.mu.Unlock()
+}
+
+// exchange sends the packet build returns and waits for the response with
+// its transaction ID, retransmitting after 15·2^n seconds as BEP 15 asks.
+// Packets are rebuilt for every attempt so an expired connection ID is
+// renewed; their transaction ID goes at bytes 12 to 16.
+func (c *UDPClient) exchange(ctx context.Context, conn net.Conn, addr string, build func() ([]byte, error), action uint32, minLen int) ([]byte, error) {
+	timeout := cmp.Or(c.Timeout, 15*time.Second)
+	maxRetransmits := cmp.Or(c.MaxRetransmits, 8)
+	buf := make([]byte, wire.MaxPacket)
+	for n := 0; n <= maxRetransmits; n++ {
+		pkt, err := build()
+		if err != nil {
+			return nil, err
+		}
+		var txid [4]byte
+		rand.Read(txid[:])
+		copy(pkt[12:16], txid[:])
+		_, err = conn.Write(pkt)
+		if err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout << n)
+		for {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			conn.SetReadDeadline(deadline)
+			m, err := conn.Read(buf)
+			if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+				return nil, cmp.Or(ctx.Err(), err)
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				// ICMP errors on connected sockets; keep waiting
+				continue
+			}
+			resp := buf[:m]
+			if m < 8 || [4]byte(resp[4:8]) != txid {
+				continue
+			}
+			got := binary.BigEndian.Uint32(resp)
+			if got == wire.ActionError {
+				if action != wire.ActionConnect {
+					c.forget(addr)
+				}
+				return nil, &FailureError{Reason: string(resp[8:])}
+			}
+			if got != action || m < minLen {
+				return nil, fmt.Errorf("%w: action %d, %d bytes", ErrUDPResponse, got, m)
+			}
+			return append([]byte(nil), resp...), nil
+		}
+	}
+	return nil, ErrTimeout
+}
@@ -0,0 +1,247 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// udpStandIn is a UDP tracker on localhost that hands every request other
+// than connect to handle and sends back what it returns.
+type udpStandIn struct {
+	conn net.PacketConn
+
+	mu       sync.Mutex
+	connects int
+	requests [][]byte
+	handle   func(req []byte, n int) [][]byte // n counts requests so far
+}
+
+func newUDPStandIn(t *testing.T, network, addr string) *udpStandIn {
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	s := &udpStandIn{conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *udpStandIn) setHandle(handle func(req []byte, n int) [][]byte) {
+	s.mu.Lock()
+	s.handle = handle
+	s.mu.Unlock()
+}
+
+// seen returns the number of connects and the other requests so far.
+func (s *udpStandIn) seen() (int, [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connects, s.requests
+}
+
+func (s *udpStandIn) url(path string) string {
+	return "udp://" + s.conn.LocalAddr().String() + path
+}
+
+func (s *udpStandIn) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+		action := binary.BigEndian.Uint32(req[8:])
+		s.mu.Lock()
+		var out [][]byte
+		if action == wire.ActionConnect {
+			s.connects++
+			resp := binary.BigEndian.AppendUint32(nil, wire.ActionConnect)
+			resp = append(resp, req[12:16]...)
+			out = [][]byte{binary.BigEndian.AppendUint64(resp, 0xc0ffee)}
+		} else {
+			s.requests = append(s.requests, req)
+			out = s.handle(req, len(s.requests))
+		}
+		s.mu.Unlock()
+		for _, resp := range out {
+			s.conn.WriteTo(resp, from)
+		}
+	}
+}
+
+func udpHeader(action uint32, req []byte) []byte {
+	resp := binary.BigEndian.AppendUint32(nil, action)
+	return append(resp, req[12:16]...)
+}
+
+func announceReply(req []byte, peers ...netip.AddrPort) []byte {
+	resp := udpHeader(wire.ActionAnnounce, req)
+	resp = binary.BigEndian.AppendUint32(resp, 1800)
+	resp = binary.BigEndian.AppendUint32(resp, 7)
+	resp = binary.BigEndian.AppendUint32(resp, 3)
+	for _, p := range peers {
+		resp = wire.AppendCompactPeer(resp, p)
+	}
+	return resp
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	s := newUDPStandIn(t, "udp4", "127.0.0.1:0")
+	peer := netip.MustParseAddrPort("10.1.2.3:6881")
+	s.setHandle(func(req []byte, n int) [][]byte {
+		return [][]byte{announceReply(req, peer)}
+	})
+	client := &UDPClient{}
+	req := testRequest()
+	res, err := client.Announce(context.Background(), s.url("/announce?passkey=x"), req)
+	require.NoError(t, err)
+	assert.Equal(t, &AnnounceResponse{
+		Interval: 30 * time.Minute,
+		Leechers: 7,
+		Seeders:  3,
+		Peers:    []Peer{{Addr: peer}},
+	}, res)
+
+	_, requests := s.seen()
+	got := requests[0]
+	require.Len(t, got, 98+2+19+1)
+	assert.Equal(t, uint64(0xc0ffee), binary.BigEndian.Uint64(got))
+	assert.Equal(t, req.InfoHash[:], got[16:36])
+	assert.Equal(t, req.PeerID[:], got[36:56])
+	assert.Equal(t, uint64(512), binary.BigEndian.Uint64(got[56:]))
+	assert.Equal(t, uint64(1024), binary.BigEndian.Uint64(got[64:]))
+	assert.Equal(t, uint64(1<<40), binary.BigEndian.Uint64(got[72:]))
+	assert.Equal(t, uint32(Started), binary.BigEndian.Uint32(got[80:]))
+	assert.Equal(t, uint32(0xbeef), binary.BigEndian.Uint32(got[88:]))
+	assert.Equal(t, uint32(30), binary.BigEndian.Uint32(got[92:]))
+	assert.Equal(t, uint16(6881), binary.BigEndian.Uint16(got[96:]))
+	assert.Equal(t, append([]byte{2, 19}, "/announce?passkey=x\x00"...), got[98:])
+
+	// the connection ID is reused
+	_, err = client.Announce(context.Background(), s.url(""), req)
+	require.NoError(t, err)
+	connects, requests := s.seen()
+	assert.Equal(t, 1, connects)
+	assert.Len(t, requests[1], 98)
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	s := newUDPStandIn(t, "udp4", "127.0.0.1:0")
+	s.setHandle(func(req []byte, n int) [][]byte {
+		switch n {
+		case 1:
+			return nil // lost
+		case 2:
+			// a stale reply and one for another transaction come first
+			wrong := announceReply(req)
+			wrong[4] ^= 0xff
+			return [][]byte{wrong, announceReply(req)}
+		}
+		return nil
+	})
+	client := &UDPClient{Timeout: 20 * time.Millisecond}
+	start := time.Now()
+	res, err := client.Announce(context.Background(), s.url(""), testRequest())
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Seeders)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	_, requests := s.seen()
+	require.Len(t, requests, 2)
+	txids := [][]byte{requests[0][12:16], requests[1][12:16]}
+	assert.NotEqual(t, txids[0], txids[1])
+
+	t.Run("GiveUp", func(t *testing.T) {
+		s.setHandle(func([]byte, int) [][]byte { return nil })
+		client := &UDPClient{Timeout: 5 * time.Millisecond, MaxRetransmits: 2}
+		_, requests := s.seen()
+		n := len(requests)
+		start := time.Now()
+		_, err := client.Announce(context.Background(), s.url(""), testRequest())
+		assert.ErrorIs(t, err, ErrTimeout)
+		// waits 5, 10 and 20 ms
+		assert.GreaterOrEqual(t, time.Since(start), 35*time.Millisecond)
+		_, requests = s.seen()
+		assert.Equal(t, 3, len(requests)-n)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := (&UDPClient{}).Announce(ctx, s.url(""), testRequest())
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestUDPError(t *testing.T) {
+	s := newUDPStandIn(t, "udp4", "127.0.0.1:0")
+	s.setHandle(func(req []byte, n int) [][]byte {
+		return [][]byte{append(udpHeader(wire.ActionError, req), "unregistered torrent"...)}
+	})
+	client := &UDPClient{}
+	_, err := client.Announce(context.Background(), s.url(""), testRequest())
+	var fe *FailureError
+	require.True(t, errors.As(err, &fe))
+	assert.Equal(t, "unregistered torrent", fe.Reason)
+
+	// an error drops the connection ID in case it expired
+	_, err = client.Announce(context.Background(), s.url(""), testRequest())
+	require.Error(t, err)
+	connects, _ := s.seen()
+	assert.Equal(t, 2, connects)
+
+	s.setHandle(func(req []byte, n int) [][]byte {
+		return [][]byte{udpHeader(wire.ActionScrape, req)}
+	})
+	_, err = client.Announce(context.Background(), s.url(""), testRequest())
+	assert.ErrorIs(t, err, ErrUDPResponse)
+
+	_, err = client.Announce(context.Background(), "http://x/", testRequest())
+	assert.ErrorIs(t, err, ErrScheme)
+}
+
+func TestUDPAnnounceIPv6(t *testing.T) {
+	s := newUDPStandIn(t, "udp6", "[::1]:0")
+	peer := netip.MustParseAddrPort("[2001:db8::7]:51413")
+	s.setHandle(func(req []byte, n int) [][]byte {
+		return [][]byte{announceReply(req, peer)}
+	})
+	res, err := (&UDPClient{}).Announce(context.Background(), s.url(""), testRequest())
+	require.NoError(t, err)
+	assert.Equal(t, []Peer{{Addr: peer}}, res.Peers)
+}
+
+func TestUDPScrape(t *testing.T) {
+	s := newUDPStandIn(t, "udp4", "127.0.0.1:0")
+	s.setHandle(func(req []byte, n int) [][]byte {
+		resp := udpHeader(wire.ActionScrape, req)
+		for i := range (len(req) - 16) / 20 {
+			resp = binary.BigEndian.AppendUint32(resp, uint32(10*i+1))
+			resp = binary.BigEndian.AppendUint32(resp, uint32(10*i+2))
+			resp = binary.BigEndian.AppendUint32(resp, uint32(10*i+3))
+		}
+		return [][]byte{resp}
+	})
+	a, b := metainfo.InfoHash{1}, metainfo.InfoHash{2}
+	res, err := (&UDPClient{}).Scrape(context.Background(), s.url(""), []metainfo.InfoHash{a, b})
+	require.NoError(t, err)
+	assert.Equal(t, map[metainfo.InfoHash]ScrapeStats{
+		a: {Seeders: 1, Downloaded: 2, Leechers: 3},
+		b: {Seeders: 11, Downloaded: 12, Leechers: 13},
+	}, res.Files)
+
+	_, err = (&UDPClient{}).Scrape(context.Background(), s.url(""), make([]metainfo.InfoHash, 75))
+	assert.ErrorIs(t, err, ErrScrape)
+}
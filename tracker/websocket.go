@@ -0,0 +1,336 @@
+package tracker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/websocket"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/webtorrent"
+)
+
+// WebSocketClient announces to WebTorrent trackers, which speak JSON over
+// WebSocket (ws and wss URLs) and relay WebRTC offers and answers between
+// peers instead of handing out addresses. It keeps one connection per
+// tracker open to receive what is relayed. It is safe for concurrent use.
+type WebSocketClient struct {
+	TLSConfig *tls.Config // for wss; nil uses the defaults
+	// OnSignal, when set, receives the offers and answers trackers relay.
+	// It is called from the goroutine reading the connection, so it must
+	// not wait on the client.
+	OnSignal func(announce string, s *Signal)
+
+	mu    sync.Mutex
+	conns map[string]*wsConn
+}
+
+// Offer is a WebRTC offer sent along an announce for the tracker to pass
+// to another peer of the swarm.
+type Offer struct {
+	ID    string          // 20 bytes naming the offer
+	Offer json.RawMessage // the session description, like {"type":"offer","sdp":"..."}
+}
+
+// Signal is an offer relayed from another peer, or its answer to one of
+// ours.
+type Signal struct {
+	InfoHash metainfo.InfoHash
+	PeerID   PeerID // of the other peer
+	OfferID  string
+	Offer    json.RawMessage // set for offers
+	Answer   json.RawMessage // set for answers
+}
+
+type wsConn struct {
+	dialed chan struct{}   // closed once the dial is over
+	conn   *websocket.Conn // nil when the dial failed; set before dialed is closed
+	done   chan struct{}   // closed once reading stopped
+
+	mu      sync.Mutex
+	waiters map[string][]chan *webtorrent.Message // by replyKey
+	err     error
+}
+
+func (c *WebSocketClient) Announce(ctx context.Context, announce string, req *AnnounceRequest) (*AnnounceResponse, error) {
+	return c.AnnounceOffers(ctx, announce, req, nil)
+}
+
+// AnnounceOffers announces with offers for the tracker to pass on, one to
+// each of as many peers.
+func (c *WebSocketClient) AnnounceOffers(ctx context.Context, announce string, req *AnnounceRequest, offers []Offer) (*AnnounceResponse, error) {
+	numWant := req.NumWant
+	if len(offers) > 0 {
+		numWant = len(offers)
+	}
+	m := &webtorrent.Message{
+		Action:     "announce",
+		InfoHash:   webtorrent.Hashes{webtorrent.Binary(req.InfoHash[:])},
+		PeerID:     webtorrent.Binary(req.PeerID[:]),
+		Event:      req.Event.String(),
+		NumWant:    &numWant,
+		Uploaded:   &req.Uploaded,
+		Downloaded: &req.Downloaded,
+		Left:       &req.Left,
+	}
+	for _, o := range offers {
+		m.Offers = append(m.Offers, webtorrent.Offer{Offer: o.Offer, OfferID: webtorrent.Binary(o.ID)})
+	}
+	reply, err := c.roundTrip(ctx, announce, m)
+	if err != nil {
+		return nil, err
+	}
+	res := &AnnounceResponse{
+		Interval:       time.Duration(reply.Interval) * time.Second,
+		WarningMessage: reply.WarningMessage,
+	}
+	if reply.Complete != nil {
+		res.Seeders = *reply.Complete
+	}
+	if reply.Incomplete != nil {
+		res.Leechers = *reply.Incomplete
+	}
+	return res, nil
+}
+
+// Answer sends our answer to offer, which the tracker relays to the peer
+// that made it; peerID is ours.
+func (c *WebSocketClient) Answer(ctx context.Context, announce string, peerID PeerID, offer *Signal, answer json.RawMessage) error {
+	wc, err := c.conn(ctx, announce)
+	if err != nil {
+		return err
+	}
+	return wc.send(&webtorrent.Message{
+		Action:   "announce",
+		InfoHash: webtorrent.Hashes{webtorrent.Binary(offer.InfoHash[:])},
+		PeerID:   webtorrent.Binary(peerID[:]),
+		ToPeerID: webtorrent.Binary(offer.PeerID[:]),
+		OfferID:  webtorrent.Binary(offer.OfferID),
+		Answer:   answer,
+	})
+}
+
+func (c *WebSocketClient) Scrape(ctx context.Context, announce string, hashes []metainfo.InfoHash) (*ScrapeResponse, error) {
+	m := &webtorrent.Message{Action: "scrape"}
+	for _, h := range hashes {
+		m.InfoHash = append(m.InfoHash, webtorrent.Binary(h[:]))
+	}
+	reply, err := c.roundTrip(ctx, announce, m)
+	if err != nil {
+		return nil, err
+	}
+	res := &ScrapeResponse{Files: make(map[metainfo.InfoHash]ScrapeStats, len(reply.Files))}
+	for k, f := range reply.Files {
+		raw, err := webtorrent.FromLatin1(k)
+		if err != nil || len(raw) != len(metainfo.InfoHash{}) {
+			continue
+		}
+		res.Files[metainfo.InfoHash([]byte(raw))] = ScrapeStats{
+			Seeders:    f.Complete,
+			Leechers:   f.Incomplete,
+			Downloaded: f.Downloaded,
+		}
+	}
+	return res, nil
+}
+
+// Close closes the connections to every tracker.
+func (c *WebSocketClient) Close() error {
+	c.mu.Lock()
+	conns := c.conns
+	c.conns = nil
+	c.mu.Unlock()
+	for _, wc := range conns {
+		select {
+		case <-wc.dialed:
+			if wc.conn != nil {
+				wc.conn.Close()
+			}
+		default:
+			// the dial closes what it gets, as wc is no longer listed
+		}
+	}
+	return nil
+}
+
+func (c *WebSocketClient) roundTrip(ctx context.Context, announce string, m *webtorrent.Message) (*webtorrent.Message, error) {
+	wc, err := c.conn(ctx, announce)
+	if err != nil {
+		return nil, err
+	}
+	key := replyKey(m)
+	ch := make(chan *webtorrent.Message, 1)
+	wc.mu.Lock()
+	if wc.err != nil {
+		wc.mu.Unlock()
+		return nil, wc.err
+	}
+	wc.waiters[key] = append(wc.waiters[key], ch)
+	wc.mu.Unlock()
+	err = wc.send(m)
+	if err != nil {
+		wc.drop(key, ch)
+		return nil, err
+	}
+	select {
+	case reply := <-ch:
+		if reply.FailureReason != "" {
+			return nil, &FailureError{Reason: reply.FailureReason}
+		}
+		return reply, nil
+	case <-wc.done:
+		wc.drop(key, ch)
+		wc.mu.Lock()
+		defer wc.mu.Unlock()
+		return nil, wc.err
+	case <-ctx.Done():
+		wc.drop(key, ch)
+		return nil, ctx.Err()
+	}
+}
+
+// conn returns the open connection to announce, dialing one if there is
+// none or the last one broke. The dial happens outside c.mu, with the
+// connection listed meanwhile so concurrent calls wait for it.
+func (c *WebSocketClient) conn(ctx context.Context, announce string) (*wsConn, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("%w %q", ErrScheme, u.Scheme)
+	}
+	for {
+		c.mu.Lock()
+		wc, ok := c.conns[announce]
+		if ok {
+			select {
+			case <-wc.done:
+				ok = false
+			default:
+			}
+		}
+		if !ok {
+			wc = &wsConn{dialed: make(chan struct{}), done: make(chan struct{}), waiters: make(map[string][]chan *webtorrent.Message)}
+			if c.conns == nil {
+				c.conns = make(map[string]*wsConn)
+			}
+			c.conns[announce] = wc
+			c.mu.Unlock()
+			return c.dial(ctx, announce, wc)
+		}
+		c.mu.Unlock()
+		select {
+		case <-wc.dialed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if wc.conn != nil {
+			return wc, nil
+		}
+		// the dial failed, maybe for the context of its caller: try again
+	}
+}
+
+// dial connects wc, listed in c.conns for announce, and starts reading.
+func (c *WebSocketClient) dial(ctx context.Context, announce string, wc *wsConn) (*wsConn, error) {
+	conn, err := websocket.Dial(ctx, announce, c.TLSConfig)
+	c.mu.Lock()
+	listed := c.conns[announce] == wc
+	if err != nil && listed {
+		delete(c.conns, announce)
+	}
+	c.mu.Unlock()
+	if err == nil && !listed {
+		// the client was closed meanwhile
+		conn.Close()
+		err = net.ErrClosed
+	}
+	if err != nil {
+		close(wc.dialed)
+		return nil, err
+	}
+	wc.conn = conn
+	close(wc.dialed)
+	go c.read(announce, wc)
+	return wc, nil
+}
+
+// read hands every message of wc to its waiter or to OnSignal until the
+// connection fails.
+func (c *WebSocketClient) read(announce string, wc *wsConn) {
+	defer close(wc.done)
+	for {
+		data, err := wc.conn.ReadMessage()
+		if err != nil {
+			wc.mu.Lock()
+			wc.err = fmt.Errorf("websocket tracker connection lost: %w", err)
+			wc.mu.Unlock()
+			wc.conn.Close()
+			return
+		}
+		var m webtorrent.Message
+		if json.Unmarshal(data, &m) != nil {
+			continue
+		}
+		if m.Offer != nil || m.Answer != nil {
+			c.signal(announce, &m)
+			continue
+		}
+		key := replyKey(&m)
+		wc.mu.Lock()
+		if waiters := wc.waiters[key]; len(waiters) > 0 {
+			waiters[0] <- &m
+			wc.waiters[key] = waiters[1:]
+		}
+		wc.mu.Unlock()
+	}
+}
+
+func (c *WebSocketClient) signal(announce string, m *webtorrent.Message) {
+	if c.OnSignal == nil || len(m.InfoHash) != 1 ||
+		len(m.InfoHash[0]) != len(metainfo.InfoHash{}) || len(m.PeerID) != len(PeerID{}) {
+		return
+	}
+	c.OnSignal(announce, &Signal{
+		InfoHash: metainfo.InfoHash([]byte(m.InfoHash[0])),
+		PeerID:   PeerID([]byte(m.PeerID)),
+		OfferID:  string(m.OfferID),
+		Offer:    m.Offer,
+		Answer:   m.Answer,
+	})
+}
+
+func (wc *wsConn) send(m *webtorrent.Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return wc.conn.WriteMessage(data)
+}
+
+func (wc *wsConn) drop(key string, ch chan *webtorrent.Message) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	waiters := wc.waiters[key]
+	for i, w := range waiters {
+		if w == ch {
+			wc.waiters[key] = append(waiters[:i:i], waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// replyKey pairs a reply with its request: announces by info hash, and
+// scrapes, whose replies carry none, by arrival order.
+func replyKey(m *webtorrent.Message) string {
+	if m.Action == "announce" && len(m.InfoHash) == 1 {
+		return "announce " + string(m.InfoHash[0])
+	}
+	return m.Action
+}
@@ -0,0 +1,228 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MysticalDevil/go_bittorrent/metainfo"
+	"github.com/MysticalDevil/go_bittorrent/tracker/internal/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wsStandIn is a WebSocket tracker on localhost that passes every message
+// to handle and sends back the replies it returns.
+type wsStandIn struct {
+	*httptest.Server
+	received chan map[string]any
+}
+
+func newWSStandIn(t *testing.T, handle func(m map[string]any) []string) *wsStandIn {
+	s := &wsStandIn{received: make(chan map[string]any, 16)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		for {
+			data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var m map[string]any
+			if !assert.NoError(t, json.Unmarshal(data, &m)) {
+				return
+			}
+			s.received <- m
+			for _, reply := range handle(m) {
+				if reply == "" {
+					return // drop the connection
+				}
+				conn.WriteMessage([]byte(reply))
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *wsStandIn) url() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/announce"
+}
+
+func TestWebSocketAnnounce(t *testing.T) {
+	h := metainfo.InfoHash{0xaa, 'x'}
+	hash := `"ªx\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000"`
+	from := `"-WW0001-` + strings.Repeat("b", 12) + `"`
+	s := newWSStandIn(t, func(m map[string]any) []string {
+		if m["answer"] != nil {
+			return nil
+		}
+		return []string{
+			`{"action":"announce","info_hash":` + hash + `,"peer_id":` + from +
+				`,"offer_id":"o-from-b","offer":{"type":"offer","sdp":"b"}}`,
+			`{"action":"announce","info_hash":` + hash + `,"interval":120,"complete":3,"incomplete":4}`,
+		}
+	})
+	signals := make(chan *Signal, 1)
+	c := &WebSocketClient{OnSignal: func(announce string, sig *Signal) {
+		assert.Equal(t, s.url(), announce)
+		signals <- sig
+	}}
+	defer c.Close()
+	ctx := context.Background()
+	req := &AnnounceRequest{InfoHash: h, PeerID: PeerID([]byte("-GO0001-aaaaaaaaaaaa")), Left: 10, Event: Started}
+
+	res, err := c.AnnounceOffers(ctx, s.url(), req, []Offer{
+		{ID: "o1", Offer: json.RawMessage(`{"type":"offer","sdp":"1"}`)},
+		{ID: "o2", Offer: json.RawMessage(`{"type":"offer","sdp":"2"}`)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &AnnounceResponse{Interval: 2 * time.Minute, Seeders: 3, Leechers: 4}, res)
+	m := <-s.received
+	assert.Equal(t, "announce", m["action"])
+	assert.Equal(t, "ªx"+strings.Repeat("\x00", 18), m["info_hash"])
+	assert.Equal(t, "started", m["event"])
+	assert.Equal(t, 2.0, m["numwant"])
+	assert.Equal(t, 10.0, m["left"])
+	assert.Equal(t, []any{
+		map[string]any{"offer": map[string]any{"type": "offer", "sdp": "1"}, "offer_id": "o1"},
+		map[string]any{"offer": map[string]any{"type": "offer", "sdp": "2"}, "offer_id": "o2"},
+	}, m["offers"])
+
+	sig := <-signals
+	assert.Equal(t, &Signal{
+		InfoHash: h,
+		PeerID:   PeerID([]byte("-WW0001-bbbbbbbbbbbb")),
+		OfferID:  "o-from-b",
+		Offer:    json.RawMessage(`{"type":"offer","sdp":"b"}`),
+	}, sig)
+	require.NoError(t, c.Answer(ctx, s.url(), req.PeerID, sig, json.RawMessage(`{"type":"answer","sdp":"a"}`)))
+	m = <-s.received
+	assert.Equal(t, "-WW0001-bbbbbbbbbbbb", m["to_peer_id"])
+	assert.Equal(t, "o-from-b", m["offer_id"])
+	assert.Equal(t, map[string]any{"type": "answer", "sdp": "a"}, m["answer"])
+}
+
+func TestWebSocketScrape(t *testing.T) {
+	a, b := metainfo.InfoHash{0xaa}, metainfo.InfoHash{0xbb}
+	s := newWSStandIn(t, func(m map[string]any) []string {
+		if m["info_hash"] == nil {
+			return []string{`{"action":"scrape","failure reason":"name a torrent"}`}
+		}
+		return []string{`{"action":"scrape","files":{` +
+			`"ª\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000\u0000":{"complete":1,"incomplete":2,"downloaded":3},` +
+			`"short":{"complete":1,"incomplete":1,"downloaded":1}}}`}
+	})
+	c := &WebSocketClient{}
+	defer c.Close()
+	ctx := context.Background()
+
+	res, err := c.Scrape(ctx, s.url(), []metainfo.InfoHash{a, b})
+	require.NoError(t, err)
+	assert.Equal(t, map[metainfo.InfoHash]ScrapeStats{a: {Seeders: 1, Leechers: 2, Downloaded: 3}}, res.Files)
+	m := <-s.received
+	assert.Equal(t, []any{"ª" + strings.Repeat("\x00", 19), "»" + strings.Repeat("\x00", 19)}, m["info_hash"])
+
+	_, err = c.Scrape(ctx, s.url(), nil)
+	var fe *FailureError
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, "name a torrent", fe.Reason)
+
+	_, err = c.Scrape(ctx, "http://example.com/announce", nil)
+	assert.ErrorIs(t, err, ErrScheme)
+}
+
+func TestWebSocketRedial(t *testing.T) {
+	n := 0
+	s := newWSStandIn(t, func(m map[string]any) []string {
+		n++
+		if n == 1 {
+			return []string{""}
+		}
+		return []string{`{"action":"scrape","files":{}}`}
+	})
+	c := &WebSocketClient{}
+	defer c.Close()
+	ctx := context.Background()
+
+	// the tracker hanging up fails what was waiting on it, and the next
+	// request dials again
+	_, err := c.Scrape(ctx, s.url(), nil)
+	assert.Error(t, err)
+	_, err = c.Scrape(ctx, s.url(), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.Scrape(ctx, "ws://"+s.Listener.Addr().String()+"/other", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestWebSocketDialUnlocked(t *testing.T) {
+	s := newWSStandIn(t, func(m map[string]any) []string {
+		return []string{`{"action":"scrape","files":{}}`}
+	})
+	// a tracker that accepts and never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	c := &WebSocketClient{}
+	ctx := context.Background()
+
+	hung := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		_, err := c.Scrape(ctx, "ws://"+ln.Addr().String()+"/announce", nil)
+		hung <- err
+	}()
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.conns) == 1
+	}, time.Second, time.Millisecond)
+	// other trackers do not wait on that dial, and concurrent requests to
+	// one tracker share a connection
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Scrape(ctx, s.url(), nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	select {
+	case err := <-hung:
+		t.Fatalf("the hung dial ended early: %v", err)
+	default:
+	}
+	c.mu.Lock()
+	assert.Len(t, c.conns, 2)
+	c.mu.Unlock()
+
+	require.NoError(t, c.Close())
+	assert.ErrorIs(t, <-hung, context.DeadlineExceeded)
+	c.mu.Lock()
+	assert.Empty(t, c.conns)
+	c.mu.Unlock()
+}